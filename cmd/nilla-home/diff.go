@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+
+	"github.com/arnarg/nilla-utils/internal/cache"
+	"github.com/arnarg/nilla-utils/internal/compare"
+	"github.com/arnarg/nilla-utils/internal/diff"
+	"github.com/arnarg/nilla-utils/internal/exec"
+	"github.com/arnarg/nilla-utils/internal/project"
+	"github.com/arnarg/nilla-utils/internal/util"
+	"github.com/urfave/cli/v3"
+)
+
+var diffDescription = `<from> <to>  Each side of the diff can be one of:
+        - a home-manager generation ID of the current user, e.g. "42", or "current"
+        - a store path or a link to one, e.g. "./result"
+        - a host and a generation ID on that host, e.g. "user@server:42" or "user@server:current"
+        - the name of a home-manager configuration in the project, which is built first`
+
+func diffGenerations(ctx context.Context, cmd *cli.Command) error {
+	// Setup logger
+	util.InitLogger(cmd.Bool("verbose"))
+	cache.SetRefresh(cmd.Bool("refresh"))
+
+	local := exec.NewLocalExecutor()
+
+	// The project is only resolved when a configuration is built
+	var source *project.ProjectSource
+
+	resolver := &compare.Resolver{
+		Build: func(ctx context.Context, name string) (string, error) {
+			if source == nil {
+				s, err := project.Resolve(cmd.String("project"))
+				if err != nil {
+					return "", err
+				}
+				source = s
+			}
+
+			name, err := findHomeConfiguration(source, []string{name})
+			if err != nil {
+				return "", err
+			}
+
+			deployment, err := source.LoadDeployment("home", name)
+			if err != nil {
+				return "", err
+			}
+
+			// Any sub command other than build does not create a result link
+			return buildConfiguration(ctx, cmd, subCmdSwitch, source, local, name, deployment)
+		},
+		GenerationPath: func(executor exec.Executor, id int) (string, error) {
+			// Generation links are next to the home-manager profile
+			current, err := currentGenerationPath(executor)
+			if err != nil {
+				return "", err
+			}
+
+			if id > 0 {
+				return filepath.Join(filepath.Dir(current), fmt.Sprintf("home-manager-%d-link", id)), nil
+			}
+			return current, nil
+		},
+		ConfigTree: diff.HomeConfigTree,
+	}
+
+	return resolver.Run(ctx, cmd)
+}
@@ -3,18 +3,22 @@ package main
 import (
 	"cmp"
 	"context"
+	"errors"
 	"fmt"
 	"os"
-	"os/exec"
+	gexec "os/exec"
 	"slices"
 	"strconv"
 	"time"
 
+	"github.com/arnarg/nilla-utils/internal/diff"
+	"github.com/arnarg/nilla-utils/internal/exec"
 	"github.com/arnarg/nilla-utils/internal/generation"
 	"github.com/arnarg/nilla-utils/internal/tui"
 	"github.com/arnarg/nilla-utils/internal/util"
 	"github.com/charmbracelet/lipgloss"
 	"github.com/urfave/cli/v3"
+	"golang.org/x/term"
 )
 
 func sortGenerationsDesc(generations []*generation.HomeGeneration) {
@@ -39,8 +43,16 @@ func listGenerations(ctx context.Context, cmd *cli.Command) error {
 	// Sort the list in reverse by ID
 	sortGenerationsDesc(generations)
 
+	// Only show specialisation column if any generation has one
+	showSpec := slices.ContainsFunc(generations, func(gen *generation.HomeGeneration) bool {
+		return gen.Specialisation != ""
+	})
+
 	// Build table
 	headers := []string{"Generation", "Build date", "Home Manager version"}
+	if showSpec {
+		headers = append(headers, "Specialisation")
+	}
 	rows := [][]string{}
 	for _, gen := range generations {
 		pre := " "
@@ -52,11 +64,16 @@ func listGenerations(ctx context.Context, cmd *cli.Command) error {
 				String()
 		}
 
-		rows = append(rows, []string{
+		row := []string{
 			fmt.Sprintf("%s %d", pre, gen.ID),
 			gen.BuildDate.Format(time.DateTime),
 			gen.Version,
-		})
+		}
+		if showSpec {
+			row = append(row, gen.Specialisation)
+		}
+
+		rows = append(rows, row)
 	}
 
 	fmt.Println(util.RenderTable(headers, rows...))
@@ -106,9 +123,52 @@ func cleanGenerations(ctx context.Context, cmd *cli.Command) error {
 			remaining -= 1
 		}
 
+		// Pinned generations are always kept
+		if generation.IsPinned(gen.Path()) {
+			doKeep = true
+		}
+
 		actions = append(actions, genAction{gen, doKeep})
 	}
 
+	//
+	// Display plan
+	//
+	util.PrintSection("Plan")
+	fmt.Fprintln(os.Stderr, planTable(actions, current))
+
+	//
+	// Ask Confirmation
+	//
+	if !cmd.Bool("confirm") {
+		doContinue, err := tui.RunConfirm("Do you want to continue?")
+		if err != nil {
+			return err
+		}
+		if !doContinue {
+			return nil
+		}
+	}
+
+	//
+	// Delete generation links
+	//
+	for _, action := range actions {
+		if !action.keep {
+			if err := action.generation.Delete(); err != nil {
+				return err
+			}
+		}
+	}
+
+	//
+	// Collect garbage
+	//
+	fmt.Fprintln(os.Stderr)
+	return collectGarbage(ctx)
+}
+
+func planTable(actions []genAction, current *generation.HomeGeneration) string {
 	// Build plan table
 	headers := []string{"Generation", "Build date", "Home Manager version"}
 	rows := [][]string{}
@@ -143,11 +203,101 @@ func cleanGenerations(ctx context.Context, cmd *cli.Command) error {
 		})
 	}
 
+	return util.RenderTable(headers, rows...)
+}
+
+func collectGarbage(ctx context.Context) error {
+	util.PrintSection("Collecting garbage from nix store")
+
+	gc := gexec.CommandContext(ctx, "nix", "store", "gc", "-v")
+	gc.Stdout = os.Stderr
+	gc.Stderr = os.Stderr
+
+	return gc.Run()
+}
+
+func rollbackGeneration(ctx context.Context, cmd *cli.Command) error {
+	// Get current generation
+	current, err := generation.CurrentHomeGeneration()
+	if err != nil {
+		return err
+	}
+
+	// List all generations
+	generations, err := generation.ListHomeGenerations()
+	if err != nil {
+		return err
+	}
+
+	// Sort the list in reverse by ID
+	sortGenerationsDesc(generations)
+
+	// Find the newest generation older than the current one
+	for _, gen := range generations {
+		if gen.ID < current.ID {
+			return activateGeneration(ctx, cmd, current, gen)
+		}
+	}
+
+	return errors.New("no generation older than the current one found")
+}
+
+func switchGeneration(ctx context.Context, cmd *cli.Command) error {
+	// Parse generation ID
+	if cmd.Args().Len() < 1 {
+		return errors.New("generation ID is required")
+	}
+	id, err := strconv.Atoi(cmd.Args().First())
+	if err != nil {
+		return fmt.Errorf("invalid generation ID \"%s\"", cmd.Args().First())
+	}
+
+	// Get current generation
+	current, err := generation.CurrentHomeGeneration()
+	if err != nil {
+		return err
+	}
+
+	// List all generations
+	generations, err := generation.ListHomeGenerations()
+	if err != nil {
+		return err
+	}
+
+	// Find requested generation
+	for _, gen := range generations {
+		if gen.ID == id {
+			return activateGeneration(ctx, cmd, current, gen)
+		}
+	}
+
+	return fmt.Errorf("generation %d not found", id)
+}
+
+func activateGeneration(ctx context.Context, cmd *cli.Command, current, gen *generation.HomeGeneration) error {
+	if gen.ID == current.ID {
+		return fmt.Errorf("generation %d is already the current generation", gen.ID)
+	}
+
+	executor := exec.NewLocalExecutor()
+
 	//
-	// Display plan
+	// Run generation diff
 	//
-	printSection("Plan")
-	fmt.Fprintln(os.Stderr, util.RenderTable(headers, rows...))
+	util.PrintSection(fmt.Sprintf("Comparing changes (%d -> %d)", current.ID, gen.ID))
+
+	if err := diff.Execute(
+		&diff.Generation{
+			Path:     current.Path(),
+			Executor: executor,
+		},
+		&diff.Generation{
+			Path:     gen.Path(),
+			Executor: executor,
+		},
+	); err != nil {
+		return err
+	}
 
 	//
 	// Ask Confirmation
@@ -162,14 +312,127 @@ func cleanGenerations(ctx context.Context, cmd *cli.Command) error {
 		}
 	}
 
+	//
+	// Activate generation
+	//
+	fmt.Fprintln(os.Stderr)
+	util.PrintSection(fmt.Sprintf("Activating generation %d", gen.ID))
+
+	activate := gexec.CommandContext(ctx, fmt.Sprintf("%s/activate", gen.Path()))
+	activate.Stdin = os.Stdin
+	activate.Stdout = os.Stdout
+	activate.Stderr = os.Stderr
+
+	return activate.Run()
+}
+
+func browseGenerations(ctx context.Context, cmd *cli.Command) error {
+	// The browser is only available in an interactive terminal
+	if !term.IsTerminal(int(os.Stdin.Fd())) || !term.IsTerminal(int(os.Stderr.Fd())) {
+		return cli.ShowSubcommandHelp(cmd)
+	}
+
+	// Get current generation
+	current, err := generation.CurrentHomeGeneration()
+	if err != nil {
+		return err
+	}
+
+	// List all generations
+	generations, err := generation.ListHomeGenerations()
+	if err != nil {
+		return err
+	}
+
+	// Sort the list in reverse by ID
+	sortGenerationsDesc(generations)
+
+	// Only show specialisation column if any generation has one
+	showSpec := slices.ContainsFunc(generations, func(gen *generation.HomeGeneration) bool {
+		return gen.Specialisation != ""
+	})
+
+	headers := []string{"Home Manager version"}
+	if showSpec {
+		headers = append(headers, "Specialisation")
+	}
+
+	executor := exec.NewLocalExecutor()
+	byID := map[int]*generation.HomeGeneration{}
+	items := []*tui.GenerationItem{}
+	for _, gen := range generations {
+		details := []string{gen.Version}
+		if showSpec {
+			details = append(details, gen.Specialisation)
+		}
+
+		byID[gen.ID] = gen
+		items = append(items, &tui.GenerationItem{
+			ID:        gen.ID,
+			BuildDate: gen.BuildDate,
+			Details:   details,
+			Current:   gen.ID == current.ID,
+			Pinned:    generation.IsPinned(gen.Path()),
+			Generation: &diff.Generation{
+				Path:     gen.Path(),
+				Executor: executor,
+			},
+		})
+	}
+
+	result, err := tui.RunGenerationBrowser(tui.GenerationBrowser{
+		Title:       "Home Manager generations",
+		Headers:     headers,
+		Generations: items,
+		SetPinned: func(item *tui.GenerationItem, pinned bool) error {
+			return generation.SetPinned(item.Generation.Path, pinned)
+		},
+	})
+	if err != nil || result == nil {
+		return err
+	}
+
+	if result.Activate != nil {
+		return activateGeneration(ctx, cmd, current, byID[result.Activate.ID])
+	}
+
+	if len(result.Delete) < 1 {
+		return nil
+	}
+
+	//
+	// Display plan
+	//
+	actions := []genAction{}
+	for _, gen := range generations {
+		actions = append(actions, genAction{
+			generation: gen,
+			keep: !slices.ContainsFunc(result.Delete, func(item *tui.GenerationItem) bool {
+				return item.ID == gen.ID
+			}),
+		})
+	}
+
+	util.PrintSection("Plan")
+	fmt.Fprintln(os.Stderr, planTable(actions, current))
+
+	//
+	// Ask Confirmation
+	//
+	doContinue, err := tui.RunConfirm("Do you want to continue?")
+	if err != nil {
+		return err
+	}
+	if !doContinue {
+		return nil
+	}
+
 	//
 	// Delete generation links
 	//
-	for _, action := range actions {
-		if !action.keep {
-			if err := action.generation.Delete(); err != nil {
-				return err
-			}
+	for _, item := range result.Delete {
+		if err := byID[item.ID].Delete(); err != nil {
+			return err
 		}
 	}
 
@@ -177,11 +440,5 @@ func cleanGenerations(ctx context.Context, cmd *cli.Command) error {
 	// Collect garbage
 	//
 	fmt.Fprintln(os.Stderr)
-	printSection("Collecting garbage from nix store")
-
-	gc := exec.CommandContext(ctx, "nix", "store", "gc", "-v")
-	gc.Stdout = os.Stderr
-	gc.Stderr = os.Stderr
-
-	return gc.Run()
+	return collectGarbage(ctx)
 }
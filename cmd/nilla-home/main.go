@@ -1,14 +1,16 @@
 package main
 
 import (
-	"bytes"
 	"context"
 	"errors"
 	"fmt"
 	"os"
-	gexec "os/exec"
+	"slices"
 	"strings"
 
+	"github.com/arnarg/nilla-utils/internal/cache"
+	"github.com/arnarg/nilla-utils/internal/check"
+	"github.com/arnarg/nilla-utils/internal/compare"
 	"github.com/arnarg/nilla-utils/internal/diff"
 	"github.com/arnarg/nilla-utils/internal/exec"
 	"github.com/arnarg/nilla-utils/internal/generation"
@@ -49,7 +51,7 @@ var app = &cli.Command{
 	Usage:           "A nilla cli plugin to work with home-manager configurations.",
 	HideVersion:     true,
 	HideHelpCommand: true,
-	Flags: []cli.Flag{
+	Flags: append([]cli.Flag{
 		&cli.BoolFlag{
 			Name:        "version",
 			Aliases:     []string{"V"},
@@ -69,6 +71,29 @@ var app = &cli.Command{
 			Usage:   "The nilla project to use",
 			Value:   "./",
 		},
+		&cli.BoolFlag{
+			Name:        "refresh",
+			Usage:       "Ignore cached project resolution and evaluation results",
+			HideDefault: true,
+		},
+		&cli.StringFlag{
+			Name:  "record-progress",
+			Usage: "Record nix's progress output to `file` for debugging",
+		},
+		&cli.BoolFlag{
+			Name:        "stats",
+			Usage:       "Print build statistics and the slowest builds after building",
+			HideDefault: true,
+		},
+		&cli.StringFlag{
+			Name:  "stats-json",
+			Usage: "Write build statistics as JSON to `file`",
+		},
+	}, compare.Flags()...),
+	Before: func(ctx context.Context, cmd *cli.Command) (context.Context, error) {
+		nix.SetRecordProgress(cmd.String("record-progress"))
+
+		return compare.Configure(ctx, cmd)
 	},
 	Commands: []*cli.Command{
 		// Build
@@ -91,6 +116,10 @@ var app = &cli.Command{
 					Aliases: []string{"o"},
 					Usage:   "Use path as prefix for the symlinks to the build results",
 				},
+				&cli.StringFlag{
+					Name:  "build-host",
+					Usage: "Host to build the configuration on",
+				},
 			},
 			Action: actionFuncFor(subCmdBuild),
 		},
@@ -107,6 +136,20 @@ var app = &cli.Command{
 					Aliases: []string{"c"},
 					Usage:   "Do not ask for confirmation",
 				},
+				&cli.StringFlag{
+					Name:    "target",
+					Aliases: []string{"t"},
+					Usage:   "Target host and user to update",
+				},
+				&cli.StringFlag{
+					Name:    "specialisation",
+					Aliases: []string{"s"},
+					Usage:   "Activate the specialisation with this name",
+				},
+				&cli.StringFlag{
+					Name:  "build-host",
+					Usage: "Host to build the configuration on",
+				},
 			},
 			Action: actionFuncFor(subCmdSwitch),
 		},
@@ -120,12 +163,44 @@ var app = &cli.Command{
 			Action:      listConfigurations,
 		},
 
+		// Check
+		{
+			Name:        "check",
+			Usage:       "Evaluate and optionally build every Home Manager configuration in project",
+			Description: "Evaluate and optionally build every Home Manager configuration in project.\n\n[name]  Names of configurations to check, \"@<tag>\" selects every configuration with that tag. If left empty every configuration is checked.",
+			ArgsUsage:   "[name...]",
+			Flags: []cli.Flag{
+				&cli.BoolFlag{
+					Name:  "build",
+					Usage: "Build configurations after evaluating them",
+				},
+				&cli.IntFlag{
+					Name:    "jobs",
+					Aliases: []string{"j"},
+					Usage:   "Number of configurations to check concurrently",
+					Value:   4,
+				},
+			},
+			Action: checkConfigurations,
+		},
+
+		// Diff
+		{
+			Name:        "diff",
+			Usage:       "Compare the packages of two home-manager generations",
+			Description: fmt.Sprintf("Compare the packages of two home-manager generations.\n\n%s", diffDescription),
+			ArgsUsage:   "<from> <to>",
+			Flags:       compare.CommandFlags("Compare home files and systemd user units"),
+			Action:      diffGenerations,
+		},
+
 		// Generations
 		{
 			Name:        "generations",
 			Aliases:     []string{"gen"},
 			Usage:       "Work with home-manager generations",
-			Description: "Work with home-manager generations",
+			Description: "Work with home-manager generations.\n\nWithout a subcommand an interactive generation browser is opened.",
+			Action:      browseGenerations,
 			Commands: []*cli.Command{
 				// List
 				{
@@ -157,7 +232,57 @@ var app = &cli.Command{
 					},
 					Action: cleanGenerations,
 				},
+
+				// Rollback
+				{
+					Name:        "rollback",
+					Usage:       "Activate the previous home-manager generation",
+					Description: "Activate the home-manager generation preceding the current one",
+					Flags: []cli.Flag{
+						&cli.BoolFlag{
+							Name:    "confirm",
+							Aliases: []string{"c"},
+							Usage:   "Do not ask for confirmation",
+						},
+					},
+					Action: rollbackGeneration,
+				},
+
+				// Switch
+				{
+					Name:        "switch",
+					Usage:       "Activate a specific home-manager generation",
+					Description: "Activate a specific home-manager generation.\n\n<id>  ID of the generation to activate.",
+					ArgsUsage:   "<id>",
+					Flags: []cli.Flag{
+						&cli.BoolFlag{
+							Name:    "confirm",
+							Aliases: []string{"c"},
+							Usage:   "Do not ask for confirmation",
+						},
+					},
+					Action: switchGeneration,
+				},
+			},
+		},
+		// Replay
+		{
+			Name:      "replay",
+			Usage:     "Replay a progress recording",
+			ArgsUsage: "<file>",
+			Hidden:    true,
+			Flags: []cli.Flag{
+				&cli.FloatFlag{
+					Name:  "speed",
+					Usage: "Replay speed factor, 0 replays without delay",
+					Value: 1,
+				},
+				&cli.BoolFlag{
+					Name:  "copy",
+					Usage: "Replay with the copy reporter instead of the build reporter",
+				},
 			},
+			Action: replayProgress,
 		},
 	},
 	Action: func(ctx context.Context, cmd *cli.Command) error {
@@ -171,20 +296,19 @@ var app = &cli.Command{
 	},
 }
 
-func printSection(text string) {
-	fmt.Fprintf(os.Stderr, "\033[32m>\033[0m %s\n", text)
-}
-
-func inferNames(name string) ([]string, error) {
+func inferNames(name string, target exec.Executor) ([]string, error) {
 	if name == "" {
 		names := []string{}
 
-		user := util.GetUser()
+		user, hn, err := identity(target)
+		if err != nil {
+			return nil, err
+		}
 		if user == "" {
 			return nil, errNoUserFound
 		}
 
-		if hn, err := os.Hostname(); err == nil {
+		if hn != "" {
 			names = append(names, fmt.Sprintf("%s@%s", user, hn))
 		}
 
@@ -193,25 +317,118 @@ func inferNames(name string) ([]string, error) {
 	return []string{name}, nil
 }
 
-func findHomeConfiguration(p string, names []string) (string, error) {
+func identity(target exec.Executor) (string, string, error) {
+	if target.IsLocal() {
+		hn, _ := os.Hostname()
+		return util.GetUser(), hn, nil
+	}
+
+	// Ask the remote host who we are
+	user, err := exec.Output(target, "id", "-un")
+	if err != nil {
+		return "", "", err
+	}
+	hn, err := exec.Output(target, "hostname")
+	if err != nil {
+		return "", "", err
+	}
+
+	return strings.TrimSpace(string(user)), strings.TrimSpace(string(hn)), nil
+}
+
+func findHomeConfiguration(source *project.ProjectSource, names []string) (string, error) {
+	// Get a list of home systems
+	systems, err := nix.ListAttrsInProject(source.NillaPath, source.FixedOutputStoreEntry(), "systems.home")
+	if err != nil {
+		return "", err
+	}
+
 	for _, name := range names {
-		code := fmt.Sprintf("x: x ? \"%s\"", name)
-		out, err := gexec.Command(
-			"nix", "eval", "-f", p, "systems.home", "--apply", code,
-		).Output()
-		if err != nil {
-			continue
-		}
-		if string(bytes.TrimSpace(out)) == "true" {
+		if slices.Contains(systems, name) {
 			return name, nil
 		}
 	}
 	return "", fmt.Errorf("Home configurations \"%s\" not found", strings.Join(names, ", "))
 }
 
+func currentGenerationPath(target exec.Executor) (string, error) {
+	if target.IsLocal() {
+		current, err := generation.CurrentHomeGeneration()
+		if err != nil {
+			return "", err
+		}
+		return current.Path(), nil
+	}
+
+	return generation.CurrentHomeGenerationPath(target)
+}
+
+// buildConfiguration builds the activation package of the home configuration.
+func buildConfiguration(ctx context.Context, cmd *cli.Command, sc subCmd, source *project.ProjectSource, builder exec.Executor, name string, deployment *project.Deployment) (string, error) {
+	// Attribute of home-manager's activation package
+	attr := fmt.Sprintf("systems.home.\"%s\".result.config.home.activationPackage", name)
+
+	// Check if attribute exists
+	exists, err := nix.ExistsInProject(source.NillaPath, source.FixedOutputStoreEntry(), attr)
+	if err != nil {
+		return "", err
+	}
+	if !exists {
+		if source.IsFlake() {
+			return "", fmt.Errorf("Configuration \"%s\" does not exist in homeConfigurations of flake \"%s\"", name, source.FlakePath)
+		}
+		return "", fmt.Errorf("Attribute '%s' does not exist in project \"%s\"", attr, source.FullNillaPath())
+	}
+
+	log.Infof("Found system \"%s\"", name)
+
+	// Build args for nix build
+	nargs := []string{"-f", source.FullNillaPath(), attr}
+
+	// Delegate all builds to the build host, if set
+	if deployment.BuildHost != "" {
+		log.Infof("Building on \"%s\"", deployment.BuildHost)
+		nargs = append(
+			nargs,
+			"--max-jobs", "0",
+			"--builders", fmt.Sprintf("ssh-ng://%s", deployment.BuildHost),
+		)
+	}
+
+	// Add extra args depending on the sub command
+	if sc == subCmdBuild {
+		if deployment.Bool(cmd, "no-link") {
+			nargs = append(nargs, "--no-link")
+		}
+		if outLink := deployment.String(cmd, "out-link"); outLink != "" {
+			nargs = append(nargs, "--out-link", outLink)
+		}
+	} else {
+		// All sub-commands except build should not
+		// create a result link
+		nargs = append(nargs, "--no-link")
+	}
+
+	// Run nix build
+	util.PrintSection("Building configuration")
+	out, err := nix.Command("build").
+		Args(nargs).
+		Executor(builder).
+		Reporter(tui.NewBuildReporter(deployment.Bool(cmd, "verbose")).WithStats(statsOptions(cmd))).
+		Run(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	return string(out), nil
+}
+
 func run(ctx context.Context, cmd *cli.Command, sc subCmd) error {
+	var builder, target exec.Executor
+
 	// Setup logger
 	util.InitLogger(cmd.Bool("verbose"))
+	cache.SetRefresh(cmd.Bool("refresh"))
 
 	// Resolve project
 	source, err := project.Resolve(cmd.String("project"))
@@ -219,88 +436,117 @@ func run(ctx context.Context, cmd *cli.Command, sc subCmd) error {
 		return err
 	}
 
-	// Try to find current generation
-	current, err := generation.CurrentHomeGeneration()
+	// Setup builder, which is always local
+	builder = exec.NewLocalExecutor()
+
+	// Load advisories to check the new configuration against
+	advisories, err := compare.LoadAdvisories(cmd)
 	if err != nil {
 		return err
 	}
 
+	//
+	// Setup target executor
+	//
+	if cmd.String("target") != "" {
+		target, err = exec.NewSSHExecutor(cmd.String("target"))
+		if err != nil {
+			return err
+		}
+	} else {
+		target = builder
+	}
+
 	// Try to infer names to try for the home-manager configuration
-	names, err := inferNames(cmd.Args().First())
+	names, err := inferNames(cmd.Args().First(), target)
 	if err != nil {
 		return err
 	}
 
 	// Find home configuration from candidates
-	name, err := findHomeConfiguration(source.FullNillaPath(), names)
+	name, err := findHomeConfiguration(source, names)
 	if err != nil {
 		return err
 	}
 
-	// Attribute of home-manager's activation package
-	attr := fmt.Sprintf("systems.home.\"%s\".result.config.home.activationPackage", name)
-
-	// Check if attribute exists
-	exists, err := nix.ExistsInProject(source.NillaPath, source.FixedOutputStoreEntry(), attr)
+	// Load deployment settings for the configuration, command
+	// line flags take precedence
+	deployment, err := source.LoadDeployment("home", name)
 	if err != nil {
 		return err
 	}
-	if !exists {
-		return fmt.Errorf("Attribute '%s' does not exist in project \"%s\"", attr, source.FullNillaPath())
+	if cmd.String("target") != "" {
+		deployment.Target = cmd.String("target")
+	} else if deployment.Target != "" {
+		target, err = exec.NewSSHExecutor(deployment.Target)
+		if err != nil {
+			return err
+		}
+	}
+	if cmd.String("build-host") != "" {
+		deployment.BuildHost = cmd.String("build-host")
 	}
+	deployment.Confirm = deployment.Confirm || cmd.Bool("confirm")
 
-	log.Infof("Found system \"%s\"", name)
+	if deployment.Target != "" {
+		log.Infof("Deploying to \"%s\"", deployment.Target)
+	}
 
-	// Setup builder, which is always local
-	builder := exec.NewLocalExecutor()
+	// Try to find current generation, there is none before
+	// home-manager is activated for the first time
+	current, err := currentGenerationPath(target)
+	if errors.Is(err, generation.ErrNoCurrentGeneration) {
+		log.Info("No current home-manager generation found, nothing to compare with")
+	} else if err != nil {
+		return err
+	}
 
 	//
 	// Home Manager configuration build
 	//
-	// Build args for nix build
-	nargs := []string{"-f", source.FullNillaPath(), attr}
+	out, err := buildConfiguration(ctx, cmd, sc, source, builder, name, deployment)
+	if err != nil {
+		return err
+	}
 
-	// Add extra args depending on the sub command
-	if sc == subCmdBuild {
-		if cmd.Bool("no-link") {
-			nargs = append(nargs, "--no-link")
+	// The activation package to activate, which is either
+	// the top-level one or one of its specialisations
+	activation := string(out)
+	if spec := deployment.String(cmd, "specialisation"); spec != "" {
+		activation = fmt.Sprintf("%s/specialisation/%s", out, spec)
+
+		exists, err := builder.PathExists(activation)
+		if err != nil {
+			return err
 		}
-		if cmd.String("out-link") != "" {
-			nargs = append(nargs, "--out-link", cmd.String("out-link"))
+		if !exists {
+			return fmt.Errorf("Specialisation \"%s\" does not exist in home configuration \"%s\"", spec, name)
 		}
-	} else {
-		// All sub-commands except build should not
-		// create a result link
-		nargs = append(nargs, "--no-link")
-	}
 
-	// Run nix build
-	printSection("Building configuration")
-	out, err := nix.Command("build").
-		Args(nargs).
-		Reporter(tui.NewBuildReporter(cmd.Bool("verbose"))).
-		Run(ctx)
-	if err != nil {
-		return err
+		log.Infof("Using specialisation \"%s\"", spec)
 	}
 
 	//
-	// Run generation diff using nvd
+	// Compare changes and check for vulnerabilities
 	//
-	fmt.Fprintln(os.Stderr)
-	printSection("Comparing changes")
+	if current != "" {
+		fmt.Fprintln(os.Stderr)
 
-	if err := diff.Execute(
-		&diff.Generation{
-			Path:     current.Path(),
-			Executor: builder,
-		},
-		&diff.Generation{
-			Path:     string(out),
+		from := &diff.Generation{
+			Path:     current,
+			Executor: target,
+		}
+		to := &diff.Generation{
+			Path:     activation,
 			Executor: builder,
-		},
-	); err != nil {
-		return err
+			Name:     name,
+		}
+		if err := compare.Changes(from, to, ""); err != nil {
+			return err
+		}
+		if err := compare.Vulnerabilities(advisories, from, to, ""); err != nil {
+			return err
+		}
 	}
 
 	// Build can exit now
@@ -311,7 +557,7 @@ func run(ctx context.Context, cmd *cli.Command, sc subCmd) error {
 	//
 	// Ask Confirmation
 	//
-	if !cmd.Bool("confirm") {
+	if !deployment.Confirm {
 		doContinue, err := tui.RunConfirm("Do you want to continue?")
 		if err != nil {
 			return err
@@ -321,22 +567,56 @@ func run(ctx context.Context, cmd *cli.Command, sc subCmd) error {
 		}
 	}
 
+	//
+	// Copy closure to target
+	//
+	if deployment.Target != "" {
+		fmt.Fprintln(os.Stderr)
+		util.PrintSection("Copying configuration to target")
+
+		// Copy activation package closure
+		_, err := nix.Command("copy").
+			Args([]string{
+				"--to", fmt.Sprintf("ssh://%s", deployment.Target),
+				string(out),
+			}).
+			Executor(builder).
+			Reporter(tui.NewCopyReporter(deployment.Bool(cmd, "verbose"))).
+			Run(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
 	//
 	// Activate Home Manager configuration
 	//
 	if sc == subCmdSwitch {
 		fmt.Fprintln(os.Stderr)
-		printSection("Activating configuration")
+		util.PrintSection("Activating configuration")
 
-		// Run switch_to_configuration
-		switchp := fmt.Sprintf("%s/activate", out)
-		switchc := gexec.Command(switchp)
-		switchc.Stderr = os.Stderr
-		switchc.Stdout = os.Stdout
+		// Run activate as the target user
+		switchp := fmt.Sprintf("%s/activate", activation)
+		switchc, err := target.Command(switchp)
+		if err != nil {
+			return err
+		}
+
+		switchc.SetStdin(os.Stdin)
+		switchc.SetStderr(os.Stderr)
+		switchc.SetStdout(os.Stdout)
 
 		if err := switchc.Run(); err != nil {
 			return err
 		}
+
+		// Remember which specialisation was activated, this is
+		// only tracked locally
+		if spec := deployment.String(cmd, "specialisation"); spec != "" && target.IsLocal() {
+			if err := generation.RecordHomeSpecialisation(activation, spec); err != nil {
+				log.Warnf("Could not record active specialisation: %s", err)
+			}
+		}
 	}
 
 	return nil
@@ -345,6 +625,7 @@ func run(ctx context.Context, cmd *cli.Command, sc subCmd) error {
 func listConfigurations(ctx context.Context, cmd *cli.Command) error {
 	// Setup logger
 	util.InitLogger(cmd.Bool("verbose"))
+	cache.SetRefresh(cmd.Bool("refresh"))
 
 	// Resolve project
 	source, err := project.Resolve(cmd.String("project"))
@@ -362,7 +643,7 @@ func listConfigurations(ctx context.Context, cmd *cli.Command) error {
 	if len(systems) < 1 {
 		fmt.Println("No Home Manager configurations found")
 	} else {
-		printSection("Home Manager configurations")
+		util.PrintSection("Home Manager configurations")
 		for _, system := range systems {
 			fmt.Printf("- %s\n", system)
 		}
@@ -371,9 +652,85 @@ func listConfigurations(ctx context.Context, cmd *cli.Command) error {
 	return nil
 }
 
+func checkConfigurations(ctx context.Context, cmd *cli.Command) error {
+	// Setup logger
+	util.InitLogger(cmd.Bool("verbose"))
+	cache.SetRefresh(cmd.Bool("refresh"))
+
+	// Resolve project
+	source, err := project.Resolve(cmd.String("project"))
+	if err != nil {
+		return err
+	}
+
+	// Get a list of configurations to check
+	var names []string
+	if cmd.Args().Len() > 0 {
+		names, err = source.SelectSystems("home", cmd.Args().Slice())
+	} else {
+		names, err = nix.ListAttrsInProject(source.NillaPath, source.FixedOutputStoreEntry(), "systems.home")
+	}
+	if err != nil {
+		return err
+	}
+
+	if len(names) < 1 {
+		fmt.Println("No Home Manager configurations found")
+		return nil
+	}
+
+	// Check all configurations
+	util.PrintSection(fmt.Sprintf("Checking %d Home Manager configurations", len(names)))
+	results := check.Run(
+		ctx,
+		source.FullNillaPath(),
+		names,
+		func(name string) string {
+			return fmt.Sprintf("systems.home.\"%s\".result.config.home.activationPackage", name)
+		},
+		check.Options{
+			Build: cmd.Bool("build"),
+			Jobs:  int(cmd.Int("jobs")),
+		},
+	)
+
+	// Print results
+	fmt.Fprintln(os.Stderr)
+	util.PrintSection("Results")
+	fmt.Println(check.Table(results))
+
+	return check.Err(results)
+}
+
+func statsOptions(cmd *cli.Command) tui.StatsOptions {
+	return tui.StatsOptions{
+		Print:    cmd.Bool("stats"),
+		Top:      10,
+		JSONPath: cmd.String("stats-json"),
+	}
+}
+
+func replayProgress(ctx context.Context, cmd *cli.Command) error {
+	if cmd.Args().Len() < 1 {
+		return errors.New("recording file is required")
+	}
+
+	var reporter nix.ProgressReporter = tui.NewBuildReporter(cmd.Bool("verbose")).WithStats(statsOptions(cmd))
+	if cmd.Bool("copy") {
+		reporter = tui.NewCopyReporter(cmd.Bool("verbose"))
+	}
+
+	return tui.Replay(ctx, cmd.Args().First(), cmd.Float("speed"), reporter)
+}
+
 func main() {
-	if err := app.Run(context.Background(), os.Args); err != nil {
-		log.Error(err)
+	err := app.Run(context.Background(), os.Args)
+
+	// Write values cached while running the command
+	cache.Flush()
+
+	if err != nil {
+		tui.PrintError(err)
 		os.Exit(1)
 	}
 }
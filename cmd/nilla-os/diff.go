@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/arnarg/nilla-utils/internal/cache"
+	"github.com/arnarg/nilla-utils/internal/compare"
+	"github.com/arnarg/nilla-utils/internal/diff"
+	"github.com/arnarg/nilla-utils/internal/exec"
+	"github.com/arnarg/nilla-utils/internal/project"
+	"github.com/arnarg/nilla-utils/internal/util"
+	"github.com/urfave/cli/v3"
+)
+
+var diffDescription = `<from> <to>  Each side of the diff can be one of:
+        - a generation ID of the local system, e.g. "42", or "current"
+        - a store path or a link to one, e.g. "./result"
+        - a host and a generation ID on that host, e.g. "root@server:42" or "server:current"
+        - the name of a NixOS system in the project, which is built first`
+
+func diffGenerations(ctx context.Context, cmd *cli.Command) error {
+	// Setup logger
+	util.InitLogger(cmd.Bool("verbose"))
+	cache.SetRefresh(cmd.Bool("refresh"))
+
+	local := exec.NewLocalExecutor()
+
+	// The project is only resolved when a system is built
+	var source *project.ProjectSource
+
+	resolver := &compare.Resolver{
+		Build: func(ctx context.Context, name string) (string, error) {
+			if source == nil {
+				s, err := project.Resolve(cmd.String("project"))
+				if err != nil {
+					return "", err
+				}
+				source = s
+			}
+
+			deployment, err := source.LoadDeployment("nixos", name)
+			if err != nil {
+				return "", err
+			}
+
+			// Any sub command other than build does not create a result link
+			sys := &system{name: name, deployment: deployment}
+			if err := buildSystem(ctx, cmd, subCmdTest, source, local, sys, true); err != nil {
+				return "", err
+			}
+
+			return sys.out, nil
+		},
+		GenerationPath: func(executor exec.Executor, id int) (string, error) {
+			if id > 0 {
+				return fmt.Sprintf("%s-%d-link", SYSTEM_PROFILE, id), nil
+			}
+			return CURRENT_PROFILE, nil
+		},
+		ConfigTree: diff.NixOSConfigTree,
+	}
+
+	return resolver.Run(ctx, cmd)
+}
@@ -5,16 +5,21 @@ import (
 	"context"
 	"fmt"
 	"os"
-	"os/exec"
+	gexec "os/exec"
 	"slices"
 	"strconv"
 	"time"
 
+	"github.com/arnarg/nilla-utils/internal/cache"
+	"github.com/arnarg/nilla-utils/internal/diff"
+	"github.com/arnarg/nilla-utils/internal/exec"
 	"github.com/arnarg/nilla-utils/internal/generation"
+	"github.com/arnarg/nilla-utils/internal/project"
 	"github.com/arnarg/nilla-utils/internal/tui"
 	"github.com/arnarg/nilla-utils/internal/util"
 	"github.com/charmbracelet/lipgloss"
 	"github.com/urfave/cli/v3"
+	"golang.org/x/term"
 )
 
 func sortGenerationsDesc(generations []*generation.NixOSGeneration) {
@@ -24,7 +29,44 @@ func sortGenerationsDesc(generations []*generation.NixOSGeneration) {
 	})
 }
 
+// hasSelectors returns true when systems from the project were
+// selected, instead of working on the local system.
+func hasSelectors(cmd *cli.Command) bool {
+	return cmd.Args().Len() > 0 || len(cmd.StringSlice("tag")) > 0
+}
+
+// selectProjectSystems resolves the project and connects to every
+// system selected by the command.
+func selectProjectSystems(cmd *cli.Command) ([]*system, error) {
+	// Setup logger
+	util.InitLogger(cmd.Bool("verbose"))
+	cache.SetRefresh(cmd.Bool("refresh"))
+
+	// Resolve project
+	source, err := project.Resolve(cmd.String("project"))
+	if err != nil {
+		return nil, err
+	}
+
+	// Select NixOS systems to work on
+	systems, _, err := selectSystems(cmd, source)
+	if err != nil {
+		return nil, err
+	}
+
+	// Setup target executors
+	if err := connect(systems, exec.NewLocalExecutor()); err != nil {
+		return nil, err
+	}
+
+	return systems, nil
+}
+
 func listGenerations(ctx context.Context, cmd *cli.Command) error {
+	if hasSelectors(cmd) {
+		return listProjectGenerations(ctx, cmd)
+	}
+
 	// Get current generation
 	current, err := generation.CurrentNixOSGeneration()
 	if err != nil {
@@ -40,30 +82,62 @@ func listGenerations(ctx context.Context, cmd *cli.Command) error {
 	// Sort the list in reverse by ID
 	sortGenerationsDesc(generations)
 
+	fmt.Println(generationsTable(generations, current))
+
+	return nil
+}
+
+func listProjectGenerations(ctx context.Context, cmd *cli.Command) error {
+	systems, err := selectProjectSystems(cmd)
+	if err != nil {
+		return err
+	}
+
+	for i, sys := range systems {
+		// List all generations on target
+		generations, current, err := generation.ListNixOSGenerationsOn(sys.target)
+		if err != nil {
+			return fmt.Errorf("%s: %w", sys.name, err)
+		}
+
+		// Sort the list in reverse by ID
+		sortGenerationsDesc(generations)
+
+		if i > 0 {
+			fmt.Println()
+		}
+		util.PrintSection(fmt.Sprintf("%s (%s)", sys.name, sys.targetName()))
+		fmt.Println(generationsTable(generations, current))
+	}
+
+	return nil
+}
+
+func currentMarker(gen, current *generation.NixOSGeneration) string {
+	if gen.ID == current.ID {
+		return lipgloss.NewStyle().
+			Foreground(lipgloss.Color("13")).
+			Bold(true).
+			SetString("*").
+			String()
+	}
+	return " "
+}
+
+func generationsTable(generations []*generation.NixOSGeneration, current *generation.NixOSGeneration) string {
 	// Build table
 	headers := []string{"Generation", "Build date", "NixOS version", "Kernel version"}
 	rows := [][]string{}
 	for _, gen := range generations {
-		pre := " "
-		if gen.ID == current.ID {
-			pre = lipgloss.NewStyle().
-				Foreground(lipgloss.Color("13")).
-				Bold(true).
-				SetString("*").
-				String()
-		}
-
 		rows = append(rows, []string{
-			fmt.Sprintf("%s %d", pre, gen.ID),
+			fmt.Sprintf("%s %d", currentMarker(gen, current), gen.ID),
 			gen.BuildDate.Format(time.DateTime),
 			gen.Version,
 			gen.KernelVersion,
 		})
 	}
 
-	fmt.Println(util.RenderTable(headers, rows...))
-
-	return nil
+	return util.RenderTable(headers, rows...)
 }
 
 type genAction struct {
@@ -71,28 +145,10 @@ type genAction struct {
 	keep       bool
 }
 
-func cleanGenerations(ctx context.Context, cmd *cli.Command) error {
-	// We need to self-elevate if we're not root before continuing
-	if !util.IsRoot() {
-		return util.SelfElevate()
-	}
-
-	// Parse parameters
-	keep := cmd.Uint("keep")
+// planClean plans which generations to keep, pinned generations are always kept.
+func planClean(generations []*generation.NixOSGeneration, current *generation.NixOSGeneration, keep uint64, pins map[string]bool) []genAction {
 	foundCurrent := false
 
-	// Get current generation
-	current, err := generation.CurrentNixOSGeneration()
-	if err != nil {
-		return err
-	}
-
-	// List all generations
-	generations, err := generation.ListNixOSGenerations()
-	if err != nil {
-		return err
-	}
-
 	// Sort the list in reverse by ID
 	sortGenerationsDesc(generations)
 
@@ -113,9 +169,17 @@ func cleanGenerations(ctx context.Context, cmd *cli.Command) error {
 			remaining -= 1
 		}
 
+		if pins[gen.Path()] {
+			doKeep = true
+		}
+
 		actions = append(actions, genAction{gen, doKeep})
 	}
 
+	return actions
+}
+
+func planTable(actions []genAction, current *generation.NixOSGeneration) string {
 	// Build plan table
 	headers := []string{"Generation", "Build date", "NixOS version", "Kernel version"}
 	rows := [][]string{}
@@ -123,14 +187,6 @@ func cleanGenerations(ctx context.Context, cmd *cli.Command) error {
 	delStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("9"))
 	for _, action := range actions {
 		gen := action.generation
-		pre := " "
-		if gen.ID == current.ID {
-			pre = lipgloss.NewStyle().
-				Foreground(lipgloss.Color("13")).
-				Bold(true).
-				SetString("*").
-				String()
-		}
 
 		var style lipgloss.Style
 		if action.keep {
@@ -142,7 +198,7 @@ func cleanGenerations(ctx context.Context, cmd *cli.Command) error {
 		rows = append(rows, []string{
 			fmt.Sprintf(
 				"%s %s",
-				pre,
+				currentMarker(gen, current),
 				style.SetString(strconv.Itoa(gen.ID)).String(),
 			),
 			style.SetString(gen.BuildDate.Format(time.DateTime)).String(),
@@ -151,11 +207,45 @@ func cleanGenerations(ctx context.Context, cmd *cli.Command) error {
 		})
 	}
 
+	return util.RenderTable(headers, rows...)
+}
+
+func cleanGenerations(ctx context.Context, cmd *cli.Command) error {
+	if hasSelectors(cmd) {
+		return cleanProjectGenerations(ctx, cmd)
+	}
+
+	// We need to self-elevate if we're not root before continuing
+	if !util.IsRoot() {
+		return util.SelfElevate()
+	}
+
+	// Get current generation
+	current, err := generation.CurrentNixOSGeneration()
+	if err != nil {
+		return err
+	}
+
+	// List all generations
+	generations, err := generation.ListNixOSGenerations()
+	if err != nil {
+		return err
+	}
+
+	// Get pinned generations
+	pins, err := generation.PinsOn(exec.NewLocalExecutor())
+	if err != nil {
+		return err
+	}
+
+	// Make a plan
+	actions := planClean(generations, current, cmd.Uint("keep"), pins)
+
 	//
 	// Display plan
 	//
-	printSection("Plan")
-	fmt.Fprintln(os.Stderr, util.RenderTable(headers, rows...))
+	util.PrintSection("Plan")
+	fmt.Fprintln(os.Stderr, planTable(actions, current))
 
 	//
 	// Ask Confirmation
@@ -185,11 +275,279 @@ func cleanGenerations(ctx context.Context, cmd *cli.Command) error {
 	// Collect garbage
 	//
 	fmt.Fprintln(os.Stderr)
-	printSection("Collecting garbage from nix store")
+	util.PrintSection("Collecting garbage from nix store")
 
-	gc := exec.CommandContext(ctx, "nix", "store", "gc", "-v")
+	gc := gexec.CommandContext(ctx, "nix", "store", "gc", "-v")
 	gc.Stdout = os.Stderr
 	gc.Stderr = os.Stderr
 
 	return gc.Run()
 }
+
+func cleanProjectGenerations(ctx context.Context, cmd *cli.Command) error {
+	systems, err := selectProjectSystems(cmd)
+	if err != nil {
+		return err
+	}
+
+	//
+	// Make and display a plan for every system
+	//
+	plans := map[string][]genAction{}
+	for i, sys := range systems {
+		// List all generations on target
+		generations, current, err := generation.ListNixOSGenerationsOn(sys.target)
+		if err != nil {
+			return fmt.Errorf("%s: %w", sys.name, err)
+		}
+
+		// Get pinned generations on target
+		pins, err := generation.PinsOn(sys.target)
+		if err != nil {
+			return fmt.Errorf("%s: %w", sys.name, err)
+		}
+
+		plans[sys.name] = planClean(generations, current, cmd.Uint("keep"), pins)
+
+		if i > 0 {
+			fmt.Fprintln(os.Stderr)
+		}
+		util.PrintSection(fmt.Sprintf("Plan for %s (%s)", sys.name, sys.targetName()))
+		fmt.Fprintln(os.Stderr, planTable(plans[sys.name], current))
+	}
+
+	//
+	// Ask Confirmation, deleting generations always
+	// needs confirmation regardless of deployment settings
+	//
+	if !cmd.Bool("confirm") {
+		doContinue, err := tui.RunConfirm("Do you want to continue?")
+		if err != nil {
+			return err
+		}
+		if !doContinue {
+			return nil
+		}
+	}
+
+	for _, sys := range systems {
+		//
+		// Delete generation links
+		//
+		links := []string{}
+		for _, action := range plans[sys.name] {
+			if !action.keep {
+				links = append(links, action.generation.Path())
+			}
+		}
+
+		if len(links) > 0 {
+			if err := runOnTarget(ctx, sys, append([]string{"rm", "-f"}, links...)...); err != nil {
+				return fmt.Errorf("%s: %w", sys.name, err)
+			}
+		}
+
+		//
+		// Collect garbage
+		//
+		fmt.Fprintln(os.Stderr)
+		util.PrintSection(fmt.Sprintf("Collecting garbage from nix store (%s)", sys.name))
+
+		if err := runOnTarget(
+			ctx, sys,
+			"nix", "store", "gc", "-v",
+			"--extra-experimental-features", "nix-command",
+		); err != nil {
+			return fmt.Errorf("%s: %w", sys.name, err)
+		}
+	}
+
+	return nil
+}
+
+// runOnTarget runs a privileged command on the target of a system.
+func runOnTarget(ctx context.Context, sys *system, args ...string) error {
+	args = sys.deployment.Elevate(args...)
+
+	c, err := sys.target.CommandContext(ctx, args[0], args[1:]...)
+	if err != nil {
+		return err
+	}
+
+	c.SetStdin(os.Stdin)
+	c.SetStdout(os.Stderr)
+	c.SetStderr(os.Stderr)
+
+	return c.Run()
+}
+
+func browseGenerations(ctx context.Context, cmd *cli.Command) error {
+	// The browser is only available in an interactive terminal
+	if !term.IsTerminal(int(os.Stdin.Fd())) || !term.IsTerminal(int(os.Stderr.Fd())) {
+		return cli.ShowSubcommandHelp(cmd)
+	}
+
+	// Get current generation
+	current, err := generation.CurrentNixOSGeneration()
+	if err != nil {
+		return err
+	}
+
+	// List all generations
+	generations, err := generation.ListNixOSGenerations()
+	if err != nil {
+		return err
+	}
+
+	// Sort the list in reverse by ID
+	sortGenerationsDesc(generations)
+
+	executor := exec.NewLocalExecutor()
+	byID := map[int]*generation.NixOSGeneration{}
+	items := []*tui.GenerationItem{}
+	for _, gen := range generations {
+		byID[gen.ID] = gen
+		items = append(items, &tui.GenerationItem{
+			ID:        gen.ID,
+			BuildDate: gen.BuildDate,
+			Details:   []string{gen.Version, gen.KernelVersion},
+			Current:   gen.ID == current.ID,
+			Pinned:    generation.IsPinned(gen.Path()),
+			Generation: &diff.Generation{
+				Path:     gen.Path(),
+				Executor: executor,
+			},
+		})
+	}
+
+	result, err := tui.RunGenerationBrowser(tui.GenerationBrowser{
+		Title:       "NixOS generations",
+		Headers:     []string{"NixOS version", "Kernel version"},
+		Generations: items,
+		SetPinned: func(item *tui.GenerationItem, pinned bool) error {
+			return generation.SetPinned(item.Generation.Path, pinned)
+		},
+	})
+	if err != nil || result == nil {
+		return err
+	}
+
+	// Privileged commands are run with sudo on the local system
+	elevation := project.ElevationSudo
+	if util.IsRoot() {
+		elevation = project.ElevationNone
+	}
+	hn, _ := os.Hostname()
+	sys := &system{
+		name:       hn,
+		deployment: &project.Deployment{Elevation: elevation},
+		target:     executor,
+	}
+
+	if result.Activate != nil {
+		return activateGeneration(ctx, sys, current, byID[result.Activate.ID])
+	}
+
+	if len(result.Delete) < 1 {
+		return nil
+	}
+
+	//
+	// Display plan
+	//
+	actions := []genAction{}
+	for _, gen := range generations {
+		actions = append(actions, genAction{
+			generation: gen,
+			keep: !slices.ContainsFunc(result.Delete, func(item *tui.GenerationItem) bool {
+				return item.ID == gen.ID
+			}),
+		})
+	}
+
+	util.PrintSection("Plan")
+	fmt.Fprintln(os.Stderr, planTable(actions, current))
+
+	//
+	// Ask Confirmation
+	//
+	doContinue, err := tui.RunConfirm("Do you want to continue?")
+	if err != nil {
+		return err
+	}
+	if !doContinue {
+		return nil
+	}
+
+	//
+	// Delete generation links
+	//
+	links := []string{}
+	for _, item := range result.Delete {
+		links = append(links, byID[item.ID].Path())
+	}
+	if err := runOnTarget(ctx, sys, append([]string{"rm", "-f"}, links...)...); err != nil {
+		return err
+	}
+
+	//
+	// Collect garbage
+	//
+	fmt.Fprintln(os.Stderr)
+	util.PrintSection("Collecting garbage from nix store")
+
+	return runOnTarget(
+		ctx, sys,
+		"nix", "store", "gc", "-v",
+		"--extra-experimental-features", "nix-command",
+	)
+}
+
+// activateGeneration makes gen the current generation of the system
+// profile and switches to it.
+func activateGeneration(ctx context.Context, sys *system, current, gen *generation.NixOSGeneration) error {
+	//
+	// Run generation diff
+	//
+	util.PrintSection(fmt.Sprintf("Comparing changes (%d -> %d)", current.ID, gen.ID))
+
+	if err := diff.Execute(
+		&diff.Generation{
+			Path:     current.Path(),
+			Executor: sys.target,
+		},
+		&diff.Generation{
+			Path:     gen.Path(),
+			Executor: sys.target,
+		},
+	); err != nil {
+		return err
+	}
+
+	//
+	// Ask Confirmation
+	//
+	doContinue, err := tui.RunConfirm("Do you want to continue?")
+	if err != nil {
+		return err
+	}
+	if !doContinue {
+		return nil
+	}
+
+	//
+	// Activate generation
+	//
+	fmt.Fprintln(os.Stderr)
+	util.PrintSection(fmt.Sprintf("Activating generation %d", gen.ID))
+
+	if err := runOnTarget(
+		ctx, sys,
+		"nix-env", "--profile", SYSTEM_PROFILE,
+		"--switch-generation", strconv.Itoa(gen.ID),
+	); err != nil {
+		return err
+	}
+
+	return runOnTarget(ctx, sys, fmt.Sprintf("%s/bin/switch-to-configuration", gen.Path()), "switch")
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+
+	"github.com/arnarg/nilla-utils/internal/cache"
+	"github.com/arnarg/nilla-utils/internal/exec"
+	"github.com/arnarg/nilla-utils/internal/nix"
+	"github.com/arnarg/nilla-utils/internal/project"
+	"github.com/arnarg/nilla-utils/internal/util"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/log"
+	"github.com/sourcegraph/conc/pool"
+	"github.com/urfave/cli/v3"
+)
+
+// Nix function collecting metadata of a NixOS system.
+const systemInfoApply = `
+	s: {
+		hostPlatform = s.result.config.nixpkgs.hostPlatform.system or s.system;
+		release = s.result.config.system.nixos.release;
+		hostName = s.result.config.networking.hostName;
+		target = s.deployment.target or null;
+	}
+`
+
+// Nix function returning the toplevel of a NixOS system.
+const systemToplevelApply = `s: s.result.config.system.build.toplevel.outPath`
+
+type systemInfo struct {
+	Name         string  `json:"name"`
+	HostPlatform string  `json:"hostPlatform"`
+	Release      string  `json:"release"`
+	HostName     string  `json:"hostName"`
+	Target       *string `json:"target"`
+	CurrentHost  bool    `json:"currentHost"`
+	Deployed     *bool   `json:"deployed"`
+	Error        string  `json:"error,omitempty"`
+}
+
+func listConfigurations(ctx context.Context, cmd *cli.Command) error {
+	// Setup logger
+	util.InitLogger(cmd.Bool("verbose"))
+	cache.SetRefresh(cmd.Bool("refresh"))
+
+	// Resolve project
+	source, err := project.Resolve(cmd.String("project"))
+	if err != nil {
+		return err
+	}
+
+	// Get a list of NixOS systems
+	systems, err := nix.ListAttrsInProject(source.NillaPath, source.FixedOutputStoreEntry(), "systems.nixos")
+	if err != nil {
+		return err
+	}
+
+	hn, _ := os.Hostname()
+
+	// Evaluate metadata of every system on its own, so that
+	// a single broken system doesn't hide the others
+	results := []*systemInfo{}
+	for _, name := range systems {
+		results = append(results, &systemInfo{Name: name})
+	}
+
+	forEachSystem(results, func(info *systemInfo) {
+		attr := fmt.Sprintf("systems.nixos.\"%s\"", info.Name)
+		if err := nix.EvalAppliedInProject(
+			source.NillaPath, source.FixedOutputStoreEntry(),
+			attr, systemInfoApply, info,
+		); err != nil {
+			info.Error = util.ErrorLine(err)
+		}
+		info.CurrentHost = info.Target == nil && (info.HostName == hn || info.Name == hn)
+	})
+
+	// Compare the deployed generations with the project
+	if cmd.Bool("deployed") {
+		checkDeployed(source, results)
+	}
+
+	// Print results
+	if cmd.Bool("json") {
+		buf, err := json.MarshalIndent(results, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(buf))
+		return nil
+	}
+
+	if len(results) < 1 {
+		fmt.Println("No NixOS configurations found")
+		return nil
+	}
+
+	util.PrintSection("NixOS configurations")
+	fmt.Println(systemsTable(results, cmd.Bool("deployed")))
+
+	return nil
+}
+
+func checkDeployed(source *project.ProjectSource, results []*systemInfo) {
+	forEachSystem(results, func(info *systemInfo) {
+		// Only check systems we know where are deployed
+		var current string
+		switch {
+		case info.Error != "":
+			return
+
+		case info.Target != nil:
+			executor, err := exec.NewSSHExecutor(*info.Target)
+			if err != nil {
+				log.Warnf("Could not connect to \"%s\": %s", *info.Target, err)
+				return
+			}
+			current, err = currentSystemOn(executor)
+			if err != nil {
+				log.Warnf("Could not get current system of \"%s\": %s", *info.Target, err)
+				return
+			}
+
+		case info.CurrentHost:
+			resolved, err := filepath.EvalSymlinks(CURRENT_PROFILE)
+			if err != nil {
+				return
+			}
+			current = resolved
+
+		default:
+			return
+		}
+
+		var toplevel string
+		attr := fmt.Sprintf("systems.nixos.\"%s\"", info.Name)
+		if err := nix.EvalAppliedInProject(
+			source.NillaPath, source.FixedOutputStoreEntry(),
+			attr, systemToplevelApply, &toplevel,
+		); err != nil {
+			log.Warnf("Could not evaluate system \"%s\": %s", info.Name, util.ErrorLine(err))
+			return
+		}
+
+		deployed := current == toplevel
+		info.Deployed = &deployed
+	})
+}
+
+// forEachSystem runs fn for every system concurrently.
+func forEachSystem(results []*systemInfo, fn func(*systemInfo)) {
+	p := pool.New().WithMaxGoroutines(runtime.NumCPU())
+	for _, info := range results {
+		p.Go(func() {
+			fn(info)
+		})
+	}
+	p.Wait()
+}
+
+func currentSystemOn(executor exec.Executor) (string, error) {
+	out, err := exec.Output(executor, "readlink", "-f", CURRENT_PROFILE)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(out)), nil
+}
+
+func systemsTable(results []*systemInfo, deployed bool) string {
+	yes := lipgloss.NewStyle().Foreground(lipgloss.Color("10")).SetString("yes").String()
+	no := lipgloss.NewStyle().Foreground(lipgloss.Color("9")).SetString("no").String()
+	unknown := lipgloss.NewStyle().Foreground(lipgloss.Color("8")).SetString("-").String()
+	errStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("9"))
+
+	failed := slices.ContainsFunc(results, func(info *systemInfo) bool {
+		return info.Error != ""
+	})
+
+	headers := []string{"Name", "Platform", "Release", "Hostname", "Target"}
+	if deployed {
+		headers = append(headers, "Deployed")
+	}
+	if failed {
+		headers = append(headers, "Error")
+	}
+
+	rows := [][]string{}
+	for _, info := range results {
+		name := fmt.Sprintf("  %s", info.Name)
+		if info.CurrentHost {
+			name = fmt.Sprintf(
+				"%s %s",
+				lipgloss.NewStyle().
+					Foreground(lipgloss.Color("13")).
+					Bold(true).
+					SetString("*").
+					String(),
+				info.Name,
+			)
+		}
+
+		target := "localhost"
+		if info.Target != nil {
+			target = *info.Target
+		}
+
+		row := []string{name, info.HostPlatform, info.Release, info.HostName, target}
+		if info.Error != "" {
+			row = []string{name, unknown, unknown, unknown, unknown}
+		}
+		if deployed {
+			switch {
+			case info.Deployed == nil:
+				row = append(row, unknown)
+			case *info.Deployed:
+				row = append(row, yes)
+			default:
+				row = append(row, no)
+			}
+		}
+		if failed {
+			row = append(row, errStyle.Render(info.Error))
+		}
+
+		rows = append(rows, row)
+	}
+
+	return util.RenderTable(headers, rows...)
+}
@@ -2,9 +2,16 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 
+	"github.com/arnarg/nilla-utils/internal/cache"
+	"github.com/arnarg/nilla-utils/internal/check"
+	"github.com/arnarg/nilla-utils/internal/compare"
 	"github.com/arnarg/nilla-utils/internal/diff"
 	"github.com/arnarg/nilla-utils/internal/exec"
 	"github.com/arnarg/nilla-utils/internal/nix"
@@ -17,7 +24,8 @@ import (
 
 var version = "unknown"
 
-var description = `[name]  Name of the NixOS system to build. If left empty it will use current hostname.`
+var description = `[name]  Name of the NixOS system to build. If left empty it will use current hostname.
+        Multiple names can be given, and "@<tag>" selects every system with that tag in "deployment.tags".`
 
 type subCmd int
 
@@ -28,6 +36,9 @@ const (
 	subCmdSwitch
 )
 
+var generationsDescription = `[name]  Names of NixOS systems in the project to work on, using their deployment target.
+        "@<tag>" selects every system with that tag in "deployment.tags". If left empty it will use the local system.`
+
 const SYSTEM_PROFILE = "/nix/var/nix/profiles/system"
 const CURRENT_PROFILE = "/run/current-system"
 
@@ -43,7 +54,7 @@ var app = &cli.Command{
 	Usage:           "A nilla cli plugin to work with NixOS configurations.",
 	HideVersion:     true,
 	HideHelpCommand: true,
-	Flags: []cli.Flag{
+	Flags: append([]cli.Flag{
 		&cli.BoolFlag{
 			Name:        "version",
 			Aliases:     []string{"V"},
@@ -63,6 +74,29 @@ var app = &cli.Command{
 			Usage:   "The nilla project to use",
 			Value:   "./",
 		},
+		&cli.BoolFlag{
+			Name:        "refresh",
+			Usage:       "Ignore cached project resolution and evaluation results",
+			HideDefault: true,
+		},
+		&cli.StringFlag{
+			Name:  "record-progress",
+			Usage: "Record nix's progress output to `file` for debugging",
+		},
+		&cli.BoolFlag{
+			Name:        "stats",
+			Usage:       "Print build statistics and the slowest builds after building",
+			HideDefault: true,
+		},
+		&cli.StringFlag{
+			Name:  "stats-json",
+			Usage: "Write build statistics as JSON to `file`, suffixed with the system name when building multiple systems",
+		},
+	}, compare.Flags()...),
+	Before: func(ctx context.Context, cmd *cli.Command) (context.Context, error) {
+		nix.SetRecordProgress(cmd.String("record-progress"))
+
+		return compare.Configure(ctx, cmd)
 	},
 	Commands: []*cli.Command{
 		// Build
@@ -70,7 +104,7 @@ var app = &cli.Command{
 			Name:        "build",
 			Usage:       "Build NixOS configuration",
 			Description: fmt.Sprintf("Build NixOS configuration.\n\n%s", description),
-			ArgsUsage:   "[name]",
+			ArgsUsage:   "[name...]",
 			Flags: []cli.Flag{
 				&cli.BoolFlag{
 					Name:  "no-link",
@@ -85,6 +119,14 @@ var app = &cli.Command{
 					Aliases: []string{"o"},
 					Usage:   "Use path as prefix for the symlinks to the build results",
 				},
+				&cli.StringFlag{
+					Name:  "build-host",
+					Usage: "Host to build the configuration on",
+				},
+				&cli.StringSliceFlag{
+					Name:  "tag",
+					Usage: "Select every system with this tag",
+				},
 			},
 			Action: actionFuncFor(subCmdBuild),
 		},
@@ -94,7 +136,7 @@ var app = &cli.Command{
 			Name:        "test",
 			Usage:       "Build NixOS configuration and activate it",
 			Description: fmt.Sprintf("Build NixOS configuration and activate it.\n\n%s", description),
-			ArgsUsage:   "[name]",
+			ArgsUsage:   "[name...]",
 			Flags: []cli.Flag{
 				&cli.BoolFlag{
 					Name:    "confirm",
@@ -106,6 +148,14 @@ var app = &cli.Command{
 					Aliases: []string{"t"},
 					Usage:   "Target host to update",
 				},
+				&cli.StringFlag{
+					Name:  "build-host",
+					Usage: "Host to build the configuration on",
+				},
+				&cli.StringSliceFlag{
+					Name:  "tag",
+					Usage: "Select every system with this tag",
+				},
 			},
 			Action: actionFuncFor(subCmdTest),
 		},
@@ -115,7 +165,7 @@ var app = &cli.Command{
 			Name:        "boot",
 			Usage:       "Build NixOS configuration and make it the boot default",
 			Description: fmt.Sprintf("Build NixOS configuration and make it the boot default.\n\n%s", description),
-			ArgsUsage:   "[name]",
+			ArgsUsage:   "[name...]",
 			Flags: []cli.Flag{
 				&cli.BoolFlag{
 					Name:    "confirm",
@@ -127,6 +177,14 @@ var app = &cli.Command{
 					Aliases: []string{"t"},
 					Usage:   "Target host to update",
 				},
+				&cli.StringFlag{
+					Name:  "build-host",
+					Usage: "Host to build the configuration on",
+				},
+				&cli.StringSliceFlag{
+					Name:  "tag",
+					Usage: "Select every system with this tag",
+				},
 			},
 			Action: actionFuncFor(subCmdBoot),
 		},
@@ -136,7 +194,7 @@ var app = &cli.Command{
 			Name:        "switch",
 			Usage:       "Build NixOS configuration, activate it and make it the boot default",
 			Description: fmt.Sprintf("Build NixOS configuration, activate it and make it the boot default.\n\n%s", description),
-			ArgsUsage:   "[name]",
+			ArgsUsage:   "[name...]",
 			Flags: []cli.Flag{
 				&cli.BoolFlag{
 					Name:    "confirm",
@@ -148,6 +206,14 @@ var app = &cli.Command{
 					Aliases: []string{"t"},
 					Usage:   "Target host to update",
 				},
+				&cli.StringFlag{
+					Name:  "build-host",
+					Usage: "Host to build the configuration on",
+				},
+				&cli.StringSliceFlag{
+					Name:  "tag",
+					Usage: "Select every system with this tag",
+				},
 			},
 			Action: actionFuncFor(subCmdSwitch),
 		},
@@ -158,7 +224,48 @@ var app = &cli.Command{
 			Aliases:     []string{"ls"},
 			Usage:       "List NixOS configurations in project",
 			Description: "List NixOS configurations in project",
-			Action:      listConfigurations,
+			Flags: []cli.Flag{
+				&cli.BoolFlag{
+					Name:  "json",
+					Usage: "Print configurations as JSON",
+				},
+				&cli.BoolFlag{
+					Name:  "deployed",
+					Usage: "Check if the deployed generation matches the project, connecting to remote targets",
+				},
+			},
+			Action: listConfigurations,
+		},
+
+		// Check
+		{
+			Name:        "check",
+			Usage:       "Evaluate and optionally build every NixOS configuration in project",
+			Description: "Evaluate and optionally build every NixOS configuration in project.\n\n[name]  Names of configurations to check, \"@<tag>\" selects every configuration with that tag. If left empty every configuration is checked.",
+			ArgsUsage:   "[name...]",
+			Flags: []cli.Flag{
+				&cli.BoolFlag{
+					Name:  "build",
+					Usage: "Build configurations after evaluating them",
+				},
+				&cli.IntFlag{
+					Name:    "jobs",
+					Aliases: []string{"j"},
+					Usage:   "Number of configurations to check concurrently",
+					Value:   4,
+				},
+			},
+			Action: checkConfigurations,
+		},
+
+		// Diff
+		{
+			Name:        "diff",
+			Usage:       "Compare the packages of two NixOS generations",
+			Description: fmt.Sprintf("Compare the packages of two NixOS generations.\n\n%s", diffDescription),
+			ArgsUsage:   "<from> <to>",
+			Flags:       compare.CommandFlags("Compare configuration files in /etc, systemd units and users"),
+			Action:      diffGenerations,
 		},
 
 		// Generations
@@ -166,15 +273,23 @@ var app = &cli.Command{
 			Name:        "generations",
 			Aliases:     []string{"gen"},
 			Usage:       "Work with NixOS generations",
-			Description: "Work with NixOS generations",
+			Description: "Work with NixOS generations.\n\nWithout a subcommand an interactive generation browser is opened.",
+			Action:      browseGenerations,
 			Commands: []*cli.Command{
 				// List
 				{
 					Name:        "list",
 					Aliases:     []string{"ls"},
 					Usage:       "List NixOS generations",
-					Description: "List NixOS generations",
-					Action:      listGenerations,
+					Description: fmt.Sprintf("List NixOS generations.\n\n%s", generationsDescription),
+					ArgsUsage:   "[name...]",
+					Flags: []cli.Flag{
+						&cli.StringSliceFlag{
+							Name:  "tag",
+							Usage: "Select every system with this tag",
+						},
+					},
+					Action: listGenerations,
 				},
 
 				// Clean
@@ -182,8 +297,13 @@ var app = &cli.Command{
 					Name:        "clean",
 					Aliases:     []string{"c"},
 					Usage:       "Delete and garbage collect NixOS generations",
-					Description: "Delete and garbage collect NixOS generations",
+					Description: fmt.Sprintf("Delete and garbage collect NixOS generations.\n\n%s", generationsDescription),
+					ArgsUsage:   "[name...]",
 					Flags: []cli.Flag{
+						&cli.StringSliceFlag{
+							Name:  "tag",
+							Usage: "Select every system with this tag",
+						},
 						&cli.UintFlag{
 							Name:    "keep",
 							Aliases: []string{"k"},
@@ -200,6 +320,25 @@ var app = &cli.Command{
 				},
 			},
 		},
+		// Replay
+		{
+			Name:      "replay",
+			Usage:     "Replay a progress recording",
+			ArgsUsage: "<file>",
+			Hidden:    true,
+			Flags: []cli.Flag{
+				&cli.FloatFlag{
+					Name:  "speed",
+					Usage: "Replay speed factor, 0 replays without delay",
+					Value: 1,
+				},
+				&cli.BoolFlag{
+					Name:  "copy",
+					Usage: "Replay with the copy reporter instead of the build reporter",
+				},
+			},
+			Action: replayProgress,
+		},
 	},
 	Action: func(ctx context.Context, cmd *cli.Command) error {
 		if cmd.Args().Len() < 1 {
@@ -212,26 +351,125 @@ var app = &cli.Command{
 	},
 }
 
-func printSection(text string) {
-	fmt.Fprintf(os.Stderr, "\033[32m>\033[0m %s\n", text)
+// system is a NixOS system in the project selected for an operation.
+type system struct {
+	name       string
+	deployment *project.Deployment
+	target     exec.Executor
+	out        string
 }
 
-func inferName(name string) (string, error) {
-	if name == "" {
-		hn, err := os.Hostname()
+// section prints a section header, naming the system when
+// an operation covers multiple systems.
+func (s *system) section(text string, multiple bool) {
+	if multiple {
+		text = fmt.Sprintf("%s (%s)", text, s.name)
+	}
+	util.PrintSection(text)
+}
+
+func (s *system) targetName() string {
+	if s.deployment.Target != "" {
+		return s.deployment.Target
+	}
+	return "localhost"
+}
+
+// selectors returns the system selectors from the command's arguments and
+// `--tag` flags, or the current hostname if none were given.
+func selectors(cmd *cli.Command) ([]string, bool, error) {
+	sels := cmd.Args().Slice()
+	for _, tag := range cmd.StringSlice("tag") {
+		sels = append(sels, fmt.Sprintf("@%s", tag))
+	}
+
+	if len(sels) > 0 {
+		return sels, true, nil
+	}
+
+	hn, err := os.Hostname()
+	if err != nil {
+		return nil, false, err
+	}
+	return []string{hn}, false, nil
+}
+
+// selectSystems resolves the systems selected by the command and loads their
+// deployment settings, with command line flags taking precedence. Returns true
+// if the systems were chosen by selectors instead of the hostname.
+func selectSystems(cmd *cli.Command, source *project.ProjectSource) ([]*system, bool, error) {
+	sels, explicit, err := selectors(cmd)
+	if err != nil {
+		return nil, false, err
+	}
+
+	// Only look up selectors if they were explicitly provided,
+	// the hostname is checked when building
+	names := sels
+	if explicit {
+		names, err = source.SelectSystems("nixos", sels)
+		if err != nil {
+			return nil, false, err
+		}
+	}
+
+	if len(names) > 1 && cmd.String("target") != "" {
+		return nil, false, errors.New("--target can only be used with a single system")
+	}
+
+	systems := []*system{}
+	for _, name := range names {
+		deployment, err := source.LoadDeployment("nixos", name)
 		if err != nil {
-			return "", err
+			return nil, false, err
 		}
-		return hn, nil
+		if cmd.String("target") != "" {
+			deployment.Target = cmd.String("target")
+		}
+		if cmd.String("build-host") != "" {
+			deployment.BuildHost = cmd.String("build-host")
+		}
+		deployment.Confirm = deployment.Confirm || cmd.Bool("confirm")
+
+		systems = append(systems, &system{name: name, deployment: deployment})
 	}
-	return name, nil
+
+	return systems, explicit, nil
 }
 
-func run(ctx context.Context, cmd *cli.Command, sc subCmd) error {
-	var builder, target exec.Executor
+// connect sets up the target executor for every system.
+func connect(systems []*system, local exec.Executor) error {
+	for _, sys := range systems {
+		if sys.deployment.Target == "" {
+			sys.target = local
+			continue
+		}
+
+		log.Infof("Connecting to \"%s\"", sys.deployment.Target)
+		target, err := exec.NewSSHExecutor(sys.deployment.Target)
+		if err != nil {
+			return err
+		}
+		sys.target = target
+	}
+
+	return nil
+}
+
+func printSelection(systems []*system) {
+	rows := [][]string{}
+	for _, sys := range systems {
+		rows = append(rows, []string{sys.name, sys.targetName()})
+	}
+
+	util.PrintSection("Selected systems")
+	fmt.Fprintln(os.Stderr, util.RenderTable([]string{"System", "Target"}, rows...))
+}
 
+func run(ctx context.Context, cmd *cli.Command, sc subCmd) error {
 	// Setup logger
 	util.InitLogger(cmd.Bool("verbose"))
+	cache.SetRefresh(cmd.Bool("refresh"))
 
 	// Resolve project
 	source, err := project.Resolve(cmd.String("project"))
@@ -240,88 +478,67 @@ func run(ctx context.Context, cmd *cli.Command, sc subCmd) error {
 	}
 
 	// Setup builder, which is always local
-	builder = exec.NewLocalExecutor()
+	builder := exec.NewLocalExecutor()
 
-	// Try to infer name of the NixOS system
-	name, err := inferName(cmd.Args().First())
+	// Load advisories to check new systems against
+	advisories, err := compare.LoadAdvisories(cmd)
 	if err != nil {
 		return err
 	}
 
-	// Attribute of NixOS configuration's toplevel
-	attr := fmt.Sprintf("systems.nixos.\"%s\".result.config.system.build.toplevel", name)
-
-	// Check if attribute exists
-	exists, err := nix.ExistsInProject(source.NillaPath, source.FixedOutputStoreEntry(), attr)
+	// Select NixOS systems to work on
+	systems, selected, err := selectSystems(cmd, source)
 	if err != nil {
 		return err
 	}
-	if !exists {
-		return fmt.Errorf("Attribute '%s' does not exist in project \"%s\"", attr, source.FullNillaPath())
-	}
-
-	log.Infof("Found system \"%s\"", name)
+	multiple := len(systems) > 1
 
 	//
 	// NixOS configuration build
 	//
-	// Build args for nix build
-	nargs := []string{"-f", source.FullNillaPath(), attr}
-
-	// Add extra args depending on the sub command
-	if sc == subCmdBuild {
-		if cmd.Bool("no-link") {
-			nargs = append(nargs, "--no-link")
+	for i, sys := range systems {
+		if i > 0 {
+			fmt.Fprintln(os.Stderr)
 		}
-		if cmd.String("out-link") != "" {
-			nargs = append(nargs, "--out-link", cmd.String("out-link"))
-		}
-	} else {
-		// All sub-commands except build should not
-		// create a result link
-		nargs = append(nargs, "--no-link")
-	}
 
-	// Run nix build
-	printSection("Building configuration")
-	out, err := nix.Command("build").
-		Args(nargs).
-		Executor(builder).
-		Reporter(tui.NewBuildReporter(cmd.Bool("verbose"))).
-		Run(ctx)
-	if err != nil {
-		return err
+		if err := buildSystem(ctx, cmd, sc, source, builder, sys, multiple); err != nil {
+			return err
+		}
 	}
 
 	//
-	// Setup target executor
+	// Setup target executors
 	//
-	if cmd.String("target") != "" {
-		target, err = exec.NewSSHExecutor(cmd.String("target"))
-		if err != nil {
-			return err
-		}
-	} else {
-		target = builder
+	if err := connect(systems, builder); err != nil {
+		return err
 	}
 
 	//
-	// Run generation diff using nvd
+	// Compare changes and check for vulnerabilities
 	//
-	fmt.Fprintln(os.Stderr)
-	printSection("Comparing changes")
+	for _, sys := range systems {
+		label := ""
+		if multiple {
+			label = sys.name
+		}
 
-	if err := diff.Execute(
-		&diff.Generation{
+		from := &diff.Generation{
 			Path:     CURRENT_PROFILE,
-			Executor: target,
-		},
-		&diff.Generation{
-			Path:     string(out),
+			Executor: sys.target,
+		}
+		to := &diff.Generation{
+			Path:     sys.out,
 			Executor: builder,
-		},
-	); err != nil {
-		return err
+			Name:     sys.name,
+		}
+
+		fmt.Fprintln(os.Stderr)
+		if err := compare.Changes(from, to, label); err != nil {
+			return err
+		}
+		if err := compare.Vulnerabilities(advisories, from, to, label); err != nil {
+			return err
+		}
 	}
 
 	// Build can exit now
@@ -332,7 +549,15 @@ func run(ctx context.Context, cmd *cli.Command, sc subCmd) error {
 	//
 	// Ask Confirmation
 	//
-	if !cmd.Bool("confirm") {
+	if selected {
+		fmt.Fprintln(os.Stderr)
+		printSelection(systems)
+	}
+
+	confirmed := !slices.ContainsFunc(systems, func(sys *system) bool {
+		return !sys.deployment.Confirm
+	})
+	if !confirmed {
 		doContinue, err := tui.RunConfirm("Do you want to continue?")
 		if err != nil {
 			return err
@@ -342,21 +567,106 @@ func run(ctx context.Context, cmd *cli.Command, sc subCmd) error {
 		}
 	}
 
+	//
+	// Deploy systems
+	//
+	for _, sys := range systems {
+		if err := deploySystem(ctx, cmd, sc, builder, sys, multiple); err != nil {
+			if multiple {
+				return fmt.Errorf("%s: %w", sys.name, err)
+			}
+			return err
+		}
+	}
+
+	return nil
+}
+
+func buildSystem(ctx context.Context, cmd *cli.Command, sc subCmd, source *project.ProjectSource, builder exec.Executor, sys *system, multiple bool) error {
+	// Attribute of NixOS configuration's toplevel
+	attr := fmt.Sprintf("systems.nixos.\"%s\".result.config.system.build.toplevel", sys.name)
+
+	// Check if attribute exists
+	exists, err := nix.ExistsInProject(source.NillaPath, source.FixedOutputStoreEntry(), attr)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		if source.IsFlake() {
+			return fmt.Errorf("Configuration \"%s\" does not exist in nixosConfigurations of flake \"%s\"", sys.name, source.FlakePath)
+		}
+		return fmt.Errorf("Attribute '%s' does not exist in project \"%s\"", attr, source.FullNillaPath())
+	}
+
+	log.Infof("Found system \"%s\"", sys.name)
+
+	// Build args for nix build
+	nargs := []string{"-f", source.FullNillaPath(), attr}
+
+	// Delegate all builds to the build host, if set
+	if sys.deployment.BuildHost != "" {
+		log.Infof("Building on \"%s\"", sys.deployment.BuildHost)
+		nargs = append(
+			nargs,
+			"--max-jobs", "0",
+			"--builders", fmt.Sprintf("ssh-ng://%s", sys.deployment.BuildHost),
+		)
+	}
+
+	// Add extra args depending on the sub command
+	if sc == subCmdBuild {
+		if sys.deployment.Bool(cmd, "no-link") {
+			nargs = append(nargs, "--no-link")
+		}
+		if outLink := sys.deployment.String(cmd, "out-link"); outLink != "" {
+			if multiple {
+				outLink = fmt.Sprintf("%s-%s", outLink, sys.name)
+			}
+			nargs = append(nargs, "--out-link", outLink)
+		}
+	} else {
+		// All sub-commands except build should not
+		// create a result link
+		nargs = append(nargs, "--no-link")
+	}
+
+	// Run nix build
+	statsName := ""
+	if multiple {
+		statsName = sys.name
+	}
+
+	sys.section("Building configuration", multiple)
+	out, err := nix.Command("build").
+		Args(nargs).
+		Executor(builder).
+		Reporter(tui.NewBuildReporter(sys.deployment.Bool(cmd, "verbose")).WithStats(statsOptions(cmd, statsName))).
+		Run(ctx)
+	if err != nil {
+		return err
+	}
+
+	sys.out = string(out)
+
+	return nil
+}
+
+func deploySystem(ctx context.Context, cmd *cli.Command, sc subCmd, builder exec.Executor, sys *system, multiple bool) error {
 	//
 	// Copy closure to target
 	//
-	if cmd.String("target") != "" {
+	if sys.deployment.Target != "" {
 		fmt.Fprintln(os.Stderr)
-		printSection("Copying system to target")
+		sys.section("Copying system to target", multiple)
 
 		// Copy system closure
 		_, err := nix.Command("copy").
 			Args([]string{
-				"--to", fmt.Sprintf("ssh://%s", cmd.String("target")),
-				string(out),
+				"--to", fmt.Sprintf("ssh://%s", sys.deployment.Target),
+				sys.out,
 			}).
 			Executor(builder).
-			Reporter(tui.NewCopyReporter(cmd.Bool("verbose"))).
+			Reporter(tui.NewCopyReporter(sys.deployment.Bool(cmd, "verbose"))).
 			Run(ctx)
 		if err != nil {
 			return err
@@ -368,11 +678,12 @@ func run(ctx context.Context, cmd *cli.Command, sc subCmd) error {
 	//
 	if sc == subCmdTest || sc == subCmdSwitch {
 		fmt.Fprintln(os.Stderr)
-		printSection("Activating configuration")
+		sys.section("Activating configuration", multiple)
 
 		// Run switch_to_configuration
-		switchp := fmt.Sprintf("%s/bin/switch-to-configuration", out)
-		switchc, err := target.Command("sudo", switchp, "test")
+		switchp := fmt.Sprintf("%s/bin/switch-to-configuration", sys.out)
+		switchArgs := sys.deployment.Elevate(switchp, "test")
+		switchc, err := sys.target.Command(switchArgs[0], switchArgs[1:]...)
 		if err != nil {
 			return err
 		}
@@ -393,15 +704,16 @@ func run(ctx context.Context, cmd *cli.Command, sc subCmd) error {
 	//
 	if sc == subCmdBoot || sc == subCmdSwitch {
 		fmt.Fprintln(os.Stderr)
-		printSection("Adding configuration to bootloader")
+		sys.section("Adding configuration to bootloader", multiple)
 
 		// Set profile
-		buildc, err := target.Command(
-			"sudo", "nix", "build",
+		buildArgs := sys.deployment.Elevate(
+			"nix", "build",
 			"--no-link", "--profile", SYSTEM_PROFILE,
 			"--extra-experimental-features", "nix-command",
-			string(out),
+			sys.out,
 		)
+		buildc, err := sys.target.Command(buildArgs[0], buildArgs[1:]...)
 		if err != nil {
 			return err
 		}
@@ -414,8 +726,9 @@ func run(ctx context.Context, cmd *cli.Command, sc subCmd) error {
 		}
 
 		// Run switch_to_configuration
-		switchp := fmt.Sprintf("%s/bin/switch-to-configuration", out)
-		switchc, err := target.Command("sudo", switchp, "boot")
+		switchp := fmt.Sprintf("%s/bin/switch-to-configuration", sys.out)
+		switchArgs := sys.deployment.Elevate(switchp, "boot")
+		switchc, err := sys.target.Command(switchArgs[0], switchArgs[1:]...)
 		if err != nil {
 			return err
 		}
@@ -430,9 +743,10 @@ func run(ctx context.Context, cmd *cli.Command, sc subCmd) error {
 	return nil
 }
 
-func listConfigurations(ctx context.Context, cmd *cli.Command) error {
+func checkConfigurations(ctx context.Context, cmd *cli.Command) error {
 	// Setup logger
 	util.InitLogger(cmd.Bool("verbose"))
+	cache.SetRefresh(cmd.Bool("refresh"))
 
 	// Resolve project
 	source, err := project.Resolve(cmd.String("project"))
@@ -440,28 +754,83 @@ func listConfigurations(ctx context.Context, cmd *cli.Command) error {
 		return err
 	}
 
-	// Get a list of home systems
-	systems, err := nix.ListAttrsInProject(source.NillaPath, source.FixedOutputStoreEntry(), "systems.nixos")
+	// Get a list of configurations to check
+	var names []string
+	if cmd.Args().Len() > 0 {
+		names, err = source.SelectSystems("nixos", cmd.Args().Slice())
+	} else {
+		names, err = nix.ListAttrsInProject(source.NillaPath, source.FixedOutputStoreEntry(), "systems.nixos")
+	}
 	if err != nil {
 		return err
 	}
 
-	// Print results
-	if len(systems) < 1 {
+	if len(names) < 1 {
 		fmt.Println("No NixOS configurations found")
-	} else {
-		printSection("NixOS configurations")
-		for _, system := range systems {
-			fmt.Printf("- %s\n", system)
-		}
+		return nil
 	}
 
-	return nil
+	// Check all configurations
+	util.PrintSection(fmt.Sprintf("Checking %d NixOS configurations", len(names)))
+	results := check.Run(
+		ctx,
+		source.FullNillaPath(),
+		names,
+		func(name string) string {
+			return fmt.Sprintf("systems.nixos.\"%s\".result.config.system.build.toplevel", name)
+		},
+		check.Options{
+			Build: cmd.Bool("build"),
+			Jobs:  int(cmd.Int("jobs")),
+		},
+	)
+
+	// Print results
+	fmt.Fprintln(os.Stderr)
+	util.PrintSection("Results")
+	fmt.Println(check.Table(results))
+
+	return check.Err(results)
+}
+
+// statsOptions returns the build statistics options. With a system name,
+// the name is added to the JSON file name so that building multiple systems
+// writes the statistics of each, e.g. "stats-laptop.json".
+func statsOptions(cmd *cli.Command, name string) tui.StatsOptions {
+	jsonPath := cmd.String("stats-json")
+	if jsonPath != "" && name != "" {
+		ext := filepath.Ext(jsonPath)
+		jsonPath = fmt.Sprintf("%s-%s%s", strings.TrimSuffix(jsonPath, ext), name, ext)
+	}
+
+	return tui.StatsOptions{
+		Print:    cmd.Bool("stats"),
+		Top:      10,
+		JSONPath: jsonPath,
+	}
+}
+
+func replayProgress(ctx context.Context, cmd *cli.Command) error {
+	if cmd.Args().Len() < 1 {
+		return errors.New("recording file is required")
+	}
+
+	var reporter nix.ProgressReporter = tui.NewBuildReporter(cmd.Bool("verbose")).WithStats(statsOptions(cmd, ""))
+	if cmd.Bool("copy") {
+		reporter = tui.NewCopyReporter(cmd.Bool("verbose"))
+	}
+
+	return tui.Replay(ctx, cmd.Args().First(), cmd.Float("speed"), reporter)
 }
 
 func main() {
-	if err := app.Run(context.Background(), os.Args); err != nil {
-		log.Error(err)
+	err := app.Run(context.Background(), os.Args)
+
+	// Write values cached while running the command
+	cache.Flush()
+
+	if err != nil {
+		tui.PrintError(err)
 		os.Exit(1)
 	}
 }
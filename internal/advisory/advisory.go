@@ -0,0 +1,224 @@
+// Package advisory checks packages against a local database of
+// security advisories in the OSV format.
+package advisory
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/arnarg/nilla-utils/internal/diff"
+)
+
+type event struct {
+	Introduced   string `json:"introduced"`
+	Fixed        string `json:"fixed"`
+	LastAffected string `json:"last_affected"`
+}
+
+type versionRange struct {
+	Type   string  `json:"type"`
+	Events []event `json:"events"`
+}
+
+type affected struct {
+	Package struct {
+		Name string `json:"name"`
+	} `json:"package"`
+	Ranges   []versionRange `json:"ranges"`
+	Versions []string       `json:"versions"`
+}
+
+// Advisory is a security advisory in the OSV format.
+type Advisory struct {
+	ID               string     `json:"id"`
+	Aliases          []string   `json:"aliases"`
+	Summary          string     `json:"summary"`
+	Affected         []affected `json:"affected"`
+	DatabaseSpecific struct {
+		Severity string `json:"severity"`
+	} `json:"database_specific"`
+}
+
+// Severity returns the severity of the advisory, if known.
+func (a *Advisory) Severity() string {
+	return strings.ToUpper(a.DatabaseSpecific.Severity)
+}
+
+// ids returns the ID and aliases of the advisory.
+func (a *Advisory) ids() []string {
+	return append([]string{a.ID}, a.Aliases...)
+}
+
+// affects returns true if the version of the package is affected.
+func (a *Advisory) affects(pname, version string) bool {
+	for _, aff := range a.Affected {
+		if !strings.EqualFold(aff.Package.Name, pname) {
+			continue
+		}
+
+		if slices.Contains(aff.Versions, version) {
+			return true
+		}
+
+		for _, r := range aff.Ranges {
+			if (r.Type == "ECOSYSTEM" || r.Type == "SEMVER") && inRange(r.Events, version) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// version returns the version the event happened in.
+func (e event) version() string {
+	return cmp.Or(e.Introduced, e.Fixed, e.LastAffected)
+}
+
+// inRange evaluates the events of an OSV range for the version.
+func inRange(events []event, version string) bool {
+	events = slices.Clone(events)
+	slices.SortStableFunc(events, func(a, b event) int {
+		return diff.CompareVersions(a.version(), b.version())
+	})
+
+	affected := false
+	for _, e := range events {
+		switch {
+		case e.Introduced != "":
+			if e.Introduced == "0" || diff.CompareVersions(version, e.Introduced) >= 0 {
+				affected = true
+			}
+		case e.Fixed != "":
+			if diff.CompareVersions(version, e.Fixed) >= 0 {
+				affected = false
+			}
+		case e.LastAffected != "":
+			if diff.CompareVersions(version, e.LastAffected) > 0 {
+				affected = false
+			}
+		}
+	}
+
+	return affected
+}
+
+// Database is a set of advisories indexed by package name.
+type Database struct {
+	advisories map[string][]*Advisory
+	whitelist  *Whitelist
+}
+
+// Load loads advisories from a JSON file with a single advisory or a list
+// of them, or from a directory of such files.
+func Load(path string) (*Database, error) {
+	db := &Database{advisories: map[string][]*Advisory{}}
+
+	err := filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || (p != path && filepath.Ext(p) != ".json") {
+			return nil
+		}
+
+		buf, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+
+		advisories, err := decodeAdvisories(buf)
+		if err != nil {
+			return fmt.Errorf("%s: %w", p, err)
+		}
+		for _, a := range advisories {
+			db.add(a)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return db, nil
+}
+
+func decodeAdvisories(buf []byte) ([]*Advisory, error) {
+	advisories := []*Advisory{}
+	if trimmed := strings.TrimSpace(string(buf)); strings.HasPrefix(trimmed, "[") {
+		if err := json.Unmarshal(buf, &advisories); err != nil {
+			return nil, err
+		}
+		return advisories, nil
+	}
+
+	advisory := &Advisory{}
+	if err := json.Unmarshal(buf, advisory); err != nil {
+		return nil, err
+	}
+	return append(advisories, advisory), nil
+}
+
+func (db *Database) add(a *Advisory) {
+	names := []string{}
+	for _, aff := range a.Affected {
+		name := strings.ToLower(aff.Package.Name)
+		if !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+
+	for _, name := range names {
+		db.advisories[name] = append(db.advisories[name], a)
+	}
+}
+
+// SetWhitelist sets a whitelist of advisories to ignore.
+func (db *Database) SetWhitelist(whitelist *Whitelist) {
+	db.whitelist = whitelist
+}
+
+// Match returns the advisories affecting the version of the package.
+func (db *Database) Match(pname, version string) []*Advisory {
+	matches := []*Advisory{}
+	for _, a := range db.advisories[strings.ToLower(pname)] {
+		if !a.affects(pname, version) {
+			continue
+		}
+		if db.whitelist != nil && db.whitelist.Ignores(pname, version, a.ids()) {
+			continue
+		}
+		matches = append(matches, a)
+	}
+	return matches
+}
+
+// LoadWithWhitelist loads the advisories at path with an optional
+// whitelist. Returns nil if path is empty.
+func LoadWithWhitelist(path, whitelistPath string) (*Database, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	db, err := Load(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if whitelistPath != "" {
+		whitelist, err := LoadWhitelist(whitelistPath)
+		if err != nil {
+			return nil, err
+		}
+		db.SetWhitelist(whitelist)
+	}
+
+	return db, nil
+}
@@ -0,0 +1,135 @@
+package advisory
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+)
+
+func TestInRange(t *testing.T) {
+	events := []event{
+		{Introduced: "3.1.0"},
+		{Fixed: "3.1.5"},
+		{Introduced: "3.0.0"},
+		{Fixed: "3.0.13"},
+	}
+
+	tests := []struct {
+		version string
+		out     bool
+	}{
+		{"2.9.9", false},
+		{"3.0.0", true},
+		{"3.0.12", true},
+		{"3.0.13", false},
+		{"3.0.14", false},
+		{"3.1.4", true},
+		{"3.1.5", false},
+		{"3.2.0", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.version, func(t *testing.T) {
+			if res := inRange(events, tt.version); res != tt.out {
+				t.Errorf("unexpected result: %t != %t", res, tt.out)
+			}
+		})
+	}
+
+	lastAffected := []event{{Introduced: "0"}, {LastAffected: "9.7p1"}}
+	if !inRange(lastAffected, "9.7p1") || inRange(lastAffected, "9.8p1") {
+		t.Error("unexpected result for last affected version")
+	}
+}
+
+func TestDatabase(t *testing.T) {
+	db, err := Load("testdata/osv")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	packages := map[string][]string{
+		"openssl": {"3.0.12", "3.1.5"},
+		"openssh": {"9.6p1"},
+		"libwebp": {"1.3.1"},
+		"gzip":    {"1.13"},
+	}
+
+	ids := func(findings []Finding) []string {
+		res := []string{}
+		for _, f := range findings {
+			res = append(res, f.PName+" "+f.Version+" "+f.Advisory.ID)
+		}
+		return res
+	}
+
+	expected := []string{
+		"libwebp 1.3.1 CVE-2023-4863",
+		"openssh 9.6p1 CVE-2024-6387",
+		"openssl 3.0.12 CVE-2024-0727",
+	}
+	if diff := deep.Equal(ids(db.Scan(packages)), expected); diff != nil {
+		t.Error(diff)
+	}
+
+	// Whitelisted advisories are ignored until they expire
+	whitelist, err := LoadWhitelist("testdata/whitelist.toml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	whitelist.now = func() time.Time { return time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC) }
+	db.SetWhitelist(whitelist)
+
+	expected = []string{
+		"openssl 3.0.12 CVE-2024-0727",
+	}
+	if diff := deep.Equal(ids(db.Scan(packages)), expected); diff != nil {
+		t.Error(diff)
+	}
+
+	whitelist.now = func() time.Time { return time.Date(2024, 8, 1, 0, 0, 0, 0, time.UTC) }
+	expected = []string{
+		"openssh 9.6p1 CVE-2024-6387",
+		"openssl 3.0.12 CVE-2024-0727",
+	}
+	if diff := deep.Equal(ids(db.Scan(packages)), expected); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestCompare(t *testing.T) {
+	openssl := &Advisory{ID: "CVE-2024-0727", Summary: "PKCS12 decoding crashes"}
+	openssl.DatabaseSpecific.Severity = "medium"
+	openssh := &Advisory{ID: "CVE-2024-6387"}
+	libwebp := &Advisory{ID: "CVE-2023-4863"}
+
+	before := []Finding{
+		{"libwebp", "1.3.1", libwebp},
+		{"openssl", "3.0.11", openssl},
+	}
+	after := []Finding{
+		{"openssh", "9.6p1", openssh},
+		{"openssl", "3.0.12", openssl},
+	}
+
+	report := Compare(before, after)
+
+	expected := &Report{
+		Introduced: []Finding{{"openssh", "9.6p1", openssh}},
+		Fixed:      []Finding{{"libwebp", "1.3.1", libwebp}},
+		Remaining:  []Finding{{"openssl", "3.0.12", openssl}},
+	}
+	if diff := deep.Equal(report, expected); diff != nil {
+		t.Error(diff)
+	}
+
+	rendered := "Introduced vulnerabilities:\n" +
+		"#01  openssh 9.6p1  CVE-2024-6387\n" +
+		"Fixed vulnerabilities:\n" +
+		"#01  libwebp 1.3.1  CVE-2023-4863\n" +
+		"Vulnerabilities: 1 introduced, 1 fixed, 1 remaining\n"
+	if out := report.Render(); out != rendered {
+		t.Errorf("unexpected output:\n%s", out)
+	}
+}
@@ -0,0 +1,148 @@
+package advisory
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/arnarg/nilla-utils/internal/diff"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// Finding is a package version affected by an advisory.
+type Finding struct {
+	PName    string
+	Version  string
+	Advisory *Advisory
+}
+
+func (f Finding) key() string {
+	return fmt.Sprintf("%s\t%s", f.PName, f.Advisory.ID)
+}
+
+// Scan returns the findings for the versions of every package.
+func (db *Database) Scan(packages map[string][]string) []Finding {
+	findings := []Finding{}
+	for pname, versions := range packages {
+		for _, version := range versions {
+			for _, a := range db.Match(pname, version) {
+				findings = append(findings, Finding{pname, version, a})
+			}
+		}
+	}
+
+	slices.SortFunc(findings, func(a, b Finding) int {
+		return cmp.Or(
+			strings.Compare(a.PName, b.PName),
+			strings.Compare(a.Version, b.Version),
+			strings.Compare(a.Advisory.ID, b.Advisory.ID),
+		)
+	})
+
+	return findings
+}
+
+// Report holds the vulnerabilities of two closures.
+type Report struct {
+	// Introduced are findings only in the new closure.
+	Introduced []Finding
+	// Fixed are findings only in the old closure.
+	Fixed []Finding
+	// Remaining are findings in both closures.
+	Remaining []Finding
+}
+
+// Compare compares the findings of an old and a new closure. A package
+// affected by the same advisory in both is not new, even if its version
+// changed.
+func Compare(before, after []Finding) *Report {
+	report := &Report{
+		Introduced: []Finding{},
+		Fixed:      []Finding{},
+		Remaining:  []Finding{},
+	}
+
+	beforeKeys := map[string]bool{}
+	for _, f := range before {
+		beforeKeys[f.key()] = true
+	}
+	afterKeys := map[string]bool{}
+	for _, f := range after {
+		afterKeys[f.key()] = true
+	}
+
+	for _, f := range after {
+		if beforeKeys[f.key()] {
+			report.Remaining = append(report.Remaining, f)
+		} else {
+			report.Introduced = append(report.Introduced, f)
+		}
+	}
+	for _, f := range before {
+		if !afterKeys[f.key()] {
+			report.Fixed = append(report.Fixed, f)
+		}
+	}
+
+	return report
+}
+
+// Check compares the vulnerabilities in the packages of two closures.
+func Check(db *Database, before, after diff.PackageSet) *Report {
+	return Compare(db.Scan(before.Versions()), db.Scan(after.Versions()))
+}
+
+func renderFindings(strb *strings.Builder, title string, color lipgloss.Color, findings []Finding) {
+	if len(findings) < 1 {
+		return
+	}
+
+	widest := 0
+	for _, f := range findings {
+		widest = max(widest, len(f.PName)+len(f.Version)+1)
+	}
+
+	fmt.Fprintf(strb, "%s:\n", title)
+	for i, f := range findings {
+		id := f.Advisory.ID
+		if severity := f.Advisory.Severity(); severity != "" {
+			id = fmt.Sprintf("%s (%s)", id, severity)
+		}
+
+		line := fmt.Sprintf(
+			"#%02d  %s  %s",
+			i+1,
+			lipgloss.NewStyle().
+				Width(widest).
+				SetString(fmt.Sprintf("%s %s", f.PName, f.Version)).
+				String(),
+			lipgloss.NewStyle().
+				Foreground(color).
+				SetString(id).
+				String(),
+		)
+		if f.Advisory.Summary != "" {
+			line = fmt.Sprintf("%s  %s", line, f.Advisory.Summary)
+		}
+		fmt.Fprintln(strb, line)
+	}
+}
+
+// Render renders the introduced and fixed vulnerabilities of the report.
+func (r *Report) Render() string {
+	strb := &strings.Builder{}
+
+	renderFindings(strb, "Introduced vulnerabilities", lipgloss.Color("9"), r.Introduced)
+	renderFindings(strb, "Fixed vulnerabilities", lipgloss.Color("10"), r.Fixed)
+
+	fmt.Fprintf(
+		strb,
+		"Vulnerabilities: %d introduced, %d fixed, %d remaining\n",
+		len(r.Introduced),
+		len(r.Fixed),
+		len(r.Remaining),
+	)
+
+	return strb.String()
+}
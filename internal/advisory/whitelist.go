@@ -0,0 +1,84 @@
+package advisory
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+type whitelistEntry struct {
+	cves  []string
+	until time.Time
+}
+
+// Whitelist ignores advisories for packages, in the format of vulnix
+// whitelists. Sections are named after a package with or without its
+// version and can limit the ignored advisories with `cve` and the
+// time they are ignored with `until`:
+//
+//	["openssl-3.0.13"]
+//	cve = ["CVE-2024-0727"]
+//	until = "2025-01-01"
+//	comment = "Not exploitable in our setup"
+type Whitelist struct {
+	entries map[string]*whitelistEntry
+	now     func() time.Time
+}
+
+// whitelistSection is a section of a vulnix whitelist. Other keys, like
+// comment and issue_url, are ignored.
+type whitelistSection struct {
+	CVE   []string `toml:"cve"`
+	Until string   `toml:"until"`
+}
+
+// LoadWhitelist loads a vulnix compatible whitelist.
+func LoadWhitelist(path string) (*Whitelist, error) {
+	sections := map[string]whitelistSection{}
+	if _, err := toml.DecodeFile(path, &sections); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	whitelist := &Whitelist{entries: map[string]*whitelistEntry{}, now: time.Now}
+
+	for name, section := range sections {
+		entry := &whitelistEntry{cves: section.CVE}
+
+		if section.Until != "" {
+			until, err := time.Parse(time.DateOnly, section.Until)
+			if err != nil {
+				return nil, fmt.Errorf("%s: invalid until in \"%s\": %w", path, name, err)
+			}
+			entry.until = until
+		}
+
+		whitelist.entries[name] = entry
+	}
+
+	return whitelist, nil
+}
+
+// Ignores returns true if an advisory with any of the IDs
+// is whitelisted for the version of the package.
+func (w *Whitelist) Ignores(pname, version string, ids []string) bool {
+	for _, name := range []string{fmt.Sprintf("%s-%s", pname, version), pname} {
+		entry, ok := w.entries[name]
+		if !ok {
+			continue
+		}
+
+		if !entry.until.IsZero() && !w.now().Before(entry.until) {
+			continue
+		}
+
+		if len(entry.cves) == 0 || slices.ContainsFunc(ids, func(id string) bool {
+			return slices.Contains(entry.cves, id)
+		}) {
+			return true
+		}
+	}
+
+	return false
+}
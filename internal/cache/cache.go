@@ -0,0 +1,215 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/arnarg/nilla-utils/internal/util"
+	"github.com/charmbracelet/log"
+)
+
+// Entries older than this are dropped when the cache is written.
+const maxAge = 30 * 24 * time.Hour
+
+type entry struct {
+	Value   json.RawMessage `json:"value"`
+	Created time.Time       `json:"created"`
+}
+
+var (
+	mu      sync.Mutex
+	refresh bool
+	values  = &store{}
+)
+
+// store holds the cache file in memory along with the entries set since it
+// was loaded, which are merged into the file when flushed.
+type store struct {
+	entries map[string]entry
+	pending map[string]entry
+}
+
+// SetRefresh makes all lookups miss when set, so that values are
+// recomputed and written back to the cache.
+func SetRefresh(r bool) {
+	mu.Lock()
+	defer mu.Unlock()
+	refresh = r
+}
+
+// Key creates a cache key by hashing all parts together.
+func Key(parts ...string) string {
+	h := sha256.New()
+	for _, p := range parts {
+		h.Write([]byte(p))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Get looks up key in the cache and decodes the value into v.
+// Returns true if the value was found.
+func Get(key string, v any) bool {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if refresh {
+		return false
+	}
+
+	return values.get(key, v)
+}
+
+// Set stores v under key in the cache. The value is only kept in memory
+// until `Flush` is called.
+func Set(key string, v any) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	buf, err := json.Marshal(v)
+	if err != nil {
+		log.Debugf("Could not encode cache value: %s", err)
+		return
+	}
+
+	values.set(key, entry{buf, time.Now()})
+}
+
+// Flush writes the values set since the cache was loaded to the cache
+// file. Failures to write the cache are only logged as the cache is never
+// required for correctness.
+func Flush() {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if err := values.flush(file()); err != nil {
+		log.Debugf("Could not write cache: %s", err)
+	}
+}
+
+func file() string {
+	dir := os.Getenv("XDG_CACHE_HOME")
+	if dir == "" {
+		dir = filepath.Join(util.GetHomeDir(), ".cache")
+	}
+	return filepath.Join(dir, "nilla-utils", "cache.json")
+}
+
+func (s *store) get(key string, v any) bool {
+	if s.entries == nil {
+		s.entries = load(file())
+	}
+
+	e, ok := s.entries[key]
+	if !ok {
+		return false
+	}
+
+	if err := json.Unmarshal(e.Value, v); err != nil {
+		return false
+	}
+
+	return true
+}
+
+func (s *store) set(key string, e entry) {
+	if s.entries == nil {
+		s.entries = load(file())
+	}
+	if s.pending == nil {
+		s.pending = map[string]entry{}
+	}
+
+	s.entries[key] = e
+	s.pending[key] = e
+}
+
+func (s *store) flush(p string) error {
+	if len(s.pending) == 0 {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return err
+	}
+
+	// Hold a lock while merging so that values written by
+	// concurrent invocations are not lost
+	lock, err := os.OpenFile(p+".lock", os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+
+	if err := syscall.Flock(int(lock.Fd()), syscall.LOCK_EX); err != nil {
+		return err
+	}
+	defer syscall.Flock(int(lock.Fd()), syscall.LOCK_UN)
+
+	entries := load(p)
+	for k, e := range s.pending {
+		entries[k] = e
+	}
+
+	if err := save(p, entries); err != nil {
+		return err
+	}
+
+	s.entries = entries
+	s.pending = nil
+
+	return nil
+}
+
+func load(p string) map[string]entry {
+	entries := map[string]entry{}
+
+	buf, err := os.ReadFile(p)
+	if err != nil {
+		return entries
+	}
+
+	if err := json.Unmarshal(buf, &entries); err != nil {
+		return map[string]entry{}
+	}
+
+	return entries
+}
+
+func save(p string, entries map[string]entry) error {
+	// Drop expired entries
+	for k, e := range entries {
+		if time.Since(e.Created) > maxAge {
+			delete(entries, k)
+		}
+	}
+
+	buf, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so that concurrent
+	// invocations never read a partially written cache
+	tmp, err := os.CreateTemp(filepath.Dir(p), ".cache-*.json")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(buf); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), p)
+}
@@ -0,0 +1,80 @@
+package cache
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+)
+
+func TestGetSet(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+
+	type value struct {
+		Path string
+		Hash string
+	}
+
+	in := value{"/nix/store/2467crcbg119q5jb5nwqxm0c87ls3wnv-source", "abc"}
+	key := Key("project", "/home/user/project")
+
+	// Miss before set
+	out := value{}
+	if Get(key, &out) {
+		t.Fatal("expected cache miss before set")
+	}
+
+	// Hit after set
+	Set(key, in)
+	if !Get(key, &out) {
+		t.Fatal("expected cache hit after set")
+	}
+	if diff := deep.Equal(out, in); diff != nil {
+		t.Error(diff)
+	}
+
+	// Miss when refreshing
+	SetRefresh(true)
+	defer SetRefresh(false)
+	if Get(key, &out) {
+		t.Error("expected cache miss when refreshing")
+	}
+}
+
+func TestKey(t *testing.T) {
+	if Key("ab", "c") == Key("a", "bc") {
+		t.Error("keys with different parts should not collide")
+	}
+}
+
+func TestFlush(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "nilla-utils", "cache.json")
+
+	// Two invocations loading the cache before either writes to it
+	first, second := &store{entries: load(p)}, &store{entries: load(p)}
+
+	first.set("first", entry{json.RawMessage(`"a"`), time.Now()})
+	second.set("second", entry{json.RawMessage(`"b"`), time.Now()})
+
+	if _, err := os.Stat(p); !os.IsNotExist(err) {
+		t.Fatal("expected cache not to be written before flush")
+	}
+
+	if err := first.flush(p); err != nil {
+		t.Fatal(err)
+	}
+	if err := second.flush(p); err != nil {
+		t.Fatal(err)
+	}
+
+	// Neither value is lost
+	entries := load(p)
+	for _, key := range []string{"first", "second"} {
+		if _, ok := entries[key]; !ok {
+			t.Errorf("expected \"%s\" to be in the cache file", key)
+		}
+	}
+}
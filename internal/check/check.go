@@ -0,0 +1,179 @@
+package check
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/arnarg/nilla-utils/internal/nix"
+	"github.com/arnarg/nilla-utils/internal/util"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/sourcegraph/conc/pool"
+)
+
+// Status is the outcome of a single stage of a check.
+type Status int
+
+const (
+	StatusSkipped Status = iota
+	StatusPassed
+	StatusFailed
+)
+
+func (s Status) String() string {
+	switch s {
+	case StatusPassed:
+		return lipgloss.NewStyle().Foreground(lipgloss.Color("10")).SetString("✓ pass").String()
+	case StatusFailed:
+		return lipgloss.NewStyle().Foreground(lipgloss.Color("9")).SetString("✗ fail").String()
+	}
+	return lipgloss.NewStyle().Foreground(lipgloss.Color("8")).SetString("- skip").String()
+}
+
+// Result is the result of checking a single configuration.
+type Result struct {
+	Name  string
+	Eval  Status
+	Build Status
+	Error string
+}
+
+// Failed returns true if any stage of the check failed.
+func (r *Result) Failed() bool {
+	return r.Eval == StatusFailed || r.Build == StatusFailed
+}
+
+// Options controls how configurations are checked.
+type Options struct {
+	// Build every configuration after evaluating it.
+	Build bool
+	// Number of configurations to check concurrently.
+	Jobs int
+}
+
+// Run evaluates, and optionally builds, the attribute returned by `attr` for every
+// configuration name in the nix file, concurrently.
+func Run(ctx context.Context, file string, names []string, attr func(string) string, opts Options) []*Result {
+	results := make([]*Result, len(names))
+
+	jobs := opts.Jobs
+	if jobs < 1 {
+		jobs = 1
+	}
+
+	var mu sync.Mutex
+	p := pool.New().WithMaxGoroutines(jobs)
+
+	for i, name := range names {
+		p.Go(func() {
+			res := checkOne(ctx, file, name, attr(name), opts.Build)
+			results[i] = res
+
+			// Report progress as configurations finish
+			mu.Lock()
+			defer mu.Unlock()
+			if res.Failed() {
+				fmt.Fprintf(os.Stderr, "%s %s\n", StatusFailed, name)
+			} else {
+				fmt.Fprintf(os.Stderr, "%s %s\n", StatusPassed, name)
+			}
+		})
+	}
+
+	p.Wait()
+
+	return results
+}
+
+func checkOne(ctx context.Context, file, name, attr string, build bool) *Result {
+	res := &Result{Name: name}
+
+	// Evaluate derivation
+	drv, err := nix.EvalDrvPath(ctx, file, attr)
+	if err != nil {
+		res.Eval = StatusFailed
+		res.Error = errorSummary(err)
+		return res
+	}
+	res.Eval = StatusPassed
+
+	if !build {
+		return res
+	}
+
+	// Build derivation
+	if _, err := nix.Realise(ctx, drv); err != nil {
+		res.Build = StatusFailed
+		res.Error = errorSummary(err)
+		return res
+	}
+	res.Build = StatusPassed
+
+	return res
+}
+
+// Returns the first line of a nix error, or the first error of
+// untyped nix output.
+func errorSummary(err error) string {
+	if isNixError(err) {
+		return util.ErrorLine(err)
+	}
+
+	return FirstError(err.Error())
+}
+
+// FirstError extracts the first error message from nix's stderr output.
+func FirstError(stderr string) string {
+	err := nix.ParseError(stderr)
+	if isNixError(err) {
+		return util.ErrorLine(err)
+	}
+
+	// Fall back to the last non-empty line
+	lines := strings.Split(err.Error(), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
+}
+
+func isNixError(err error) bool {
+	var (
+		merr *nix.MissingAttributeError
+		eerr *nix.EvalError
+		berr *nix.BuildError
+	)
+	return errors.As(err, &merr) || errors.As(err, &eerr) || errors.As(err, &berr)
+}
+
+// Table renders the results as a pass/fail matrix.
+func Table(results []*Result) string {
+	headers := []string{"Configuration", "Evaluation", "Build", "Error"}
+	rows := [][]string{}
+	for _, res := range results {
+		rows = append(rows, []string{
+			res.Name,
+			res.Eval.String(),
+			res.Build.String(),
+			res.Error,
+		})
+	}
+
+	return util.RenderTable(headers, rows...)
+}
+
+// Err returns an error summarizing the failures, if there were any.
+func Err(results []*Result) error {
+	failed := 0
+	for _, res := range results {
+		if res.Failed() {
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d configurations failed", failed, len(results))
+	}
+
+	return nil
+}
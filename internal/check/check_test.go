@@ -0,0 +1,55 @@
+package check
+
+import "testing"
+
+func TestFirstError(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		out  string
+	}{
+		{
+			name: "single line error",
+			in:   "error: attribute 'foo' missing\n",
+			out:  "attribute 'foo' missing",
+		},
+		{
+			name: "error with trace",
+			in: "error:\n" +
+				"       … while evaluating the attribute 'config.system.build.toplevel'\n" +
+				"         at /nix/store/abc-source/nixos/modules/system/activation/top-level.nix:71:5:\n" +
+				"\n" +
+				"       error: The option `services.foo' does not exist.\n",
+			out: "The option `services.foo' does not exist.",
+		},
+		{
+			name: "error with ansi codes",
+			in:   "\x1b[31;1merror:\x1b[0m undefined variable 'pkgs'\n",
+			out:  "undefined variable 'pkgs'",
+		},
+		{
+			name: "multiple errors",
+			in: "error: undefined variable 'pkgs'\n" +
+				"\n" +
+				"       at /nix/store/abc-source/hosts/laptop.nix:3:5:\n" +
+				"\n" +
+				"error: 1 dependencies of derivation '/nix/store/abc-nixos-system-laptop.drv' failed to build\n",
+			out: "undefined variable 'pkgs'",
+		},
+		{
+			name: "without error prefix",
+			in:   "warning: something\nsomething went wrong\n",
+			out:  "something went wrong",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := FirstError(tt.in)
+
+			if out != tt.out {
+				t.Errorf("unexpected error: \"%s\" != \"%s\"", out, tt.out)
+			}
+		})
+	}
+}
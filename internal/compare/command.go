@@ -0,0 +1,187 @@
+package compare
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/arnarg/nilla-utils/internal/diff"
+	"github.com/arnarg/nilla-utils/internal/exec"
+	"github.com/arnarg/nilla-utils/internal/store"
+	"github.com/arnarg/nilla-utils/internal/util"
+	"github.com/charmbracelet/log"
+	"github.com/urfave/cli/v3"
+)
+
+// CommandFlags returns the flags of the diff command, describing
+// what --config compares with configUsage.
+func CommandFlags(configUsage string) []cli.Flag {
+	return []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "config",
+			Usage: configUsage,
+		},
+		&cli.BoolFlag{
+			Name:  "unified",
+			Usage: "Show unified diffs of modified files, implies --config",
+		},
+		&cli.StringSliceFlag{
+			Name:  "why",
+			Usage: "Show why the package `pname` is in the closure of <to>",
+		},
+	}
+}
+
+// Resolver resolves diff targets to generations, connecting to every host
+// at most once. Building configurations and finding generations is left to
+// the command.
+type Resolver struct {
+	// Build builds the configuration `name` from the project
+	// and returns the path to its output.
+	Build func(ctx context.Context, name string) (string, error)
+	// GenerationPath returns the path to generation `id` on the host
+	// of the executor, or the current generation if `id` is 0.
+	GenerationPath func(executor exec.Executor, id int) (string, error)
+	// Tree of the configuration files compared with --config.
+	ConfigTree diff.ConfigTree
+
+	local   exec.Executor
+	remotes map[string]exec.Executor
+}
+
+func (r *Resolver) executor(host string) (exec.Executor, error) {
+	if host == "" {
+		return r.local, nil
+	}
+	if executor, ok := r.remotes[host]; ok {
+		return executor, nil
+	}
+
+	log.Infof("Connecting to \"%s\"", host)
+	executor, err := exec.NewSSHExecutor(host)
+	if err != nil {
+		return nil, err
+	}
+	r.remotes[host] = executor
+
+	return executor, nil
+}
+
+func (r *Resolver) resolve(ctx context.Context, target *diff.Target) (*diff.Generation, error) {
+	// Build the configuration from the project
+	if target.Name != "" {
+		out, err := r.Build(ctx, target.Name)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintln(os.Stderr)
+
+		return &diff.Generation{Path: out, Executor: r.local}, nil
+	}
+
+	executor, err := r.executor(target.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	path := target.Path
+	if path == "" {
+		path, err = r.GenerationPath(executor, target.Generation)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	exists, err := store.PathExists(executor, path)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("\"%s\" not found", target)
+	}
+
+	return &diff.Generation{Path: path, Executor: executor}, nil
+}
+
+// Run runs the diff command, comparing the two targets given as arguments.
+func (r *Resolver) Run(ctx context.Context, cmd *cli.Command) error {
+	if cmd.Args().Len() != 2 {
+		return errors.New("two diff targets are required")
+	}
+
+	// Load advisories to check the closure of <to> against
+	advisories, err := LoadAdvisories(cmd)
+	if err != nil {
+		return err
+	}
+
+	r.local = exec.NewLocalExecutor()
+	r.remotes = map[string]exec.Executor{}
+
+	gens := []*diff.Generation{}
+	targets := []*diff.Target{}
+	for _, arg := range cmd.Args().Slice() {
+		target, err := diff.ParseTarget(arg)
+		if err != nil {
+			return err
+		}
+
+		gen, err := r.resolve(ctx, target)
+		if err != nil {
+			return err
+		}
+
+		targets = append(targets, target)
+		gens = append(gens, gen)
+	}
+
+	// Reports are headed by the compared targets
+	gens[1].Name = fmt.Sprintf("%s -> %s", targets[0], targets[1])
+
+	if err := Changes(gens[0], gens[1], gens[1].Name); err != nil {
+		return err
+	}
+
+	//
+	// Compare configuration files, systemd units and users
+	//
+	if cmd.Bool("config") || cmd.Bool("unified") {
+		fmt.Fprintln(os.Stderr)
+		util.PrintSection("Comparing configuration")
+
+		config, err := diff.CalculateConfig(gens[0], gens[1], r.ConfigTree, cmd.Bool("unified"))
+		if err != nil {
+			return err
+		}
+
+		if config.Empty() {
+			fmt.Fprintln(os.Stderr, "No configuration changes")
+		} else {
+			fmt.Fprint(os.Stderr, diff.RenderConfig(config))
+		}
+	}
+
+	//
+	// Check for vulnerabilities
+	//
+	if err := Vulnerabilities(advisories, gens[0], gens[1], ""); err != nil {
+		return err
+	}
+
+	//
+	// Explain why packages are in the closure
+	//
+	for _, pname := range cmd.StringSlice("why") {
+		fmt.Fprintln(os.Stderr)
+		util.PrintSection(fmt.Sprintf("Why %s is in %s", pname, targets[1]))
+
+		chains, err := diff.Why(gens[1], pname)
+		if err != nil {
+			return err
+		}
+		fmt.Fprint(os.Stderr, diff.RenderWhy(pname, chains))
+	}
+
+	return nil
+}
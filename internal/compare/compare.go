@@ -0,0 +1,96 @@
+package compare
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/arnarg/nilla-utils/internal/advisory"
+	"github.com/arnarg/nilla-utils/internal/diff"
+	"github.com/arnarg/nilla-utils/internal/util"
+	"github.com/urfave/cli/v3"
+)
+
+// Flags returns the global flags controlling how changes between
+// generations are compared, which are applied with Configure.
+func Flags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:  "format",
+			Usage: "Format of comparing changes, one of text, markdown or html. Reports other than text are written to stdout",
+			Value: "text",
+		},
+		&cli.StringFlag{
+			Name:  "advisories",
+			Usage: "Check new closures for vulnerabilities in the OSV advisories in `path`, a JSON file or directory",
+		},
+		&cli.StringFlag{
+			Name:  "advisory-whitelist",
+			Usage: "Ignore advisories listed in the vulnix compatible whitelist `file`",
+		},
+		&cli.BoolFlag{
+			Name:        "fail-on-downgrade",
+			Usage:       "Fail when comparing changes shows downgraded packages",
+			HideDefault: true,
+		},
+	}
+}
+
+// Configure applies the global flags returned by Flags.
+func Configure(ctx context.Context, cmd *cli.Command) (context.Context, error) {
+	diff.SetFailOnDowngrade(cmd.Bool("fail-on-downgrade"))
+
+	format, err := diff.ParseFormat(cmd.String("format"))
+	if err != nil {
+		return ctx, err
+	}
+	diff.SetFormat(format)
+
+	return ctx, nil
+}
+
+// LoadAdvisories loads the advisories to check new closures against.
+// Returns nil if no advisories were given.
+func LoadAdvisories(cmd *cli.Command) (*advisory.Database, error) {
+	return advisory.LoadWithWhitelist(cmd.String("advisories"), cmd.String("advisory-whitelist"))
+}
+
+// Changes compares the packages of two generations. The section header is
+// suffixed with the label, if not empty.
+func Changes(from, to *diff.Generation, label string) error {
+	printSection("Comparing changes", label)
+
+	return diff.Execute(from, to)
+}
+
+// Vulnerabilities checks the closure of to against the advisories, if any
+// were loaded. The section header is suffixed with the label, if not empty.
+func Vulnerabilities(advisories *advisory.Database, from, to *diff.Generation, label string) error {
+	if advisories == nil {
+		return nil
+	}
+
+	fmt.Fprintln(os.Stderr)
+	printSection("Checking vulnerabilities", label)
+
+	// Packages were already queried when comparing changes
+	before, err := diff.QueryPackages(from)
+	if err != nil {
+		return err
+	}
+	after, err := diff.QueryPackages(to)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprint(os.Stderr, advisory.Check(advisories, before, after).Render())
+
+	return nil
+}
+
+func printSection(text, label string) {
+	if label != "" {
+		text = fmt.Sprintf("%s (%s)", text, label)
+	}
+	util.PrintSection(text)
+}
@@ -0,0 +1,411 @@
+package diff
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/arnarg/nilla-utils/internal/exec"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/valyala/fastjson"
+)
+
+// ConfigTree describes where the configuration files of a generation are.
+type ConfigTree struct {
+	// Directory of configuration files in the generation.
+	Dir string
+	// Directory of systemd units, relative to Dir.
+	UnitDir string
+	// Where the configuration files end up, used when rendering.
+	Target string
+}
+
+var (
+	// NixOSConfigTree is the configuration of a NixOS generation.
+	NixOSConfigTree = ConfigTree{Dir: "etc", UnitDir: "systemd/system", Target: "/etc"}
+	// HomeConfigTree is the configuration of a home-manager generation.
+	HomeConfigTree = ConfigTree{Dir: "home-files", UnitDir: ".config/systemd/user", Target: "~"}
+)
+
+// ConfigChangeKind is the kind of change of a file or user.
+type ConfigChangeKind int
+
+const (
+	ConfigAdded ConfigChangeKind = iota
+	ConfigRemoved
+	ConfigModified
+)
+
+func (k ConfigChangeKind) String() string {
+	switch k {
+	case ConfigAdded:
+		return "added"
+	case ConfigRemoved:
+		return "removed"
+	}
+	return "modified"
+}
+
+type ConfigChange struct {
+	// Path relative to the configuration tree, or a user name.
+	Name string
+	Kind ConfigChangeKind
+	// Unified diff of a modified file, when requested.
+	Diff string
+}
+
+type ConfigDiff struct {
+	Tree  ConfigTree
+	Files []ConfigChange
+	Units []ConfigChange
+	Users []ConfigChange
+}
+
+// Empty returns true if no configuration changed.
+func (d *ConfigDiff) Empty() bool {
+	return len(d.Files) == 0 && len(d.Units) == 0 && len(d.Users) == 0
+}
+
+// CalculateConfig compares the configuration files, systemd units and users
+// of two generations. With unified set, modified files include a unified diff.
+func CalculateConfig(from, to *Generation, tree ConfigTree, unified bool) (*ConfigDiff, error) {
+	// Hash every configuration file
+	beforeFiles, err := hashConfigFiles(from.Executor, fmt.Sprintf("%s/%s", from.Path, tree.Dir))
+	if err != nil {
+		return nil, err
+	}
+	afterFiles, err := hashConfigFiles(to.Executor, fmt.Sprintf("%s/%s", to.Path, tree.Dir))
+	if err != nil {
+		return nil, err
+	}
+
+	config := &ConfigDiff{
+		Tree:  tree,
+		Files: []ConfigChange{},
+		Units: []ConfigChange{},
+	}
+
+	// Systemd units are reported on their own
+	unitPrefix := tree.UnitDir + "/"
+	for _, change := range compareConfig(beforeFiles, afterFiles) {
+		if unified && change.Kind == ConfigModified {
+			before, after := beforeFiles[change.Name], afterFiles[change.Name]
+			if strings.HasPrefix(before, linkPrefix) || strings.HasPrefix(after, linkPrefix) {
+				change.Diff = diffConfigLink(before, after)
+			} else {
+				change.Diff, err = diffConfigFile(from, to, tree, change.Name)
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		if unit, ok := strings.CutPrefix(change.Name, unitPrefix); ok {
+			change.Name = unit
+			config.Units = append(config.Units, change)
+		} else {
+			config.Files = append(config.Files, change)
+		}
+	}
+
+	// Compare declared users
+	beforeUsers, err := queryUsers(from.Executor, from.Path)
+	if err != nil {
+		return nil, err
+	}
+	afterUsers, err := queryUsers(to.Executor, to.Path)
+	if err != nil {
+		return nil, err
+	}
+	config.Users = compareConfig(beforeUsers, afterUsers)
+
+	return config, nil
+}
+
+// compareConfig compares two maps of names to hashes.
+func compareConfig(before, after map[string]string) []ConfigChange {
+	changes := []ConfigChange{}
+
+	for name, hash := range after {
+		beforeHash, ok := before[name]
+		if !ok {
+			changes = append(changes, ConfigChange{Name: name, Kind: ConfigAdded})
+		} else if beforeHash != hash {
+			changes = append(changes, ConfigChange{Name: name, Kind: ConfigModified})
+		}
+	}
+	for name := range before {
+		if _, ok := after[name]; !ok {
+			changes = append(changes, ConfigChange{Name: name, Kind: ConfigRemoved})
+		}
+	}
+
+	slices.SortFunc(changes, func(a, b ConfigChange) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	return changes
+}
+
+// Hashes of links that are not followed are their target with this prefix.
+const linkPrefix = "link:"
+
+// hashConfigFiles returns a hash of every file in dir, by their path
+// relative to dir. Regular files and links to files in the nix store, which
+// every file in the configuration trees is, are hashed by their content.
+// Links to directories in the nix store, like etc/systemd/system, are
+// descended into. Other links, e.g. to the live /etc, are hashed by their
+// target and never followed.
+func hashConfigFiles(executor exec.Executor, dir string) (map[string]string, error) {
+	exists, err := executor.PathExists(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return map[string]string{}, nil
+	}
+
+	hashes := map[string]string{}
+	files := []string{}
+
+	// Every store directory is only descended into once,
+	// so links back into it can't make us loop
+	visited := map[string]bool{}
+
+	for queue := []string{dir}; len(queue) > 0; queue = queue[1:] {
+		// The trailing slash makes find descend into links
+		out, err := exec.Output(
+			executor,
+			"find", queue[0]+"/", "(", "-type", "f", "-o", "-type", "l", ")",
+			"-printf", `%y\t%Y\t%l\t%p\n`,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		entries := parseConfigFiles(out, dir)
+		for _, entry := range entries {
+			switch {
+			case entry.kind == "f" || (entry.target == "f" && isStoreLink(entry.link)):
+				files = append(files, entry.path)
+
+			case entry.target == "d" && isStoreLink(entry.link) && !visited[entry.link]:
+				visited[entry.link] = true
+				queue = append(queue, entry.path)
+
+			default:
+				hashes[entry.rel] = linkPrefix + entry.link
+			}
+		}
+	}
+
+	if len(files) < 1 {
+		return hashes, nil
+	}
+
+	out, err := exec.Output(executor, "sha256sum", append([]string{"--"}, files...)...)
+	if err != nil {
+		return nil, err
+	}
+	maps.Copy(hashes, parseHashes(out, dir))
+
+	return hashes, nil
+}
+
+// Store directory links are followed into, overridden in tests.
+var storeDir = "/nix/store/"
+
+func isStoreLink(link string) bool {
+	return strings.HasPrefix(link, storeDir)
+}
+
+// configFile is a file or link found in a configuration tree.
+type configFile struct {
+	// Type of the file and of its target if it's a link, like find's %y and %Y
+	kind   string
+	target string
+	// Target of the link, empty for files
+	link string
+	path string
+	// Path relative to the configuration tree
+	rel string
+}
+
+// parseConfigFiles parses the output of find in hashConfigFiles.
+func parseConfigFiles(out []byte, dir string) []configFile {
+	files := []configFile{}
+
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.SplitN(scanner.Text(), "\t", 4)
+		if len(fields) != 4 {
+			continue
+		}
+
+		rel, ok := strings.CutPrefix(fields[3], dir+"/")
+		if !ok {
+			continue
+		}
+
+		files = append(files, configFile{
+			kind:   fields[0],
+			target: fields[1],
+			link:   fields[2],
+			path:   fields[3],
+			rel:    rel,
+		})
+	}
+
+	return files
+}
+
+// parseHashes parses the output of sha256sum.
+func parseHashes(out []byte, dir string) map[string]string {
+	hashes := map[string]string{}
+
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		hash, path, ok := strings.Cut(scanner.Text(), "  ")
+		if !ok {
+			continue
+		}
+
+		if rel, ok := strings.CutPrefix(path, dir+"/"); ok {
+			hashes[rel] = hash
+		}
+	}
+
+	return hashes
+}
+
+func diffConfigFile(from, to *Generation, tree ConfigTree, name string) (string, error) {
+	before, err := exec.Output(from.Executor, "cat", fmt.Sprintf("%s/%s/%s", from.Path, tree.Dir, name))
+	if err != nil {
+		return "", err
+	}
+	after, err := exec.Output(to.Executor, "cat", fmt.Sprintf("%s/%s/%s", to.Path, tree.Dir, name))
+	if err != nil {
+		return "", err
+	}
+
+	if bytes.IndexByte(before, 0) >= 0 || bytes.IndexByte(after, 0) >= 0 {
+		return "Binary files differ\n", nil
+	}
+
+	return UnifiedDiff(
+		string(before), string(after),
+		fmt.Sprintf("a/%s", name), fmt.Sprintf("b/%s", name),
+		3,
+	), nil
+}
+
+// diffConfigLink describes a change of a link that is not followed.
+func diffConfigLink(before, after string) string {
+	target := func(hash string) string {
+		if link, ok := strings.CutPrefix(hash, linkPrefix); ok {
+			return link
+		}
+		return "(file)"
+	}
+
+	return fmt.Sprintf("Link changed: %s -> %s\n", target(before), target(after))
+}
+
+var usersSpecRegex = regexp.MustCompile(`/nix/store/[a-z0-9]+-users-groups\.json`)
+
+// queryUsers returns the users declared in a NixOS generation, which are
+// found in the users and groups spec its activation script applies.
+// Generations without such spec have no users.
+func queryUsers(executor exec.Executor, path string) (map[string]string, error) {
+	activate := fmt.Sprintf("%s/activate", path)
+
+	exists, err := executor.PathExists(activate)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return map[string]string{}, nil
+	}
+
+	script, err := exec.Output(executor, "cat", activate)
+	if err != nil {
+		return nil, err
+	}
+
+	spec := usersSpecRegex.Find(script)
+	if spec == nil {
+		return map[string]string{}, nil
+	}
+
+	buf, err := exec.Output(executor, "cat", string(spec))
+	if err != nil {
+		return nil, err
+	}
+
+	return parseUsers(buf)
+}
+
+// parseUsers returns every user in a users and groups spec,
+// with their serialized settings to detect modifications.
+func parseUsers(buf []byte) (map[string]string, error) {
+	val, err := fastjson.ParseBytes(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	users := map[string]string{}
+	for _, user := range val.GetArray("users") {
+		users[string(user.GetStringBytes("name"))] = user.String()
+	}
+
+	return users, nil
+}
+
+var configChangeColors = map[ConfigChangeKind]lipgloss.Color{
+	ConfigAdded:    lipgloss.Color("10"),
+	ConfigRemoved:  lipgloss.Color("9"),
+	ConfigModified: lipgloss.Color("3"),
+}
+
+func renderConfigChanges(strb *strings.Builder, title string, changes []ConfigChange, name func(string) string) {
+	if len(changes) < 1 {
+		return
+	}
+
+	fmt.Fprintf(strb, "%s:\n", title)
+	for i, change := range changes {
+		fmt.Fprintf(
+			strb,
+			"#%02d  %s  %s\n",
+			i+1,
+			lipgloss.NewStyle().
+				Width(8).
+				Foreground(configChangeColors[change.Kind]).
+				SetString(change.Kind.String()).
+				String(),
+			name(change.Name),
+		)
+		strb.WriteString(change.Diff)
+	}
+}
+
+// RenderConfig renders the changes of a configuration diff.
+func RenderConfig(config *ConfigDiff) string {
+	strb := &strings.Builder{}
+
+	renderConfigChanges(strb, "Changed files", config.Files, func(name string) string {
+		return fmt.Sprintf("%s/%s", config.Tree.Target, name)
+	})
+	renderConfigChanges(strb, "Changed systemd units", config.Units, func(name string) string {
+		return name
+	})
+	renderConfigChanges(strb, "Changed users", config.Users, func(name string) string {
+		return name
+	})
+
+	return strb.String()
+}
@@ -0,0 +1,241 @@
+package diff
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/arnarg/nilla-utils/internal/exec"
+	"github.com/go-test/deep"
+)
+
+func writeFiles(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCalculateConfig(t *testing.T) {
+	from := t.TempDir()
+	to := t.TempDir()
+
+	writeFiles(t, from, map[string]string{
+		"etc/ssh/sshd_config":            "PermitRootLogin no\nPort 22\n",
+		"etc/hostname":                   "foo\n",
+		"etc/motd":                       "hello\n",
+		"etc/systemd/system/foo.service": "[Service]\nExecStart=foo\n",
+	})
+	writeFiles(t, to, map[string]string{
+		"etc/ssh/sshd_config":            "PermitRootLogin yes\nPort 22\n",
+		"etc/hostname":                   "foo\n",
+		"etc/systemd/system/foo.service": "[Service]\nExecStart=foo\n",
+		"etc/systemd/system/bar.service": "[Service]\nExecStart=bar\n",
+	})
+
+	executor := exec.NewLocalExecutor()
+	config, err := CalculateConfig(
+		&Generation{Path: from, Executor: executor},
+		&Generation{Path: to, Executor: executor},
+		NixOSConfigTree,
+		true,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := &ConfigDiff{
+		Tree: NixOSConfigTree,
+		Files: []ConfigChange{
+			{Name: "motd", Kind: ConfigRemoved},
+			{
+				Name: "ssh/sshd_config",
+				Kind: ConfigModified,
+				Diff: "--- a/ssh/sshd_config\n+++ b/ssh/sshd_config\n" +
+					"@@ -1,2 +1,2 @@\n" +
+					"-PermitRootLogin no\n" +
+					"+PermitRootLogin yes\n" +
+					" Port 22\n",
+			},
+		},
+		Units: []ConfigChange{
+			{Name: "bar.service", Kind: ConfigAdded},
+		},
+		Users: []ConfigChange{},
+	}
+
+	if diff := deep.Equal(config, expected); diff != nil {
+		t.Error(diff)
+	}
+
+	rendered := RenderConfig(config)
+	expectedRender := "Changed files:\n" +
+		"#01  removed   /etc/motd\n" +
+		"#02  modified  /etc/ssh/sshd_config\n" +
+		expected.Files[1].Diff +
+		"Changed systemd units:\n" +
+		"#01  added     bar.service\n"
+
+	if rendered != expectedRender {
+		t.Errorf("unexpected output:\n%s", rendered)
+	}
+}
+
+func TestCalculateConfigLinks(t *testing.T) {
+	// The live file links outside of the store point to
+	live := filepath.Join(t.TempDir(), "hostname")
+	if err := os.WriteFile(live, []byte("foo\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	gens := []string{}
+	for _, zone := range []string{"/etc/zoneinfo/UTC", "/etc/zoneinfo/CET"} {
+		root := t.TempDir()
+		writeFiles(t, root, map[string]string{"etc-tree/motd": "hello\n"})
+
+		// The configuration tree itself is a link, like in generations
+		links := map[string]string{
+			"etc":                 filepath.Join(root, "etc-tree"),
+			"etc-tree/hostname":   live,
+			"etc-tree/localtime":  zone,
+			"etc-tree/loop":       "loop",
+			"etc-tree/motd.local": "motd",
+		}
+		for name, target := range links {
+			if err := os.Symlink(target, filepath.Join(root, name)); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		gens = append(gens, root)
+	}
+
+	// Links are compared by their targets, so the loop doesn't abort the diff
+	executor := exec.NewLocalExecutor()
+	config, err := CalculateConfig(
+		&Generation{Path: gens[0], Executor: executor},
+		&Generation{Path: gens[1], Executor: executor},
+		NixOSConfigTree,
+		true,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []ConfigChange{
+		{
+			Name: "localtime",
+			Kind: ConfigModified,
+			Diff: "Link changed: /etc/zoneinfo/UTC -> /etc/zoneinfo/CET\n",
+		},
+	}
+
+	if diff := deep.Equal(config.Files, expected); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestCalculateConfigStoreLinks(t *testing.T) {
+	store := t.TempDir()
+	defer func(dir string) { storeDir = dir }(storeDir)
+	storeDir = store + "/"
+
+	writeFiles(t, store, map[string]string{
+		"aaaa-system-units/foo.service": "[Service]\nExecStart=foo\n",
+		"bbbb-system-units/foo.service": "[Service]\nExecStart=foo --verbose\n",
+		"bbbb-system-units/bar.service": "[Service]\nExecStart=bar\n",
+		"cccc-hostname":                 "foo\n",
+	})
+	if err := os.MkdirAll(filepath.Join(store, "dddd-shared"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	// Links back into a store directory must not make the walk loop
+	if err := os.Symlink(filepath.Join(store, "dddd-shared"), filepath.Join(store, "dddd-shared", "loop")); err != nil {
+		t.Fatal(err)
+	}
+
+	gens := []string{}
+	for _, units := range []string{"aaaa-system-units", "bbbb-system-units"} {
+		root := t.TempDir()
+
+		// Units are a link to their own store directory, like in generations
+		links := map[string]string{
+			"etc/systemd/system": filepath.Join(store, units),
+			"etc/hostname":       filepath.Join(store, "cccc-hostname"),
+			"etc/shared":         filepath.Join(store, "dddd-shared"),
+		}
+		for name, target := range links {
+			path := filepath.Join(root, name)
+			if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Symlink(target, path); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		gens = append(gens, root)
+	}
+
+	executor := exec.NewLocalExecutor()
+	config, err := CalculateConfig(
+		&Generation{Path: gens[0], Executor: executor},
+		&Generation{Path: gens[1], Executor: executor},
+		NixOSConfigTree,
+		false,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := &ConfigDiff{
+		Tree:  NixOSConfigTree,
+		Files: []ConfigChange{},
+		Units: []ConfigChange{
+			{Name: "bar.service", Kind: ConfigAdded},
+			{Name: "foo.service", Kind: ConfigModified},
+		},
+		Users: []ConfigChange{},
+	}
+
+	if diff := deep.Equal(config, expected); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestParseUsers(t *testing.T) {
+	spec := `{
+		"groups": [{"name": "wheel", "gid": 1, "members": ["alice"]}],
+		"users": [
+			{"name": "alice", "uid": 1000, "isSystemUser": false},
+			{"name": "bob", "uid": 1001, "isSystemUser": false}
+		]
+	}`
+
+	users, err := parseUsers([]byte(spec))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	after := map[string]string{
+		"alice": `{"name":"alice","uid":1000,"isSystemUser":true}`,
+		"carol": `{"name":"carol","uid":1002,"isSystemUser":false}`,
+	}
+
+	expected := []ConfigChange{
+		{Name: "alice", Kind: ConfigModified},
+		{Name: "bob", Kind: ConfigRemoved},
+		{Name: "carol", Kind: ConfigAdded},
+	}
+
+	if diff := deep.Equal(compareConfig(users, after), expected); diff != nil {
+		t.Error(diff)
+	}
+}
@@ -6,7 +6,9 @@ import (
 	"os"
 	"regexp"
 	"slices"
+	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/arnarg/nilla-utils/internal/exec"
 	"github.com/arnarg/nilla-utils/internal/util"
@@ -18,23 +20,75 @@ import (
 type Package struct {
 	pname   string
 	version string
+	output  string
 	path    string
 }
 
+// Names of outputs that are stripped from versions, so that every
+// output of a package is grouped with the package.
+var outputNames = []string{
+	"bin",
+	"debug",
+	"dev",
+	"devdoc",
+	"dist",
+	"doc",
+	"info",
+	"lib",
+	"man",
+	"modules",
+	"out",
+	"static",
+	"terminfo",
+}
+
+var storeHashRegex = regexp.MustCompile(`^[a-z0-9]+$`)
+
+// parseDrvName splits a derivation name into a name and version like
+// `builtins.parseDrvName`. The version starts after the first dash that
+// is not followed by a letter.
+func parseDrvName(s string) (string, string) {
+	for i := 0; i+1 < len(s); i++ {
+		c := s[i+1]
+		if s[i] == '-' && !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z') {
+			return s[:i], s[i+1:]
+		}
+	}
+	return s, ""
+}
+
+// cutOutput removes the output name from the end of a version.
+func cutOutput(version string) (string, string) {
+	i := strings.LastIndexByte(version, '-')
+	if i < 0 || !slices.Contains(outputNames, version[i+1:]) {
+		return version, ""
+	}
+	return version[:i], version[i+1:]
+}
+
 func ParsePackageFromPath(path string) *Package {
 	// Parse nix store path
-	res := regexp.
-		MustCompile(`^/nix/store/[a-z0-9]+-(.+?)(?:-([0-9].*?))?(?:\.drv)?$`).
-		FindStringSubmatch(path)
+	base, ok := strings.CutPrefix(path, "/nix/store/")
+	if !ok {
+		return nil
+	}
+	hash, name, ok := strings.Cut(base, "-")
+	if !ok || !storeHashRegex.MatchString(hash) || strings.ContainsRune(name, '/') {
+		return nil
+	}
 
-	// Check if it could be parsed
-	if len(res) < 3 {
+	name = strings.TrimSuffix(name, ".drv")
+	if name == "" {
 		return nil
 	}
 
+	pname, version := parseDrvName(name)
+	version, output := cutOutput(version)
+
 	return &Package{
-		pname:   res[1],
-		version: res[2],
+		pname:   pname,
+		version: version,
+		output:  output,
 		path:    path,
 	}
 }
@@ -42,11 +96,13 @@ func ParsePackageFromPath(path string) *Package {
 type PackageSet struct {
 	pnames   set.Unordered[string]
 	packages map[string]set.Unordered[string]
+	paths    map[string]set.Unordered[string]
 }
 
 func NewPackageSet(paths []string) PackageSet {
 	pnames := make(set.Unordered[string])
 	packages := map[string]set.Unordered[string]{}
+	pkgPaths := map[string]set.Unordered[string]{}
 
 	for _, p := range paths {
 		pkg := ParsePackageFromPath(p)
@@ -65,6 +121,12 @@ func NewPackageSet(paths []string) PackageSet {
 		lst := packages[pkg.pname]
 		lst.Add(pkg.version)
 
+		// Add store path to map, to detect rebuilds
+		if _, ok := pkgPaths[pkg.pname]; !ok {
+			pkgPaths[pkg.pname] = make(set.Unordered[string])
+		}
+		pkgPaths[pkg.pname].Add(pkg.path)
+
 		// Add package to pnames
 		pnames.Add(pkg.pname)
 	}
@@ -72,6 +134,7 @@ func NewPackageSet(paths []string) PackageSet {
 	return PackageSet{
 		pnames:   pnames,
 		packages: packages,
+		paths:    pkgPaths,
 	}
 }
 
@@ -90,16 +153,85 @@ func (s *PackageSet) GetPackagesVersions(pname string) set.Unordered[string] {
 	return s.packages[pname]
 }
 
+func (s *PackageSet) storePaths(pname string) set.Unordered[string] {
+	return s.paths[pname]
+}
+
+// equalSets is like set.Equal, but two empty sets are also equal.
+func equalSets(a, b set.Unordered[string]) bool {
+	if len(a) == 0 && len(b) == 0 {
+		return true
+	}
+	return set.Equal(a, b)
+}
+
+// Versions returns the sorted versions of every package in the set.
+func (s *PackageSet) Versions() map[string][]string {
+	versions := map[string][]string{}
+	for pname, pkgs := range s.packages {
+		versions[pname] = set.ToSlice(pkgs)
+		slices.Sort(versions[pname])
+	}
+	return versions
+}
+
 func (s *PackageSet) NumPackages() int {
 	return len(s.packages)
 }
 
+// ChangeKind classifies the change of a package in a diff.
+type ChangeKind int
+
+const (
+	// ChangeNone is used for added and removed packages.
+	ChangeNone ChangeKind = iota
+	// ChangeUpgrade means the newest version is newer than before.
+	ChangeUpgrade
+	// ChangeDowngrade means the newest version is older than before.
+	ChangeDowngrade
+	// ChangeRebuild means the newest version is the same as before,
+	// but the package was rebuilt or its outputs changed.
+	ChangeRebuild
+)
+
+func (k ChangeKind) String() string {
+	switch k {
+	case ChangeUpgrade:
+		return "upgrade"
+	case ChangeDowngrade:
+		return "downgrade"
+	case ChangeRebuild:
+		return "rebuild"
+	}
+	return ""
+}
+
 type PackageDiff struct {
 	PName  string
+	Kind   ChangeKind
 	Before []string
 	After  []string
 }
 
+// newestVersion returns the newest of the versions.
+func newestVersion(versions []string) string {
+	if len(versions) < 1 {
+		return ""
+	}
+	return slices.MaxFunc(versions, CompareVersions)
+}
+
+// classifyChange compares the newest versions before and after.
+func classifyChange(before, after []string) ChangeKind {
+	switch CompareVersions(newestVersion(before), newestVersion(after)) {
+	case -1:
+		return ChangeUpgrade
+	case 1:
+		return ChangeDowngrade
+	}
+	return ChangeRebuild
+}
+
 func sortPackageDiff(a, b PackageDiff) int {
 	return strings.Compare(strings.ToLower(a.PName), strings.ToLower(b.PName))
 }
@@ -110,6 +242,33 @@ type Diff struct {
 	Removed []PackageDiff
 }
 
+// Count returns the number of changed packages of the kind.
+func (d *Diff) Count(kind ChangeKind) int {
+	count := 0
+	for _, pkg := range d.Changed {
+		if pkg.Kind == kind {
+			count++
+		}
+	}
+	return count
+}
+
+// Downgrades returns the packages that were downgraded.
+func (d *Diff) Downgrades() []PackageDiff {
+	downgrades := []PackageDiff{}
+	for _, pkg := range d.Changed {
+		if pkg.Kind == ChangeDowngrade {
+			downgrades = append(downgrades, pkg)
+		}
+	}
+	return downgrades
+}
+
+// Empty returns true if no packages changed.
+func (d *Diff) Empty() bool {
+	return len(d.Changed) == 0 && len(d.Added) == 0 && len(d.Removed) == 0
+}
+
 func Calculate(from, to PackageSet) Diff {
 	changed := []PackageDiff{}
 	added := []PackageDiff{}
@@ -126,25 +285,35 @@ func Calculate(from, to PackageSet) Diff {
 		fromVersions := from.GetPackagesVersions(pname)
 		toVersions := to.GetPackagesVersions(pname)
 
-		if !set.Equal(fromVersions, toVersions) {
-			// Get slices with version before and after
-			before := set.ToSlice(fromVersions)
-			after := set.ToSlice(toVersions)
-
-			// Sort slices
-			slices.Sort(before)
-			slices.Sort(after)
-
-			// Append diff to list
-			changed = append(
-				changed,
-				PackageDiff{
-					PName:  pname,
-					Before: before,
-					After:  after,
-				},
-			)
+		// Same versions in different store paths means a rebuild
+		sameVersions := set.Equal(fromVersions, toVersions)
+		if sameVersions && equalSets(from.storePaths(pname), to.storePaths(pname)) {
+			continue
 		}
+
+		// Get slices with version before and after
+		before := set.ToSlice(fromVersions)
+		after := set.ToSlice(toVersions)
+
+		// Sort slices
+		slices.Sort(before)
+		slices.Sort(after)
+
+		kind := ChangeRebuild
+		if !sameVersions {
+			kind = classifyChange(before, after)
+		}
+
+		// Append diff to list
+		changed = append(
+			changed,
+			PackageDiff{
+				PName:  pname,
+				Kind:   kind,
+				Before: before,
+				After:  after,
+			},
+		)
 	}
 	slices.SortFunc(changed, sortPackageDiff)
 
@@ -190,6 +359,29 @@ func Calculate(from, to PackageSet) Diff {
 type Generation struct {
 	Path     string
 	Executor exec.Executor
+	// Name of the generation, e.g. a system name, used in reports.
+	Name string
+
+	// Closure and packages in it, set when first queried
+	mu             sync.Mutex
+	closure        *generationClosure
+	closureQueried bool
+	packages       *PackageSet
+}
+
+// setPackages caches the packages in the closure of the generation.
+func (g *Generation) setPackages(packages PackageSet) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.packages = &packages
+}
+
+// cachedPackages returns the cached packages in the closure
+// of the generation, or nil if they were not queried yet.
+func (g *Generation) cachedPackages() *PackageSet {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.packages
 }
 
 type ClosureDiff struct {
@@ -197,17 +389,19 @@ type ClosureDiff struct {
 	NumAfter    int
 	BytesBefore int64
 	BytesAfter  int64
+	// Size changes of the packages in the diff, biggest change first.
+	Packages []PackageSize
 }
 
 func Run(from, to *Generation) (*Diff, *ClosureDiff, error) {
 	// Query before
-	beforePaths, err := queryGeneration(from.Path, from.Executor)
+	beforePaths, beforeSizes, err := queryContents(from)
 	if err != nil {
 		return nil, nil, err
 	}
 
 	// Query after
-	afterPaths, err := queryGeneration(to.Path, to.Executor)
+	afterPaths, afterSizes, err := queryContents(to)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -215,6 +409,8 @@ func Run(from, to *Generation) (*Diff, *ClosureDiff, error) {
 	// Parse paths
 	before := NewPackageSet(beforePaths)
 	after := NewPackageSet(afterPaths)
+	from.setPackages(before)
+	to.setPackages(after)
 
 	// Calculate diff
 	diff := Calculate(before, after)
@@ -225,37 +421,77 @@ func Run(from, to *Generation) (*Diff, *ClosureDiff, error) {
 		NumAfter:  after.NumPackages(),
 	}
 
-	// Get closure size from before
-	closure.BytesBefore, err = getClosureSize(from.Executor, from.Path)
+	// The closure size is the sum of all path sizes
+	closure.BytesBefore = sumSizes(beforeSizes)
+	closure.BytesAfter = sumSizes(afterSizes)
+	closure.Packages = calculateSizes(&diff, before, after, beforeSizes, afterSizes)
+
+	return &diff, closure, nil
+}
+
+// queryContents returns the paths of the packages in a generation
+// and the size of every path in its closure.
+func queryContents(gen *Generation) ([]string, map[string]int64, error) {
+	source, err := gen.source()
 	if err != nil {
 		return nil, nil, err
 	}
 
-	// Get closure size from after
-	closure.BytesAfter, err = getClosureSize(to.Executor, to.Path)
+	paths, err := source.packagePaths()
 	if err != nil {
 		return nil, nil, err
 	}
 
-	return &diff, closure, nil
+	// Get sizes of every path in the closure
+	sizes, err := source.sizes()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return paths, sizes, nil
 }
 
-func queryGeneration(path string, executor exec.Executor) ([]string, error) {
+// QueryPackages returns the packages in the closure of a generation.
+// They are only queried once per generation, also when compared with Run.
+func QueryPackages(gen *Generation) (PackageSet, error) {
+	if packages := gen.cachedPackages(); packages != nil {
+		return *packages, nil
+	}
+
+	source, err := gen.source()
+	if err != nil {
+		return PackageSet{}, err
+	}
+
+	paths, err := source.packagePaths()
+	if err != nil {
+		return PackageSet{}, err
+	}
+
+	packages := NewPackageSet(paths)
+	gen.setPackages(packages)
+
+	return packages, nil
+}
+
+// packagePaths returns the paths of the packages in the system path
+// and every path in the closure.
+func (c *cliClosure) packagePaths() ([]string, error) {
 	paths := []string{}
 
-	swPath := path + "/sw"
+	swPath := c.gen.Path + "/sw"
 
 	// Check if /sw exists
-	swExists, err := executor.PathExists(swPath)
+	swExists, err := c.gen.Executor.PathExists(swPath)
 	if err != nil {
 		return nil, err
 	}
 	if !swExists {
-		swPath = path
+		swPath = c.gen.Path
 	}
 
 	// Query references
-	refs, err := runNixStoreOutput(executor, "--query", "--references", swPath)
+	refs, err := exec.Output(c.gen.Executor, "nix-store", "--query", "--references", swPath)
 	if err != nil {
 		return nil, err
 	}
@@ -263,7 +499,7 @@ func queryGeneration(path string, executor exec.Executor) ([]string, error) {
 	paths = append(paths, strings.Split(string(refs), "\n")...)
 
 	// Query requisites
-	reqs, err := runNixStoreOutput(executor, "--query", "--requisites", path)
+	reqs, err := exec.Output(c.gen.Executor, "nix-store", "--query", "--requisites", c.gen.Path)
 	if err != nil {
 		return nil, err
 	}
@@ -273,31 +509,13 @@ func queryGeneration(path string, executor exec.Executor) ([]string, error) {
 	return paths, nil
 }
 
-func runNixStoreOutput(executor exec.Executor, args ...string) ([]byte, error) {
+// closureSize returns the closure size of the generation.
+func (c *cliClosure) closureSize() (int64, error) {
 	// Create buffer for output
 	buf := &bytes.Buffer{}
 
 	// Create command from executor
-	cmd, err := executor.Command("nix-store", args...)
-	if err != nil {
-		return nil, err
-	}
-	cmd.SetStdout(buf)
-
-	// Run command
-	if err := cmd.Run(); err != nil {
-		return nil, err
-	}
-
-	return buf.Bytes(), nil
-}
-
-func getClosureSize(executor exec.Executor, path string) (int64, error) {
-	// Create buffer for output
-	buf := &bytes.Buffer{}
-
-	// Create command from executor
-	cmd, err := executor.Command("nix", "path-info", "--json", "--closure-size", path)
+	cmd, err := c.gen.Executor.Command("nix", "path-info", "--json", "--closure-size", c.gen.Path)
 	if err != nil {
 		return 0, err
 	}
@@ -317,6 +535,16 @@ func getClosureSize(executor exec.Executor, path string) (int64, error) {
 	return size, nil
 }
 
+// ClosureSize returns the closure size of a generation in bytes.
+func ClosureSize(gen *Generation) (int64, error) {
+	source, err := gen.source()
+	if err != nil {
+		return 0, err
+	}
+
+	return source.closureSize()
+}
+
 func decodeClosureSize(buf []byte) (int64, error) {
 	val, err := fastjson.ParseBytes(buf)
 	if err != nil {
@@ -355,24 +583,39 @@ func decodeClosureSize(buf []byte) (int64, error) {
 	return 0, nil
 }
 
-func Print(diff *Diff) {
-	if len(diff.Changed) > 0 {
-		fmt.Println("Version changes:")
+// Colors of changed package names by the kind of change.
+var changeColors = map[ChangeKind]lipgloss.Color{
+	ChangeUpgrade:   lipgloss.Color("10"),
+	ChangeDowngrade: lipgloss.Color("9"),
+	ChangeRebuild:   lipgloss.Color("12"),
+}
+
+// Render renders the package changes of a diff.
+func Render(diff *Diff) string {
+	strb := &strings.Builder{}
+
+	// Packages rebuilt without version changes are only counted
+	versionChanges := slices.DeleteFunc(slices.Clone(diff.Changed), func(pkg PackageDiff) bool {
+		return slices.Equal(pkg.Before, pkg.After)
+	})
+
+	if len(versionChanges) > 0 {
+		strb.WriteString("Version changes:\n")
 		widest := 0
-		for _, pkg := range diff.Changed {
+		for _, pkg := range versionChanges {
 			if len(pkg.PName) > widest {
 				widest = len(pkg.PName)
 			}
 		}
 
-		for i, pkg := range diff.Changed {
+		for i, pkg := range versionChanges {
 			fmt.Fprintf(
-				os.Stderr,
+				strb,
 				"#%02d  %s  %s -> %s\n",
 				i+1,
 				lipgloss.NewStyle().
 					Width(widest).
-					Foreground(lipgloss.Color("10")).
+					Foreground(changeColors[pkg.Kind]).
 					SetString(pkg.PName).
 					String(),
 				lipgloss.NewStyle().
@@ -388,7 +631,7 @@ func Print(diff *Diff) {
 	}
 
 	if len(diff.Added) > 0 {
-		fmt.Println("Added packages:")
+		strb.WriteString("Added packages:\n")
 		widest := 0
 		for _, pkg := range diff.Added {
 			if len(pkg.PName) > widest {
@@ -398,7 +641,7 @@ func Print(diff *Diff) {
 
 		for i, pkg := range diff.Added {
 			fmt.Fprintf(
-				os.Stderr,
+				strb,
 				"#%02d  %s  %s\n",
 				i+1,
 				lipgloss.NewStyle().
@@ -415,7 +658,7 @@ func Print(diff *Diff) {
 	}
 
 	if len(diff.Removed) > 0 {
-		fmt.Println("Removed packages:")
+		strb.WriteString("Removed packages:\n")
 		widest := 0
 		for _, pkg := range diff.Removed {
 			if len(pkg.PName) > widest {
@@ -425,7 +668,7 @@ func Print(diff *Diff) {
 
 		for i, pkg := range diff.Removed {
 			fmt.Fprintf(
-				os.Stderr,
+				strb,
 				"#%02d  %s  %s\n",
 				i+1,
 				lipgloss.NewStyle().
@@ -440,29 +683,37 @@ func Print(diff *Diff) {
 			)
 		}
 	}
-}
 
-func Execute(from, to *Generation) error {
-	// Just execute nvd diff if both are local, for now
-	if from.Executor.IsLocal() && to.Executor.IsLocal() {
-		diff, _ := to.Executor.Command("nvd", "diff", from.Path, to.Path)
-		diff.SetStderr(os.Stderr)
-		diff.SetStdout(os.Stderr)
-		if err := diff.Run(); err != nil {
-			return err
-		}
-
-		return nil
+	if !diff.Empty() {
+		fmt.Fprintf(
+			strb,
+			"Summary: %s upgraded, %s downgraded, %s rebuilt, %d added, %d removed\n",
+			lipgloss.NewStyle().
+				Foreground(changeColors[ChangeUpgrade]).
+				SetString(strconv.Itoa(diff.Count(ChangeUpgrade))).
+				String(),
+			lipgloss.NewStyle().
+				Foreground(changeColors[ChangeDowngrade]).
+				SetString(strconv.Itoa(diff.Count(ChangeDowngrade))).
+				String(),
+			lipgloss.NewStyle().
+				Foreground(changeColors[ChangeRebuild]).
+				SetString(strconv.Itoa(diff.Count(ChangeRebuild))).
+				String(),
+			len(diff.Added),
+			len(diff.Removed),
+		)
 	}
 
-	// Otherwise we calculate it locally and print
-	pkgDiff, closure, err := Run(from, to)
-	if err != nil {
-		return err
-	}
+	return strb.String()
+}
 
-	Print(pkgDiff)
+func Print(diff *Diff) {
+	fmt.Fprint(os.Stderr, Render(diff))
+}
 
+// RenderClosure renders the closure size changes of a diff.
+func RenderClosure(closure *ClosureDiff) string {
 	sizeDiff, negative, unit := util.DiffBytes(closure.BytesBefore, closure.BytesAfter)
 
 	prefix := "+"
@@ -471,13 +722,58 @@ func Execute(from, to *Generation) error {
 	}
 	diskUsage := fmt.Sprintf("%s%.2f%s", prefix, sizeDiff, unit)
 
-	fmt.Fprintf(
-		os.Stderr,
-		"Closure size: %d -> %d (disk usage %s)\n",
+	return fmt.Sprintf(
+		"Closure size: %d -> %d (disk usage %s)\n%s",
 		closure.NumBefore,
 		closure.NumAfter,
 		diskUsage,
+		renderSizes(closure.Packages),
 	)
+}
+
+var failOnDowngrade bool
+
+// SetFailOnDowngrade makes Execute return a *DowngradeError when
+// any package was downgraded.
+func SetFailOnDowngrade(fail bool) {
+	failOnDowngrade = fail
+}
+
+// DowngradeError is returned by Execute when packages were downgraded
+// and failing on downgrades was enabled with SetFailOnDowngrade.
+type DowngradeError struct {
+	Packages []PackageDiff
+}
+
+func (e *DowngradeError) Error() string {
+	pkgs := []string{}
+	for _, pkg := range e.Packages {
+		pkgs = append(
+			pkgs,
+			fmt.Sprintf("%s (%s -> %s)", pkg.PName, strings.Join(pkg.Before, ", "), strings.Join(pkg.After, ", ")),
+		)
+	}
+	return fmt.Sprintf("%d package(s) downgraded: %s", len(e.Packages), strings.Join(pkgs, ", "))
+}
+
+// Execute compares two generations and prints the changes, either as text to
+// stderr or as a report in the format set with SetFormat to stdout.
+func Execute(from, to *Generation) error {
+	pkgDiff, closure, err := Run(from, to)
+	if err != nil {
+		return err
+	}
+
+	if format == FormatText {
+		Print(pkgDiff)
+		fmt.Fprint(os.Stderr, RenderClosure(closure))
+	} else {
+		fmt.Fprint(os.Stdout, RenderReport(format, to.Name, pkgDiff, closure))
+	}
+
+	if downgrades := pkgDiff.Downgrades(); failOnDowngrade && len(downgrades) > 0 {
+		return &DowngradeError{Packages: downgrades}
+	}
 
 	return nil
 }
@@ -1,13 +1,23 @@
 package diff
 
 import (
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
 
+	"github.com/arnarg/nilla-utils/internal/exec"
 	"github.com/go-test/deep"
 	"github.com/s0rg/set"
 )
 
 func TestParsePackageFromPath(t *testing.T) {
+	deep.CompareUnexportedFields = true
+	defer func() { deep.CompareUnexportedFields = false }()
+
 	tests := []struct {
 		name string
 		in   string
@@ -27,10 +37,101 @@ func TestParsePackageFromPath(t *testing.T) {
 			in:   "/nix/store/3bl0g75vyjgg8gnggwiavbwdxyg6gv20-gnutar-1.35-info",
 			out: &Package{
 				pname:   "gnutar",
-				version: "1.35-info",
+				version: "1.35",
+				output:  "info",
 				path:    "/nix/store/3bl0g75vyjgg8gnggwiavbwdxyg6gv20-gnutar-1.35-info",
 			},
 		},
+		{
+			name: "package with lib output",
+			in:   "/nix/store/nc394xps4al1r99ziabqvajbkrhxr5b7-gzip-1.13-lib",
+			out: &Package{
+				pname:   "gzip",
+				version: "1.13",
+				output:  "lib",
+				path:    "/nix/store/nc394xps4al1r99ziabqvajbkrhxr5b7-gzip-1.13-lib",
+			},
+		},
+		{
+			name: "package with man output",
+			in:   "/nix/store/nc394xps4al1r99ziabqvajbkrhxr5b7-coreutils-9.5-man",
+			out: &Package{
+				pname:   "coreutils",
+				version: "9.5",
+				output:  "man",
+				path:    "/nix/store/nc394xps4al1r99ziabqvajbkrhxr5b7-coreutils-9.5-man",
+			},
+		},
+		{
+			name: "package with dev output",
+			in:   "/nix/store/nc394xps4al1r99ziabqvajbkrhxr5b7-openssl-3.0.15-dev",
+			out: &Package{
+				pname:   "openssl",
+				version: "3.0.15",
+				output:  "dev",
+				path:    "/nix/store/nc394xps4al1r99ziabqvajbkrhxr5b7-openssl-3.0.15-dev",
+			},
+		},
+		{
+			name: "package with bin output",
+			in:   "/nix/store/nc394xps4al1r99ziabqvajbkrhxr5b7-util-linux-2.39.4-bin",
+			out: &Package{
+				pname:   "util-linux",
+				version: "2.39.4",
+				output:  "bin",
+				path:    "/nix/store/nc394xps4al1r99ziabqvajbkrhxr5b7-util-linux-2.39.4-bin",
+			},
+		},
+		{
+			name: "package with doc output",
+			in:   "/nix/store/nc394xps4al1r99ziabqvajbkrhxr5b7-nix-2.24.10-doc",
+			out: &Package{
+				pname:   "nix",
+				version: "2.24.10",
+				output:  "doc",
+				path:    "/nix/store/nc394xps4al1r99ziabqvajbkrhxr5b7-nix-2.24.10-doc",
+			},
+		},
+		{
+			name: "package with debug output",
+			in:   "/nix/store/nc394xps4al1r99ziabqvajbkrhxr5b7-glibc-2.40-36-debug",
+			out: &Package{
+				pname:   "glibc",
+				version: "2.40-36",
+				output:  "debug",
+				path:    "/nix/store/nc394xps4al1r99ziabqvajbkrhxr5b7-glibc-2.40-36-debug",
+			},
+		},
+		{
+			name: "package with terminfo output",
+			in:   "/nix/store/nc394xps4al1r99ziabqvajbkrhxr5b7-kitty-0.36.4-terminfo",
+			out: &Package{
+				pname:   "kitty",
+				version: "0.36.4",
+				output:  "terminfo",
+				path:    "/nix/store/nc394xps4al1r99ziabqvajbkrhxr5b7-kitty-0.36.4-terminfo",
+			},
+		},
+		{
+			name: "package with static output",
+			in:   "/nix/store/nc394xps4al1r99ziabqvajbkrhxr5b7-zlib-1.3.1-static",
+			out: &Package{
+				pname:   "zlib",
+				version: "1.3.1",
+				output:  "static",
+				path:    "/nix/store/nc394xps4al1r99ziabqvajbkrhxr5b7-zlib-1.3.1-static",
+			},
+		},
+		{
+			name: "kernel modules",
+			in:   "/nix/store/nc394xps4al1r99ziabqvajbkrhxr5b7-linux-6.6.1-modules",
+			out: &Package{
+				pname:   "linux",
+				version: "6.6.1",
+				output:  "modules",
+				path:    "/nix/store/nc394xps4al1r99ziabqvajbkrhxr5b7-linux-6.6.1-modules",
+			},
+		},
 		{
 			name: "package without version",
 			in:   "/nix/store/i6fl7i35dacvxqpzya6h78nacciwryfh-nixos-rebuild",
@@ -40,15 +141,207 @@ func TestParsePackageFromPath(t *testing.T) {
 				path:    "/nix/store/i6fl7i35dacvxqpzya6h78nacciwryfh-nixos-rebuild",
 			},
 		},
+		{
+			name: "package without version ending in output-like word",
+			in:   "/nix/store/nc394xps4al1r99ziabqvajbkrhxr5b7-hwdb-dev",
+			out: &Package{
+				pname:   "hwdb-dev",
+				version: "",
+				path:    "/nix/store/nc394xps4al1r99ziabqvajbkrhxr5b7-hwdb-dev",
+			},
+		},
+		{
+			name: "package with dashes in name",
+			in:   "/nix/store/nc394xps4al1r99ziabqvajbkrhxr5b7-nss-cacert-3.98",
+			out: &Package{
+				pname:   "nss-cacert",
+				version: "3.98",
+				path:    "/nix/store/nc394xps4al1r99ziabqvajbkrhxr5b7-nss-cacert-3.98",
+			},
+		},
+		{
+			name: "python package",
+			in:   "/nix/store/nc394xps4al1r99ziabqvajbkrhxr5b7-python3.12-requests-2.32.3",
+			out: &Package{
+				pname:   "python3.12-requests",
+				version: "2.32.3",
+				path:    "/nix/store/nc394xps4al1r99ziabqvajbkrhxr5b7-python3.12-requests-2.32.3",
+			},
+		},
+		{
+			name: "python package with dashes in name",
+			in:   "/nix/store/nc394xps4al1r99ziabqvajbkrhxr5b7-python3.12-typing-extensions-4.12.2",
+			out: &Package{
+				pname:   "python3.12-typing-extensions",
+				version: "4.12.2",
+				path:    "/nix/store/nc394xps4al1r99ziabqvajbkrhxr5b7-python3.12-typing-extensions-4.12.2",
+			},
+		},
+		{
+			name: "perl package",
+			in:   "/nix/store/nc394xps4al1r99ziabqvajbkrhxr5b7-perl5.40.0-Locale-gettext-1.07",
+			out: &Package{
+				pname:   "perl5.40.0-Locale-gettext",
+				version: "1.07",
+				path:    "/nix/store/nc394xps4al1r99ziabqvajbkrhxr5b7-perl5.40.0-Locale-gettext-1.07",
+			},
+		},
+		{
+			name: "package with digits in name",
+			in:   "/nix/store/nc394xps4al1r99ziabqvajbkrhxr5b7-x264-0-unstable-2023-10-01",
+			out: &Package{
+				pname:   "x264",
+				version: "0-unstable-2023-10-01",
+				path:    "/nix/store/nc394xps4al1r99ziabqvajbkrhxr5b7-x264-0-unstable-2023-10-01",
+			},
+		},
+		{
+			name: "package with digit after dash in name",
+			in:   "/nix/store/nc394xps4al1r99ziabqvajbkrhxr5b7-gst-plugins-base-1.24.7",
+			out: &Package{
+				pname:   "gst-plugins-base",
+				version: "1.24.7",
+				path:    "/nix/store/nc394xps4al1r99ziabqvajbkrhxr5b7-gst-plugins-base-1.24.7",
+			},
+		},
+		{
+			name: "package with unstable version",
+			in:   "/nix/store/nc394xps4al1r99ziabqvajbkrhxr5b7-neovim-unwrapped-0.11.0-unstable-2024-11-30",
+			out: &Package{
+				pname:   "neovim-unwrapped",
+				version: "0.11.0-unstable-2024-11-30",
+				path:    "/nix/store/nc394xps4al1r99ziabqvajbkrhxr5b7-neovim-unwrapped-0.11.0-unstable-2024-11-30",
+			},
+		},
+		{
+			name: "package with patch level",
+			in:   "/nix/store/nc394xps4al1r99ziabqvajbkrhxr5b7-bash-5.2p37",
+			out: &Package{
+				pname:   "bash",
+				version: "5.2p37",
+				path:    "/nix/store/nc394xps4al1r99ziabqvajbkrhxr5b7-bash-5.2p37",
+			},
+		},
+		{
+			name: "package with pre-release version",
+			in:   "/nix/store/nc394xps4al1r99ziabqvajbkrhxr5b7-firefox-134.0b5",
+			out: &Package{
+				pname:   "firefox",
+				version: "134.0b5",
+				path:    "/nix/store/nc394xps4al1r99ziabqvajbkrhxr5b7-firefox-134.0b5",
+			},
+		},
+		{
+			name: "package with git revision",
+			in:   "/nix/store/nc394xps4al1r99ziabqvajbkrhxr5b7-nixos-system-laptop-24.11.20241130.62c435d",
+			out: &Package{
+				pname:   "nixos-system-laptop",
+				version: "24.11.20241130.62c435d",
+				path:    "/nix/store/nc394xps4al1r99ziabqvajbkrhxr5b7-nixos-system-laptop-24.11.20241130.62c435d",
+			},
+		},
+		{
+			name: "package with release suffix",
+			in:   "/nix/store/nc394xps4al1r99ziabqvajbkrhxr5b7-glibc-2.40-36",
+			out: &Package{
+				pname:   "glibc",
+				version: "2.40-36",
+				path:    "/nix/store/nc394xps4al1r99ziabqvajbkrhxr5b7-glibc-2.40-36",
+			},
+		},
+		{
+			name: "font package",
+			in:   "/nix/store/nc394xps4al1r99ziabqvajbkrhxr5b7-font-awesome-6.7.1",
+			out: &Package{
+				pname:   "font-awesome",
+				version: "6.7.1",
+				path:    "/nix/store/nc394xps4al1r99ziabqvajbkrhxr5b7-font-awesome-6.7.1",
+			},
+		},
+		{
+			name: "package with capital letters",
+			in:   "/nix/store/nc394xps4al1r99ziabqvajbkrhxr5b7-SDL2-2.30.9",
+			out: &Package{
+				pname:   "SDL2",
+				version: "2.30.9",
+				path:    "/nix/store/nc394xps4al1r99ziabqvajbkrhxr5b7-SDL2-2.30.9",
+			},
+		},
+		{
+			name: "etc file",
+			in:   "/nix/store/nc394xps4al1r99ziabqvajbkrhxr5b7-etc-hosts",
+			out: &Package{
+				pname:   "etc-hosts",
+				version: "",
+				path:    "/nix/store/nc394xps4al1r99ziabqvajbkrhxr5b7-etc-hosts",
+			},
+		},
+		{
+			name: "unit file",
+			in:   "/nix/store/nc394xps4al1r99ziabqvajbkrhxr5b7-unit-sshd.service",
+			out: &Package{
+				pname:   "unit-sshd.service",
+				version: "",
+				path:    "/nix/store/nc394xps4al1r99ziabqvajbkrhxr5b7-unit-sshd.service",
+			},
+		},
+		{
+			name: "source tarball",
+			in:   "/nix/store/nc394xps4al1r99ziabqvajbkrhxr5b7-source",
+			out: &Package{
+				pname:   "source",
+				version: "",
+				path:    "/nix/store/nc394xps4al1r99ziabqvajbkrhxr5b7-source",
+			},
+		},
 		{
 			name: "package with .drv suffix",
 			in:   "/nix/store/nc394xps4al1r99ziabqvajbkrhxr5b7-gzip-1.13.drv",
 			out: &Package{
 				pname:   "gzip",
 				version: "1.13",
-				path:    "/nix/store/nc394xps4al1r99ziabqvajbkrhxr5b7-gzip-1.13",
+				path:    "/nix/store/nc394xps4al1r99ziabqvajbkrhxr5b7-gzip-1.13.drv",
+			},
+		},
+		{
+			name: "derivation with output-like version",
+			in:   "/nix/store/nc394xps4al1r99ziabqvajbkrhxr5b7-linux-6.6.1-modules.drv",
+			out: &Package{
+				pname:   "linux",
+				version: "6.6.1",
+				output:  "modules",
+				path:    "/nix/store/nc394xps4al1r99ziabqvajbkrhxr5b7-linux-6.6.1-modules.drv",
+			},
+		},
+		{
+			name: "derivation without version",
+			in:   "/nix/store/nc394xps4al1r99ziabqvajbkrhxr5b7-nixos-rebuild.drv",
+			out: &Package{
+				pname:   "nixos-rebuild",
+				version: "",
+				path:    "/nix/store/nc394xps4al1r99ziabqvajbkrhxr5b7-nixos-rebuild.drv",
 			},
 		},
+		{
+			name: "not a store path",
+			in:   "/usr/bin/gzip",
+			out:  nil,
+		},
+		{
+			name: "store path without name",
+			in:   "/nix/store/nc394xps4al1r99ziabqvajbkrhxr5b7",
+			out:  nil,
+		},
+		{
+			name: "store path with invalid hash",
+			in:   "/nix/store/NC394XPS-gzip-1.13",
+			out:  nil,
+		},
+		{
+			name: "path inside store path",
+			in:   "/nix/store/nc394xps4al1r99ziabqvajbkrhxr5b7-gzip-1.13/bin/gzip",
+			out:  nil,
+		},
 	}
 
 	for _, tt := range tests {
@@ -78,11 +371,10 @@ func TestNewPackageSet(t *testing.T) {
 			out: PackageSet{
 				packages: map[string]set.Unordered[string]{
 					"gzip": {
-						"1.13":     true,
-						"1.13-lib": true,
+						"1.13": true,
 					},
 					"gnutar": {
-						"1.35-info": true,
+						"1.35": true,
 					},
 				},
 			},
@@ -112,11 +404,11 @@ func TestHasPackage(t *testing.T) {
 			set: PackageSet{
 				packages: map[string]set.Unordered[string]{
 					"gzip": {
-						"1.13":     true,
-						"1.13-lib": true,
+						"1.12": true,
+						"1.13": true,
 					},
 					"gnutar": {
-						"1.35-info": true,
+						"1.35": true,
 					},
 				},
 			},
@@ -125,7 +417,7 @@ func TestHasPackage(t *testing.T) {
 		},
 		{
 			name: "package does not exist",
-			set:  PackageSet{map[string]set.Unordered[string]{}},
+			set:  PackageSet{packages: map[string]set.Unordered[string]{}},
 			in:   "gzip",
 			out:  false,
 		},
@@ -147,27 +439,27 @@ func TestGetPackagesVersions(t *testing.T) {
 		name string
 		set  PackageSet
 		in   string
-		out  []string
+		out  set.Unordered[string]
 	}{
 		{
 			name: "package exists",
 			set: PackageSet{
 				packages: map[string]set.Unordered[string]{
 					"gzip": {
-						"1.13":     true,
-						"1.13-lib": true,
+						"1.12": true,
+						"1.13": true,
 					},
 					"gnutar": {
-						"1.35-info": true,
+						"1.35": true,
 					},
 				},
 			},
 			in:  "gzip",
-			out: []string{"1.13", "1.13-lib"},
+			out: set.Unordered[string]{"1.12": true, "1.13": true},
 		},
 		{
 			name: "package does not exist",
-			set:  PackageSet{map[string]set.Unordered[string]{}},
+			set:  PackageSet{packages: map[string]set.Unordered[string]{}},
 			in:   "gzip",
 			out:  nil,
 		},
@@ -194,24 +486,26 @@ func TestCalculate(t *testing.T) {
 		{
 			name: "diff with all possible changes",
 			from: PackageSet{
+				pnames: set.Unordered[string]{"gzip": true, "gnutar": true},
 				packages: map[string]set.Unordered[string]{
 					"gzip": {
-						"1.13":     true,
-						"1.13-lib": true,
+						"1.12": true,
+						"1.13": true,
 					},
 					"gnutar": {
-						"1.35-info": true,
+						"1.35": true,
 					},
 				},
 			},
 			to: PackageSet{
+				pnames: set.Unordered[string]{"gzip": true, "tar": true},
 				packages: map[string]set.Unordered[string]{
 					"gzip": {
-						"1.14":     true,
-						"1.14-lib": true,
+						"1.13": true,
+						"1.14": true,
 					},
 					"tar": {
-						"1.35-info": true,
+						"1.35": true,
 					},
 				},
 			},
@@ -219,21 +513,22 @@ func TestCalculate(t *testing.T) {
 				Changed: []PackageDiff{
 					{
 						PName:  "gzip",
-						Before: []string{"1.13", "1.13-lib"},
-						After:  []string{"1.14", "1.14-lib"},
+						Kind:   ChangeUpgrade,
+						Before: []string{"1.12", "1.13"},
+						After:  []string{"1.13", "1.14"},
 					},
 				},
 				Added: []PackageDiff{
 					{
 						PName:  "tar",
 						Before: []string{},
-						After:  []string{"1.35-info"},
+						After:  []string{"1.35"},
 					},
 				},
 				Removed: []PackageDiff{
 					{
 						PName:  "gnutar",
-						Before: []string{"1.35-info"},
+						Before: []string{"1.35"},
 						After:  []string{},
 					},
 				},
@@ -242,24 +537,26 @@ func TestCalculate(t *testing.T) {
 		{
 			name: "diff with no changes",
 			from: PackageSet{
+				pnames: set.Unordered[string]{"gzip": true, "gnutar": true},
 				packages: map[string]set.Unordered[string]{
 					"gzip": {
-						"1.13":     true,
-						"1.13-lib": true,
+						"1.12": true,
+						"1.13": true,
 					},
 					"gnutar": {
-						"1.35-info": true,
+						"1.35": true,
 					},
 				},
 			},
 			to: PackageSet{
+				pnames: set.Unordered[string]{"gzip": true, "gnutar": true},
 				packages: map[string]set.Unordered[string]{
 					"gzip": {
-						"1.13":     true,
-						"1.13-lib": true,
+						"1.12": true,
+						"1.13": true,
 					},
 					"gnutar": {
-						"1.35-info": true,
+						"1.35": true,
 					},
 				},
 			},
@@ -269,6 +566,64 @@ func TestCalculate(t *testing.T) {
 				Removed: []PackageDiff{},
 			},
 		},
+		{
+			name: "diff with downgrade and rebuild",
+			from: NewPackageSet([]string{
+				"/nix/store/nc394xps4al1r99ziabqvajbkrhxr5b7-gzip-1.13",
+				"/nix/store/3bl0g75vyjgg8gnggwiavbwdxyg6gv20-gnutar-1.35",
+				"/nix/store/i6fl7i35dacvxqpzya6h78nacciwryfh-bash-5.2p37",
+			}),
+			to: NewPackageSet([]string{
+				"/nix/store/nc394xps4al1r99ziabqvajbkrhxr5b7-gzip-1.12",
+				"/nix/store/0a8xq2l0dfhkxvn5hqmz4gndp4kiqbv0-gnutar-1.35",
+				"/nix/store/i6fl7i35dacvxqpzya6h78nacciwryfh-bash-5.2p37",
+			}),
+			out: Diff{
+				Changed: []PackageDiff{
+					{
+						PName:  "gnutar",
+						Kind:   ChangeRebuild,
+						Before: []string{"1.35"},
+						After:  []string{"1.35"},
+					},
+					{
+						PName:  "gzip",
+						Kind:   ChangeDowngrade,
+						Before: []string{"1.13"},
+						After:  []string{"1.12"},
+					},
+				},
+				Added:   []PackageDiff{},
+				Removed: []PackageDiff{},
+			},
+		},
+		{
+			name: "diff with multiple outputs",
+			from: NewPackageSet([]string{
+				"/nix/store/nc394xps4al1r99ziabqvajbkrhxr5b7-gzip-1.13",
+				"/nix/store/3bl0g75vyjgg8gnggwiavbwdxyg6gv20-gzip-1.13-man",
+				"/nix/store/i6fl7i35dacvxqpzya6h78nacciwryfh-python3.12-requests-2.32.3",
+				"/nix/store/0a8xq2l0dfhkxvn5hqmz4gndp4kiqbv0-linux-6.6.1-modules",
+			}),
+			to: NewPackageSet([]string{
+				"/nix/store/4x2hbfwzcq4gy7rz7m0ja8hk6kqqxdmd-gzip-1.14",
+				"/nix/store/bsx8s8v5lzdj7a2zq3q0qscdwn3f1mif-gzip-1.14-man",
+				"/nix/store/i6fl7i35dacvxqpzya6h78nacciwryfh-python3.12-requests-2.32.3",
+				"/nix/store/0a8xq2l0dfhkxvn5hqmz4gndp4kiqbv0-linux-6.6.1-modules",
+			}),
+			out: Diff{
+				Changed: []PackageDiff{
+					{
+						PName:  "gzip",
+						Kind:   ChangeUpgrade,
+						Before: []string{"1.13"},
+						After:  []string{"1.14"},
+					},
+				},
+				Added:   []PackageDiff{},
+				Removed: []PackageDiff{},
+			},
+		},
 	}
 
 	for _, tt := range tests {
@@ -281,3 +636,172 @@ func TestCalculate(t *testing.T) {
 		})
 	}
 }
+
+func TestRender(t *testing.T) {
+	diff := &Diff{
+		Changed: []PackageDiff{
+			{PName: "gnutar", Kind: ChangeRebuild, Before: []string{"1.35"}, After: []string{"1.35"}},
+			{PName: "gzip", Kind: ChangeDowngrade, Before: []string{"1.13"}, After: []string{"1.12"}},
+			{PName: "zstd", Kind: ChangeUpgrade, Before: []string{"1.5.6"}, After: []string{"1.5.7"}},
+		},
+		Added:   []PackageDiff{{PName: "tar", Before: []string{}, After: []string{"1.35"}}},
+		Removed: []PackageDiff{},
+	}
+
+	expected := "Version changes:\n" +
+		"#01  gzip  1.13 -> 1.12\n" +
+		"#02  zstd  1.5.6 -> 1.5.7\n" +
+		"Added packages:\n" +
+		"#01  tar  1.35\n" +
+		"Summary: 1 upgraded, 1 downgraded, 1 rebuilt, 1 added, 0 removed\n"
+
+	if out := Render(diff); out != expected {
+		t.Errorf("unexpected output:\n%s", out)
+	}
+
+	if out := Render(&Diff{}); out != "" {
+		t.Errorf("expected no output for empty diff, got:\n%s", out)
+	}
+}
+
+func TestDowngradeError(t *testing.T) {
+	err := &DowngradeError{
+		Packages: []PackageDiff{
+			{PName: "gzip", Kind: ChangeDowngrade, Before: []string{"1.13"}, After: []string{"1.12"}},
+		},
+	}
+
+	if err.Error() != "1 package(s) downgraded: gzip (1.13 -> 1.12)" {
+		t.Errorf("unexpected error message: \"%s\"", err.Error())
+	}
+}
+
+// fakeNix puts fake nix and nix-store commands on PATH that answer
+// queries about generations from files in the generation.
+func fakeNix(t *testing.T) {
+	t.Helper()
+
+	bin := t.TempDir()
+	writeFiles(t, bin, map[string]string{
+		"nix-store": "#!/bin/sh\n" +
+			"case \"$2\" in\n" +
+			"  --references) cat \"$3/references\" ;;\n" +
+			"  --requisites) cat \"$3/requisites\" ;;\n" +
+			"  *) exit 1 ;;\n" +
+			"esac\n",
+		"nix":        "#!/bin/sh\ncat \"$4/path-info.json\"\n",
+		"nix-daemon": "#!/bin/sh\nexit 1\n",
+	})
+	for _, name := range []string{"nix-store", "nix", "nix-daemon"} {
+		if err := os.Chmod(filepath.Join(bin, name), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	t.Setenv("NIX_DAEMON_SOCKET_PATH", filepath.Join(bin, "socket"))
+}
+
+// writeGeneration writes a generation with the packages in its system
+// path and the NAR size of every path in its closure.
+func writeGeneration(t *testing.T, packages []string, sizes map[string]int64) string {
+	t.Helper()
+
+	root := t.TempDir()
+
+	infos := []string{}
+	for path, size := range sizes {
+		infos = append(infos, fmt.Sprintf(`{"path":"%s","narSize":%d}`, path, size))
+	}
+
+	writeFiles(t, root, map[string]string{
+		"sw/references":  strings.Join(packages, "\n") + "\n",
+		"requisites":     strings.Join(slices.Sorted(maps.Keys(sizes)), "\n") + "\n",
+		"path-info.json": "[" + strings.Join(infos, ",") + "]",
+	})
+
+	return root
+}
+
+func captureStderr(t *testing.T, f func()) string {
+	t.Helper()
+
+	out, err := os.CreateTemp(t.TempDir(), "stderr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+
+	stderr := os.Stderr
+	os.Stderr = out
+	defer func() { os.Stderr = stderr }()
+
+	f()
+
+	buf, err := os.ReadFile(out.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(buf)
+}
+
+func TestExecute(t *testing.T) {
+	fakeNix(t)
+
+	from := writeGeneration(t,
+		[]string{
+			"/nix/store/c2qsnv6hvcc4i0p2j38jqrgihaaz9xs2-gzip-1.13",
+			"/nix/store/sd5lhlm1w2dsa2wmsr2x0kp3xcqpaycw-zstd-1.5.6",
+		},
+		map[string]int64{
+			"/nix/store/c2qsnv6hvcc4i0p2j38jqrgihaaz9xs2-gzip-1.13":  1024,
+			"/nix/store/sd5lhlm1w2dsa2wmsr2x0kp3xcqpaycw-zstd-1.5.6": 2048,
+		},
+	)
+	to := writeGeneration(t,
+		[]string{
+			"/nix/store/c2qsnv6hvcc4i0p2j38jqrgihaaz9xs2-gzip-1.13",
+			"/nix/store/0m7b1ml3yrmw8h5hkwa4zqi5vhcmqyqq-zstd-1.5.7",
+			"/nix/store/kwmqk7ygvhypxadsdaai27gl6qfxv7za-hello-2.12.1",
+		},
+		map[string]int64{
+			"/nix/store/c2qsnv6hvcc4i0p2j38jqrgihaaz9xs2-gzip-1.13":    1024,
+			"/nix/store/0m7b1ml3yrmw8h5hkwa4zqi5vhcmqyqq-zstd-1.5.7":   4096,
+			"/nix/store/kwmqk7ygvhypxadsdaai27gl6qfxv7za-hello-2.12.1": 512,
+		},
+	)
+
+	// Local generations without any flags are diffed like remote ones
+	executor := exec.NewLocalExecutor()
+	gens := []*Generation{
+		{Path: from, Executor: executor},
+		{Path: to, Executor: executor},
+	}
+	out := captureStderr(t, func() {
+		if err := Execute(gens[0], gens[1]); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	for _, expected := range []string{
+		"Version changes:\n#01  zstd  1.5.6 -> 1.5.7\n",
+		"Added packages:\n#01  hello  2.12.1\n",
+		"Summary: 1 upgraded, 0 downgraded, 0 rebuilt, 1 added, 0 removed\n",
+		"Closure size: 2 -> 3 (disk usage +2.50KiB)\n",
+		"Size changes:\n",
+	} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("expected output to contain %q, got:\n%s", expected, out)
+		}
+	}
+
+	// Packages of compared generations are not queried again
+	for _, gen := range gens {
+		if err := os.Remove(filepath.Join(gen.Path, "requisites")); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := QueryPackages(gen); err != nil {
+			t.Errorf("expected packages of %s to be cached: %s", gen.Path, err)
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package diff
+
+import (
+	"fmt"
+	"html"
+	"slices"
+	"strings"
+
+	"github.com/arnarg/nilla-utils/internal/util"
+)
+
+// Format is the output format of a diff.
+type Format string
+
+const (
+	FormatText     Format = "text"
+	FormatMarkdown Format = "markdown"
+	FormatHTML     Format = "html"
+)
+
+// ParseFormat parses the name of an output format.
+func ParseFormat(s string) (Format, error) {
+	switch f := Format(s); f {
+	case FormatText, FormatMarkdown, FormatHTML:
+		return f, nil
+	case "md":
+		return FormatMarkdown, nil
+	}
+	return "", fmt.Errorf("unknown diff format \"%s\", expected text, markdown or html", s)
+}
+
+var format = FormatText
+
+// SetFormat sets the output format of Execute. Reports in other formats
+// than text are written to stdout, so they can be posted elsewhere.
+func SetFormat(f Format) {
+	format = f
+}
+
+// reportTable is a section of a report.
+type reportTable struct {
+	title   string
+	headers []string
+	rows    [][]string
+}
+
+func reportTables(diff *Diff, closure *ClosureDiff) []reportTable {
+	changed := reportTable{title: "Version changes", headers: []string{"Package", "Change", "Before", "After"}}
+	rebuilt := reportTable{title: "Rebuilt packages", headers: []string{"Package", "Version"}}
+	for _, pkg := range diff.Changed {
+		if slices.Equal(pkg.Before, pkg.After) {
+			rebuilt.rows = append(rebuilt.rows, []string{pkg.PName, strings.Join(pkg.After, ", ")})
+			continue
+		}
+
+		changed.rows = append(changed.rows, []string{
+			pkg.PName,
+			pkg.Kind.String(),
+			strings.Join(pkg.Before, ", "),
+			strings.Join(pkg.After, ", "),
+		})
+	}
+
+	added := reportTable{title: "Added packages", headers: []string{"Package", "Version"}}
+	for _, pkg := range diff.Added {
+		added.rows = append(added.rows, []string{pkg.PName, strings.Join(pkg.After, ", ")})
+	}
+
+	removed := reportTable{title: "Removed packages", headers: []string{"Package", "Version"}}
+	for _, pkg := range diff.Removed {
+		removed.rows = append(removed.rows, []string{pkg.PName, strings.Join(pkg.Before, ", ")})
+	}
+
+	sizes := reportTable{title: "Size changes", headers: []string{"Package", "Change", "Before", "After"}}
+	for _, pkg := range closure.Packages {
+		sizes.rows = append(sizes.rows, []string{
+			pkg.PName,
+			formatSizeDiff(pkg.Before, pkg.After),
+			formatBytes(pkg.Before),
+			formatBytes(pkg.After),
+		})
+	}
+
+	tables := []reportTable{}
+	for _, table := range []reportTable{changed, added, removed, rebuilt, sizes} {
+		if len(table.rows) > 0 {
+			tables = append(tables, table)
+		}
+	}
+	return tables
+}
+
+func formatSizeDiff(from, to int64) string {
+	sizeDiff, negative, unit := util.DiffBytes(from, to)
+
+	prefix := "+"
+	if negative {
+		prefix = "-"
+	}
+	return fmt.Sprintf("%s%.2f%s", prefix, sizeDiff, unit)
+}
+
+func reportSummary(diff *Diff) string {
+	return fmt.Sprintf(
+		"%d upgraded, %d downgraded, %d rebuilt, %d added, %d removed",
+		diff.Count(ChangeUpgrade),
+		diff.Count(ChangeDowngrade),
+		diff.Count(ChangeRebuild),
+		len(diff.Added),
+		len(diff.Removed),
+	)
+}
+
+func reportClosure(closure *ClosureDiff) string {
+	return fmt.Sprintf(
+		"%d -> %d packages, disk usage %s",
+		closure.NumBefore,
+		closure.NumAfter,
+		formatSizeDiff(closure.BytesBefore, closure.BytesAfter),
+	)
+}
+
+// escapeMarkdown escapes characters with meaning in markdown tables.
+func escapeMarkdown(s string) string {
+	return strings.NewReplacer(
+		`\`, `\\`,
+		"|", `\|`,
+		"*", `\*`,
+		"_", `\_`,
+		"`", "\\`",
+		"<", "&lt;",
+		">", "&gt;",
+	).Replace(s)
+}
+
+// RenderMarkdown renders a diff as markdown with collapsible sections,
+// headed by title if it's not empty.
+func RenderMarkdown(title string, diff *Diff, closure *ClosureDiff) string {
+	strb := &strings.Builder{}
+
+	if title != "" {
+		fmt.Fprintf(strb, "## %s\n\n", escapeMarkdown(title))
+	}
+
+	fmt.Fprintf(strb, "**Summary:** %s  \n", reportSummary(diff))
+	fmt.Fprintf(strb, "**Closure:** %s\n", reportClosure(closure))
+
+	for _, table := range reportTables(diff, closure) {
+		fmt.Fprintf(strb, "\n<details>\n<summary>%s (%d)</summary>\n\n", table.title, len(table.rows))
+
+		fmt.Fprintf(strb, "| %s |\n", strings.Join(table.headers, " | "))
+		fmt.Fprintf(strb, "|%s\n", strings.Repeat(" --- |", len(table.headers)))
+		for _, row := range table.rows {
+			cells := []string{}
+			for _, cell := range row {
+				cells = append(cells, escapeMarkdown(cell))
+			}
+			fmt.Fprintf(strb, "| %s |\n", strings.Join(cells, " | "))
+		}
+
+		strb.WriteString("\n</details>\n")
+	}
+
+	return strb.String()
+}
+
+// RenderHTML renders a diff as an HTML fragment with collapsible sections,
+// headed by title if it's not empty.
+func RenderHTML(title string, diff *Diff, closure *ClosureDiff) string {
+	strb := &strings.Builder{}
+
+	if title != "" {
+		fmt.Fprintf(strb, "<h2>%s</h2>\n", html.EscapeString(title))
+	}
+
+	fmt.Fprintf(strb, "<p><strong>Summary:</strong> %s<br>\n", reportSummary(diff))
+	fmt.Fprintf(strb, "<strong>Closure:</strong> %s</p>\n", html.EscapeString(reportClosure(closure)))
+
+	for _, table := range reportTables(diff, closure) {
+		fmt.Fprintf(strb, "<details>\n<summary>%s (%d)</summary>\n<table>\n", table.title, len(table.rows))
+
+		strb.WriteString("<tr>")
+		for _, header := range table.headers {
+			fmt.Fprintf(strb, "<th>%s</th>", header)
+		}
+		strb.WriteString("</tr>\n")
+
+		for _, row := range table.rows {
+			strb.WriteString("<tr>")
+			for _, cell := range row {
+				fmt.Fprintf(strb, "<td>%s</td>", html.EscapeString(cell))
+			}
+			strb.WriteString("</tr>\n")
+		}
+
+		strb.WriteString("</table>\n</details>\n")
+	}
+
+	return strb.String()
+}
+
+// RenderReport renders a diff in the format.
+func RenderReport(f Format, title string, diff *Diff, closure *ClosureDiff) string {
+	switch f {
+	case FormatMarkdown:
+		return RenderMarkdown(title, diff, closure)
+	case FormatHTML:
+		return RenderHTML(title, diff, closure)
+	}
+	return Render(diff) + RenderClosure(closure)
+}
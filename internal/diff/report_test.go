@@ -0,0 +1,94 @@
+package diff
+
+import (
+	"testing"
+)
+
+var testReportDiff = &Diff{
+	Changed: []PackageDiff{
+		{PName: "gnutar", Kind: ChangeRebuild, Before: []string{"1.35"}, After: []string{"1.35"}},
+		{PName: "gzip", Kind: ChangeDowngrade, Before: []string{"1.13"}, After: []string{"1.12"}},
+	},
+	Added:   []PackageDiff{{PName: "foo_bar", Before: []string{}, After: []string{"1.0"}}},
+	Removed: []PackageDiff{},
+}
+
+var testReportClosure = &ClosureDiff{
+	NumBefore:   10,
+	NumAfter:    11,
+	BytesBefore: 1024,
+	BytesAfter:  3072,
+	Packages:    []PackageSize{{PName: "foo_bar", Before: 0, After: 2048}},
+}
+
+func TestRenderMarkdown(t *testing.T) {
+	expected := "## web1\n\n" +
+		"**Summary:** 0 upgraded, 1 downgraded, 1 rebuilt, 1 added, 0 removed  \n" +
+		"**Closure:** 10 -> 11 packages, disk usage +2.00KiB\n" +
+		"\n<details>\n<summary>Version changes (1)</summary>\n\n" +
+		"| Package | Change | Before | After |\n" +
+		"| --- | --- | --- | --- |\n" +
+		"| gzip | downgrade | 1.13 | 1.12 |\n" +
+		"\n</details>\n" +
+		"\n<details>\n<summary>Added packages (1)</summary>\n\n" +
+		"| Package | Version |\n" +
+		"| --- | --- |\n" +
+		"| foo\\_bar | 1.0 |\n" +
+		"\n</details>\n" +
+		"\n<details>\n<summary>Rebuilt packages (1)</summary>\n\n" +
+		"| Package | Version |\n" +
+		"| --- | --- |\n" +
+		"| gnutar | 1.35 |\n" +
+		"\n</details>\n" +
+		"\n<details>\n<summary>Size changes (1)</summary>\n\n" +
+		"| Package | Change | Before | After |\n" +
+		"| --- | --- | --- | --- |\n" +
+		"| foo\\_bar | +2.00KiB | 0.00B | 2.00KiB |\n" +
+		"\n</details>\n"
+
+	if out := RenderMarkdown("web1", testReportDiff, testReportClosure); out != expected {
+		t.Errorf("unexpected output:\n%s", out)
+	}
+}
+
+func TestRenderHTML(t *testing.T) {
+	expected := "<p><strong>Summary:</strong> 0 upgraded, 1 downgraded, 1 rebuilt, 1 added, 0 removed<br>\n" +
+		"<strong>Closure:</strong> 10 -&gt; 11 packages, disk usage +2.00KiB</p>\n" +
+		"<details>\n<summary>Version changes (1)</summary>\n<table>\n" +
+		"<tr><th>Package</th><th>Change</th><th>Before</th><th>After</th></tr>\n" +
+		"<tr><td>gzip</td><td>downgrade</td><td>1.13</td><td>1.12</td></tr>\n" +
+		"</table>\n</details>\n" +
+		"<details>\n<summary>Added packages (1)</summary>\n<table>\n" +
+		"<tr><th>Package</th><th>Version</th></tr>\n" +
+		"<tr><td>foo_bar</td><td>1.0</td></tr>\n" +
+		"</table>\n</details>\n" +
+		"<details>\n<summary>Rebuilt packages (1)</summary>\n<table>\n" +
+		"<tr><th>Package</th><th>Version</th></tr>\n" +
+		"<tr><td>gnutar</td><td>1.35</td></tr>\n" +
+		"</table>\n</details>\n" +
+		"<details>\n<summary>Size changes (1)</summary>\n<table>\n" +
+		"<tr><th>Package</th><th>Change</th><th>Before</th><th>After</th></tr>\n" +
+		"<tr><td>foo_bar</td><td>+2.00KiB</td><td>0.00B</td><td>2.00KiB</td></tr>\n" +
+		"</table>\n</details>\n"
+
+	if out := RenderHTML("", testReportDiff, testReportClosure); out != expected {
+		t.Errorf("unexpected output:\n%s", out)
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	for in, out := range map[string]Format{
+		"text":     FormatText,
+		"markdown": FormatMarkdown,
+		"md":       FormatMarkdown,
+		"html":     FormatHTML,
+	} {
+		if f, err := ParseFormat(in); err != nil || f != out {
+			t.Errorf("unexpected format for \"%s\": %s, %v", in, f, err)
+		}
+	}
+
+	if _, err := ParseFormat("json"); err == nil {
+		t.Error("expected error for unknown format")
+	}
+}
@@ -0,0 +1,172 @@
+package diff
+
+import (
+	"bytes"
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/arnarg/nilla-utils/internal/util"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/valyala/fastjson"
+)
+
+// Number of packages shown when rendering size changes.
+const maxSizeChanges = 10
+
+// PackageSize is the size a package contributes to a closure,
+// which is the sum of the NAR sizes of its store paths.
+type PackageSize struct {
+	PName  string
+	Before int64
+	After  int64
+}
+
+// Delta returns the change in size in bytes.
+func (s PackageSize) Delta() int64 {
+	return s.After - s.Before
+}
+
+// sizes returns the NAR size of every path in the closure.
+func (c *cliClosure) sizes() (map[string]int64, error) {
+	// Create buffer for output
+	buf := &bytes.Buffer{}
+
+	// Create command from executor
+	cmd, err := c.gen.Executor.Command("nix", "path-info", "--json", "--recursive", c.gen.Path)
+	if err != nil {
+		return nil, err
+	}
+	cmd.SetStdout(buf)
+
+	// Run command
+	if err := cmd.Run(); err != nil {
+		return nil, err
+	}
+
+	return decodePathSizes(buf.Bytes())
+}
+
+func decodePathSizes(buf []byte) (map[string]int64, error) {
+	val, err := fastjson.ParseBytes(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	sizes := map[string]int64{}
+
+	// Depending on nix or lix, it's sometimes a list
+	// of objects and sometimes an object with store
+	// path as key
+	switch val.Type() {
+	case fastjson.TypeArray:
+		for _, info := range val.GetArray() {
+			sizes[string(info.GetStringBytes("path"))] = info.GetInt64("narSize")
+		}
+
+	case fastjson.TypeObject:
+		val.GetObject().Visit(func(k []byte, v *fastjson.Value) {
+			sizes[string(k)] = v.GetInt64("narSize")
+		})
+	}
+
+	return sizes, nil
+}
+
+func sumSizes(sizes map[string]int64) int64 {
+	var total int64
+	for _, size := range sizes {
+		total += size
+	}
+	return total
+}
+
+// calculateSizes returns the size changes of the packages in the diff,
+// sorted from the biggest change to the smallest.
+func calculateSizes(diff *Diff, before, after PackageSet, beforeSizes, afterSizes map[string]int64) []PackageSize {
+	pkgs := []PackageSize{}
+
+	for _, group := range [][]PackageDiff{diff.Changed, diff.Added, diff.Removed} {
+		for _, pkg := range group {
+			size := PackageSize{PName: pkg.PName}
+			for path := range before.storePaths(pkg.PName) {
+				size.Before += beforeSizes[path]
+			}
+			for path := range after.storePaths(pkg.PName) {
+				size.After += afterSizes[path]
+			}
+
+			if size.Delta() != 0 {
+				pkgs = append(pkgs, size)
+			}
+		}
+	}
+
+	slices.SortFunc(pkgs, func(a, b PackageSize) int {
+		return cmp.Or(
+			cmp.Compare(abs(b.Delta()), abs(a.Delta())),
+			strings.Compare(strings.ToLower(a.PName), strings.ToLower(b.PName)),
+		)
+	})
+
+	return pkgs
+}
+
+func abs(n int64) int64 {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+func formatBytes(b int64) string {
+	size, unit := util.ConvertBytes(b)
+	return fmt.Sprintf("%.2f%s", size, unit)
+}
+
+func renderSizes(pkgs []PackageSize) string {
+	if len(pkgs) < 1 {
+		return ""
+	}
+
+	strb := &strings.Builder{}
+	strb.WriteString("Size changes:\n")
+
+	pkgs = pkgs[:min(len(pkgs), maxSizeChanges)]
+
+	widest := 0
+	for _, pkg := range pkgs {
+		if len(pkg.PName) > widest {
+			widest = len(pkg.PName)
+		}
+	}
+
+	for i, pkg := range pkgs {
+		sizeDiff, negative, unit := util.DiffBytes(pkg.Before, pkg.After)
+
+		// Growing closures are the ones worth noticing
+		prefix, color := "+", lipgloss.Color("9")
+		if negative {
+			prefix, color = "-", lipgloss.Color("10")
+		}
+
+		fmt.Fprintf(
+			strb,
+			"#%02d  %s  %s (%s -> %s)\n",
+			i+1,
+			lipgloss.NewStyle().
+				Width(widest).
+				SetString(pkg.PName).
+				String(),
+			lipgloss.NewStyle().
+				Foreground(color).
+				SetString(fmt.Sprintf("%s%.2f%s", prefix, sizeDiff, unit)).
+				String(),
+			formatBytes(pkg.Before),
+			formatBytes(pkg.After),
+		)
+	}
+
+	return strb.String()
+}
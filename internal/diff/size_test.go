@@ -0,0 +1,97 @@
+package diff
+
+import (
+	"testing"
+
+	"github.com/go-test/deep"
+)
+
+func TestDecodePathSizes(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		out  map[string]int64
+	}{
+		{
+			name: "list of objects",
+			in: `[
+				{"path": "/nix/store/nc394xps4al1r99ziabqvajbkrhxr5b7-gzip-1.13", "narSize": 1000},
+				{"path": "/nix/store/3bl0g75vyjgg8gnggwiavbwdxyg6gv20-gnutar-1.35", "narSize": 2000}
+			]`,
+			out: map[string]int64{
+				"/nix/store/nc394xps4al1r99ziabqvajbkrhxr5b7-gzip-1.13":   1000,
+				"/nix/store/3bl0g75vyjgg8gnggwiavbwdxyg6gv20-gnutar-1.35": 2000,
+			},
+		},
+		{
+			name: "object with store paths as keys",
+			in: `{
+				"/nix/store/nc394xps4al1r99ziabqvajbkrhxr5b7-gzip-1.13": {"narSize": 1000},
+				"/nix/store/3bl0g75vyjgg8gnggwiavbwdxyg6gv20-gnutar-1.35": {"narSize": 2000}
+			}`,
+			out: map[string]int64{
+				"/nix/store/nc394xps4al1r99ziabqvajbkrhxr5b7-gzip-1.13":   1000,
+				"/nix/store/3bl0g75vyjgg8gnggwiavbwdxyg6gv20-gnutar-1.35": 2000,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sizes, err := decodePathSizes([]byte(tt.in))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if diff := deep.Equal(sizes, tt.out); diff != nil {
+				t.Error(diff)
+			}
+		})
+	}
+}
+
+func TestCalculateSizes(t *testing.T) {
+	before := NewPackageSet([]string{
+		"/nix/store/nc394xps4al1r99ziabqvajbkrhxr5b7-gzip-1.13",
+		"/nix/store/3bl0g75vyjgg8gnggwiavbwdxyg6gv20-gnutar-1.35",
+		"/nix/store/i6fl7i35dacvxqpzya6h78nacciwryfh-bash-5.2p37",
+	})
+	after := NewPackageSet([]string{
+		"/nix/store/0a8xq2l0dfhkxvn5hqmz4gndp4kiqbv0-gzip-1.14",
+		"/nix/store/i6fl7i35dacvxqpzya6h78nacciwryfh-bash-5.2p37",
+		"/nix/store/5qfwz7s2ylj1xk3wyrbc7ys4lf0f2d7r-cuda-12.4",
+	})
+	beforeSizes := map[string]int64{
+		"/nix/store/nc394xps4al1r99ziabqvajbkrhxr5b7-gzip-1.13":   1000,
+		"/nix/store/3bl0g75vyjgg8gnggwiavbwdxyg6gv20-gnutar-1.35": 3000,
+		"/nix/store/i6fl7i35dacvxqpzya6h78nacciwryfh-bash-5.2p37": 5000,
+	}
+	afterSizes := map[string]int64{
+		"/nix/store/0a8xq2l0dfhkxvn5hqmz4gndp4kiqbv0-gzip-1.14":   1500,
+		"/nix/store/i6fl7i35dacvxqpzya6h78nacciwryfh-bash-5.2p37": 5000,
+		"/nix/store/5qfwz7s2ylj1xk3wyrbc7ys4lf0f2d7r-cuda-12.4":   2147483648,
+	}
+
+	diff := Calculate(before, after)
+	sizes := calculateSizes(&diff, before, after, beforeSizes, afterSizes)
+
+	expected := []PackageSize{
+		{PName: "cuda", Before: 0, After: 2147483648},
+		{PName: "gnutar", Before: 3000, After: 0},
+		{PName: "gzip", Before: 1000, After: 1500},
+	}
+
+	if d := deep.Equal(sizes, expected); d != nil {
+		t.Error(d)
+	}
+
+	rendered := renderSizes(sizes)
+	expectedRender := "Size changes:\n" +
+		"#01  cuda    +2.00GiB (0.00B -> 2.00GiB)\n" +
+		"#02  gnutar  -2.93KiB (2.93KiB -> 0.00B)\n" +
+		"#03  gzip    +500.00B (1000.00B -> 1.46KiB)\n"
+
+	if rendered != expectedRender {
+		t.Errorf("unexpected output:\n%s", rendered)
+	}
+}
@@ -0,0 +1,182 @@
+package diff
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/arnarg/nilla-utils/internal/exec"
+	"github.com/arnarg/nilla-utils/internal/store"
+)
+
+// generationClosure is the closure of a generation queried from the nix daemon.
+type generationClosure struct {
+	// Store path of the generation
+	path string
+	// Store path of the system path, empty if there is none
+	sw    string
+	infos map[string]*store.PathInfo
+}
+
+// queryClosure queries the closure of a generation from the nix daemon. It
+// returns nil when the daemon can't be used and the nix CLI should be used
+// instead.
+func queryClosure(executor exec.Executor, path string) (*generationClosure, error) {
+	client, err := store.Connect(executor)
+	if err != nil {
+		return nil, nil
+	}
+
+	// The daemon only knows about store paths, not the
+	// profile links generations are usually referred to by
+	top, sw, err := resolveGeneration(executor, path)
+	if err != nil || !store.IsStorePath(top) {
+		return nil, nil
+	}
+	if !store.IsStorePath(sw) {
+		sw = ""
+	}
+
+	infos, err := client.QueryClosure(top)
+	if err != nil {
+		return nil, err
+	}
+
+	return &generationClosure{top, sw, infos}, nil
+}
+
+// storeClosure returns the closure of the generation queried from the nix
+// daemon, walking it at most once per generation. Returns nil when the nix
+// CLI should be used instead.
+func (g *Generation) storeClosure() (*generationClosure, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if !g.closureQueried {
+		closure, err := queryClosure(g.Executor, g.Path)
+		if err != nil {
+			return nil, err
+		}
+		g.closure, g.closureQueried = closure, true
+	}
+
+	return g.closure, nil
+}
+
+// closureSource answers queries about the closure of a generation.
+type closureSource interface {
+	// Paths of the packages in the system path and every path in the closure
+	packagePaths() ([]string, error)
+	// NAR size of every path in the closure
+	sizes() (map[string]int64, error)
+	// Sum of the NAR sizes of every path in the closure
+	closureSize() (int64, error)
+	// Graph of references in the closure
+	graph() (*referenceGraph, error)
+}
+
+// source returns where to query the closure of the generation from. The nix
+// daemon is preferred, the nix CLI is used when the daemon can't be used.
+func (g *Generation) source() (closureSource, error) {
+	closure, err := g.storeClosure()
+	if err != nil {
+		return nil, err
+	}
+	if closure != nil {
+		return closure, nil
+	}
+
+	return &cliClosure{g}, nil
+}
+
+// cliClosure queries the closure of a generation with the nix CLI.
+type cliClosure struct {
+	gen *Generation
+}
+
+// resolveGeneration resolves the symlinks to the generation and
+// its system path.
+func resolveGeneration(executor exec.Executor, path string) (string, string, error) {
+	swPath := path + "/sw"
+
+	if executor.IsLocal() {
+		top, err := filepath.EvalSymlinks(path)
+		if err != nil {
+			return "", "", err
+		}
+
+		sw, err := filepath.EvalSymlinks(swPath)
+		if err != nil && !os.IsNotExist(err) {
+			return "", "", err
+		}
+
+		return top, sw, nil
+	}
+
+	// Only the last component has to exist, so both are
+	// printed if the generation exists
+	out, err := exec.Output(executor, "readlink", "-f", path, swPath)
+	if err != nil {
+		return "", "", err
+	}
+
+	lines := strings.Fields(string(out))
+	if len(lines) != 2 {
+		return "", "", nil
+	}
+
+	return lines[0], lines[1], nil
+}
+
+// packagePaths returns the paths of the packages in the system path
+// and every path in the closure, like `cliClosure.packagePaths`.
+func (c *generationClosure) packagePaths() ([]string, error) {
+	info, ok := c.infos[c.sw]
+	if !ok {
+		info = c.infos[c.path]
+	}
+
+	return append(slices.Clone(info.References), slices.Sorted(maps.Keys(c.infos))...), nil
+}
+
+// sizes returns the NAR size of every path in the closure.
+func (c *generationClosure) sizes() (map[string]int64, error) {
+	sizes := map[string]int64{}
+	for path, info := range c.infos {
+		sizes[path] = info.NarSize
+	}
+	return sizes, nil
+}
+
+// closureSize returns the sum of the NAR sizes of every path in the closure.
+func (c *generationClosure) closureSize() (int64, error) {
+	sizes, _ := c.sizes()
+	return sumSizes(sizes), nil
+}
+
+// graph returns the graph of references in the closure,
+// like `nix-store --query --graph`.
+func (c *generationClosure) graph() (*referenceGraph, error) {
+	graph := &referenceGraph{references: map[string][]string{}}
+
+	for path, info := range c.infos {
+		node := strings.TrimPrefix(path, "/nix/store/")
+		graph.nodes = append(graph.nodes, node)
+
+		for _, ref := range info.References {
+			if ref != path {
+				graph.references[node] = append(graph.references[node], strings.TrimPrefix(ref, "/nix/store/"))
+			}
+		}
+	}
+
+	// Sort references to get the same chains on every run
+	for _, refs := range graph.references {
+		slices.Sort(refs)
+	}
+	slices.Sort(graph.nodes)
+
+	return graph, nil
+}
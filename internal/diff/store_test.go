@@ -0,0 +1,97 @@
+package diff
+
+import (
+	"testing"
+
+	"github.com/arnarg/nilla-utils/internal/store"
+	"github.com/go-test/deep"
+)
+
+func TestGenerationClosure(t *testing.T) {
+	closure := &generationClosure{
+		path: "/nix/store/aaaa-nixos-system-laptop-24.11",
+		sw:   "/nix/store/bbbb-system-path",
+		infos: map[string]*store.PathInfo{
+			"/nix/store/aaaa-nixos-system-laptop-24.11": {
+				References: []string{"/nix/store/bbbb-system-path", "/nix/store/dddd-glibc-2.40-36"},
+				NarSize:    1,
+			},
+			"/nix/store/bbbb-system-path": {
+				References: []string{"/nix/store/bbbb-system-path", "/nix/store/cccc-gzip-1.13"},
+				NarSize:    2,
+			},
+			"/nix/store/cccc-gzip-1.13": {
+				References: []string{"/nix/store/dddd-glibc-2.40-36"},
+				NarSize:    4,
+			},
+			"/nix/store/dddd-glibc-2.40-36": {
+				References: []string{},
+				NarSize:    8,
+			},
+		},
+	}
+
+	expectedPaths := []string{
+		"/nix/store/bbbb-system-path",
+		"/nix/store/cccc-gzip-1.13",
+		"/nix/store/aaaa-nixos-system-laptop-24.11",
+		"/nix/store/bbbb-system-path",
+		"/nix/store/cccc-gzip-1.13",
+		"/nix/store/dddd-glibc-2.40-36",
+	}
+	paths, err := closure.packagePaths()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := deep.Equal(paths, expectedPaths); diff != nil {
+		t.Error(diff)
+	}
+
+	if size, err := closure.closureSize(); err != nil || size != 15 {
+		t.Errorf("expected closure size of 15, got %d (%v)", size, err)
+	}
+
+	// Self references are not part of the graph
+	graph, err := closure.graph()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedReferences := map[string][]string{
+		"aaaa-nixos-system-laptop-24.11": {"bbbb-system-path", "dddd-glibc-2.40-36"},
+		"bbbb-system-path":               {"cccc-gzip-1.13"},
+		"cccc-gzip-1.13":                 {"dddd-glibc-2.40-36"},
+	}
+	if diff := deep.Equal(graph.references, expectedReferences); diff != nil {
+		t.Error(diff)
+	}
+
+	chains := graph.shortestChains(func(node string) bool {
+		return node == "dddd-glibc-2.40-36"
+	})
+	if diff := deep.Equal(chains, [][]string{{"aaaa-nixos-system-laptop-24.11", "dddd-glibc-2.40-36"}}); diff != nil {
+		t.Error(diff)
+	}
+
+	// Generations walk their closure once, so every query is answered
+	// from it without running anything through an executor
+	gen := &Generation{Path: closure.path, closure: closure, closureQueried: true}
+
+	if size, err := ClosureSize(gen); err != nil || size != 15 {
+		t.Errorf("expected closure size of 15, got %d (%v)", size, err)
+	}
+
+	paths, sizes, err := queryContents(gen)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := deep.Equal(paths, expectedPaths); diff != nil {
+		t.Error(diff)
+	}
+	if size := sumSizes(sizes); size != 15 {
+		t.Errorf("expected closure size of 15, got %d", size)
+	}
+
+	if _, err := Why(gen, "gzip"); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,84 @@
+package diff
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Target is one side of a diff given on the command line.
+type Target struct {
+	// Host to connect to, empty for the local system.
+	Host string
+	// Generation ID, 0 for the current generation.
+	Generation int
+	// Path to a store path or a link to one.
+	Path string
+	// Name of a configuration in the project to build.
+	Name string
+}
+
+// ParseTarget parses a diff target, which is one of:
+//
+//   - a generation ID, e.g. "42", or "current"
+//   - a store path or a link to one, e.g. "./result"
+//   - a host and a generation ID, e.g. "root@server:42" or "server:current"
+//   - the name of a configuration in the project
+func ParseTarget(s string) (*Target, error) {
+	if s == "" {
+		return nil, fmt.Errorf("empty diff target")
+	}
+
+	// Anything that looks like a path is used as is
+	if strings.ContainsRune(s, '/') || s == "." {
+		path, err := filepath.Abs(s)
+		if err != nil {
+			return nil, err
+		}
+		return &Target{Path: path}, nil
+	}
+
+	host, gen, remote := strings.Cut(s, ":")
+	if remote && host == "" {
+		return nil, fmt.Errorf("invalid diff target \"%s\": missing host", s)
+	}
+	if !remote {
+		host, gen = "", s
+	}
+
+	// Generation of the local or remote system
+	if gen == "current" || (remote && gen == "") {
+		return &Target{Host: host}, nil
+	}
+	if id, err := strconv.Atoi(gen); err == nil {
+		if id < 1 {
+			return nil, fmt.Errorf("invalid generation ID \"%s\"", gen)
+		}
+		return &Target{Host: host, Generation: id}, nil
+	}
+	if remote {
+		return nil, fmt.Errorf("invalid generation ID \"%s\"", gen)
+	}
+
+	return &Target{Name: s}, nil
+}
+
+// String returns the target as it would be given on the command line.
+func (t *Target) String() string {
+	switch {
+	case t.Path != "":
+		return t.Path
+	case t.Name != "":
+		return t.Name
+	}
+
+	gen := "current"
+	if t.Generation > 0 {
+		gen = strconv.Itoa(t.Generation)
+	}
+	if t.Host != "" {
+		return fmt.Sprintf("%s:%s", t.Host, gen)
+	}
+	return gen
+}
@@ -0,0 +1,113 @@
+package diff
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-test/deep"
+)
+
+func TestParseTarget(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		in   string
+		out  *Target
+		err  bool
+	}{
+		{
+			name: "generation ID",
+			in:   "42",
+			out:  &Target{Generation: 42},
+		},
+		{
+			name: "current generation",
+			in:   "current",
+			out:  &Target{},
+		},
+		{
+			name: "store path",
+			in:   "/nix/store/i6fl7i35dacvxqpzya6h78nacciwryfh-nixos-system-foo",
+			out:  &Target{Path: "/nix/store/i6fl7i35dacvxqpzya6h78nacciwryfh-nixos-system-foo"},
+		},
+		{
+			name: "relative link",
+			in:   "./result",
+			out:  &Target{Path: filepath.Join(wd, "result")},
+		},
+		{
+			name: "remote generation",
+			in:   "root@server:42",
+			out:  &Target{Host: "root@server", Generation: 42},
+		},
+		{
+			name: "remote current generation",
+			in:   "server:current",
+			out:  &Target{Host: "server"},
+		},
+		{
+			name: "remote without generation",
+			in:   "server:",
+			out:  &Target{Host: "server"},
+		},
+		{
+			name: "configuration name",
+			in:   "my-laptop",
+			out:  &Target{Name: "my-laptop"},
+		},
+		{
+			name: "home configuration name",
+			in:   "user@my-laptop",
+			out:  &Target{Name: "user@my-laptop"},
+		},
+		{
+			name: "invalid remote generation",
+			in:   "server:latest",
+			err:  true,
+		},
+		{
+			name: "missing host",
+			in:   ":42",
+			err:  true,
+		},
+		{
+			name: "zero generation",
+			in:   "0",
+			err:  true,
+		},
+		{
+			name: "empty",
+			in:   "",
+			err:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target, err := ParseTarget(tt.in)
+			if tt.err {
+				if err == nil {
+					t.Errorf("expected error, got %#v", target)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if diff := deep.Equal(target, tt.out); diff != nil {
+				t.Error(diff)
+			}
+
+			// Targets should round trip, except relative paths
+			if tt.out.Path == "" && target.String() != tt.in && tt.in != "server:" {
+				t.Errorf("unexpected string: \"%s\" != \"%s\"", target.String(), tt.in)
+			}
+		})
+	}
+}
@@ -0,0 +1,166 @@
+package diff
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Files where the part that differs has more lines than
+// this squared are not diffed line by line.
+const maxDiffLines = 2000
+
+type lineOp struct {
+	kind byte
+	line string
+}
+
+// diffLines returns the edit script turning a into b, using the longest
+// common subsequence of the lines. Returns nil if the files are too large.
+func diffLines(a, b []string) []lineOp {
+	// Trim common prefix and suffix, which is most of a config file
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix &&
+		a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	ma := a[prefix : len(a)-suffix]
+	mb := b[prefix : len(b)-suffix]
+	if len(ma)*len(mb) > maxDiffLines*maxDiffLines {
+		return nil
+	}
+
+	// lcs[i][j] is the length of the longest common
+	// subsequence of ma[i:] and mb[j:]
+	lcs := make([][]int32, len(ma)+1)
+	for i := range lcs {
+		lcs[i] = make([]int32, len(mb)+1)
+	}
+	for i := len(ma) - 1; i >= 0; i-- {
+		for j := len(mb) - 1; j >= 0; j-- {
+			if ma[i] == mb[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	ops := []lineOp{}
+	for _, line := range a[:prefix] {
+		ops = append(ops, lineOp{' ', line})
+	}
+
+	i, j := 0, 0
+	for i < len(ma) || j < len(mb) {
+		switch {
+		case i < len(ma) && j < len(mb) && ma[i] == mb[j]:
+			ops = append(ops, lineOp{' ', ma[i]})
+			i++
+			j++
+		// Removed lines go before added lines
+		case i < len(ma) && (j == len(mb) || lcs[i+1][j] >= lcs[i][j+1]):
+			ops = append(ops, lineOp{'-', ma[i]})
+			i++
+		default:
+			ops = append(ops, lineOp{'+', mb[j]})
+			j++
+		}
+	}
+
+	for _, line := range a[len(a)-suffix:] {
+		ops = append(ops, lineOp{' ', line})
+	}
+
+	return ops
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return []string{}
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+// UnifiedDiff returns a unified diff of two texts with the number of lines
+// of context, or an empty string if they are the same.
+func UnifiedDiff(from, to, fromName, toName string, context int) string {
+	if from == to {
+		return ""
+	}
+
+	a, b := splitLines(from), splitLines(to)
+	ops := diffLines(a, b)
+	if ops == nil {
+		return fmt.Sprintf("--- %s\n+++ %s\nFiles are too large to compare\n", fromName, toName)
+	}
+
+	strb := &strings.Builder{}
+	fmt.Fprintf(strb, "--- %s\n+++ %s\n", fromName, toName)
+
+	// Group changes closer than two contexts apart into hunks
+	for start := 0; start < len(ops); {
+		// Find next change
+		for start < len(ops) && ops[start].kind == ' ' {
+			start++
+		}
+		if start == len(ops) {
+			break
+		}
+
+		// Find where the hunk ends
+		end, unchanged := start, 0
+		for end < len(ops) && unchanged <= 2*context {
+			if ops[end].kind == ' ' {
+				unchanged++
+			} else {
+				unchanged = 0
+			}
+			end++
+		}
+		end -= max(unchanged-context, 0)
+		hunkStart := max(start-context, 0)
+
+		// Count line numbers up to the hunk
+		lineA, lineB := 1, 1
+		for _, op := range ops[:hunkStart] {
+			if op.kind != '+' {
+				lineA++
+			}
+			if op.kind != '-' {
+				lineB++
+			}
+		}
+
+		countA, countB := 0, 0
+		for _, op := range ops[hunkStart:end] {
+			if op.kind != '+' {
+				countA++
+			}
+			if op.kind != '-' {
+				countB++
+			}
+		}
+
+		// Empty ranges start at the line before, like in GNU diff
+		if countA == 0 {
+			lineA--
+		}
+		if countB == 0 {
+			lineB--
+		}
+
+		fmt.Fprintf(strb, "@@ -%d,%d +%d,%d @@\n", lineA, countA, lineB, countB)
+		for _, op := range ops[hunkStart:end] {
+			fmt.Fprintf(strb, "%c%s\n", op.kind, op.line)
+		}
+
+		start = end
+	}
+
+	return strb.String()
+}
@@ -0,0 +1,64 @@
+package diff
+
+import (
+	"testing"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	tests := []struct {
+		name string
+		from string
+		to   string
+		out  string
+	}{
+		{
+			name: "same content",
+			from: "a\nb\n",
+			to:   "a\nb\n",
+			out:  "",
+		},
+		{
+			name: "changed line",
+			from: "PermitRootLogin no\nPort 22\nUsePAM yes\nX11Forwarding no\n",
+			to:   "PermitRootLogin yes\nPort 22\nUsePAM yes\nX11Forwarding no\n",
+			out: "--- a\n+++ b\n" +
+				"@@ -1,2 +1,2 @@\n" +
+				"-PermitRootLogin no\n" +
+				"+PermitRootLogin yes\n" +
+				" Port 22\n",
+		},
+		{
+			name: "separate hunks",
+			from: "1\n2\n3\n4\n5\n6\n7\n8\n",
+			to:   "1\ntwo\n3\n4\n5\n6\n7\neight\n",
+			out: "--- a\n+++ b\n" +
+				"@@ -1,3 +1,3 @@\n" +
+				" 1\n" +
+				"-2\n" +
+				"+two\n" +
+				" 3\n" +
+				"@@ -7,2 +7,2 @@\n" +
+				" 7\n" +
+				"-8\n" +
+				"+eight\n",
+		},
+		{
+			name: "added lines to empty file",
+			from: "",
+			to:   "a\nb\n",
+			out: "--- a\n+++ b\n" +
+				"@@ -0,0 +1,2 @@\n" +
+				"+a\n" +
+				"+b\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := UnifiedDiff(tt.from, tt.to, "a", "b", 1)
+			if out != tt.out {
+				t.Errorf("unexpected diff:\n%s\nexpected:\n%s", out, tt.out)
+			}
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package diff
+
+import (
+	"strings"
+)
+
+// CompareVersions compares two versions like `builtins.compareVersions`
+// in nix. It returns -1 if a is older than b, 1 if a is newer than b
+// and 0 if they are the same version.
+//
+// Versions are split into components of digits or other characters,
+// separated by "." and "-". Numeric components are compared as numbers,
+// "pre" is older than anything else, an empty component is older than
+// a number and other components are compared as strings.
+func CompareVersions(a, b string) int {
+	for a != "" || b != "" {
+		var ca, cb string
+		ca, a = nextComponent(a)
+		cb, b = nextComponent(b)
+
+		if componentLess(ca, cb) {
+			return -1
+		}
+		if componentLess(cb, ca) {
+			return 1
+		}
+	}
+
+	return 0
+}
+
+func isSeparator(c byte) bool {
+	return c == '.' || c == '-'
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// Returns the next version component and the rest of the version.
+func nextComponent(s string) (string, string) {
+	// Skip separators
+	for s != "" && isSeparator(s[0]) {
+		s = s[1:]
+	}
+
+	if s == "" {
+		return "", ""
+	}
+
+	// A component is either all digits or all other characters
+	digits := isDigit(s[0])
+	i := 1
+	for i < len(s) && !isSeparator(s[i]) && isDigit(s[i]) == digits {
+		i++
+	}
+
+	return s[:i], s[i:]
+}
+
+func isNumber(s string) bool {
+	return s != "" && isDigit(s[0])
+}
+
+func componentLess(a, b string) bool {
+	na, nb := isNumber(a), isNumber(b)
+
+	switch {
+	case na && nb:
+		return numberLess(a, b)
+	case a == "" && nb:
+		return true
+	case a == "pre" && b != "pre":
+		return true
+	case b == "pre":
+		return false
+	// Assume that "2.3a" is older than "2.3.1"
+	case nb:
+		return true
+	case na:
+		return false
+	}
+
+	return a < b
+}
+
+// Compares numeric components without overflowing on long numbers.
+func numberLess(a, b string) bool {
+	a = strings.TrimLeft(a, "0")
+	b = strings.TrimLeft(b, "0")
+
+	if len(a) != len(b) {
+		return len(a) < len(b)
+	}
+	return a < b
+}
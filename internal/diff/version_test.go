@@ -0,0 +1,47 @@
+package diff
+
+import (
+	"testing"
+)
+
+func TestCompareVersions(t *testing.T) {
+	// Cases from the documentation and tests of builtins.compareVersions
+	tests := []struct {
+		a   string
+		b   string
+		out int
+	}{
+		{"1.0", "2.3", -1},
+		{"2.1", "2.3", -1},
+		{"2.3", "2.3", 0},
+		{"2.5", "2.3", 1},
+		{"3.1", "2.3", 1},
+		{"2.3.1", "2.3", 1},
+		{"2.3.1", "2.3a", 1},
+		{"2.3pre1", "2.3", -1},
+		{"2.3pre3", "2.3pre12", -1},
+		{"2.3a", "2.3c", -1},
+		{"2.3pre1", "2.3c", -1},
+		{"2.3pre1", "2.3q", -1},
+		{"1.13", "1.13-lib", -1},
+		{"6.6.63", "6.6.64", -1},
+		{"24.11.20241130.62c435d", "24.11.20241205.a0f3e10", -1},
+		{"1.01", "1.1", 0},
+		{"123456789012345678901234567890", "123456789012345678901234567891", -1},
+		{"", "1", -1},
+		{"", "", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.a+" "+tt.b, func(t *testing.T) {
+			if res := CompareVersions(tt.a, tt.b); res != tt.out {
+				t.Errorf("unexpected result: %d != %d", res, tt.out)
+			}
+
+			// Comparison should be antisymmetric
+			if res := CompareVersions(tt.b, tt.a); res != -tt.out {
+				t.Errorf("unexpected reverse result: %d != %d", res, -tt.out)
+			}
+		})
+	}
+}
@@ -0,0 +1,201 @@
+package diff
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/arnarg/nilla-utils/internal/exec"
+	"github.com/charmbracelet/lipgloss"
+)
+
+var (
+	graphNodeRegex = regexp.MustCompile(`^"([^"]+)" \[`)
+	graphEdgeRegex = regexp.MustCompile(`^"([^"]+)" -> "([^"]+)"`)
+)
+
+// referenceGraph is the graph of references in a closure.
+type referenceGraph struct {
+	nodes      []string
+	references map[string][]string
+}
+
+// parseGraph parses the output of `nix-store --query --graph`. Nodes are
+// named after their store path without the store directory and every edge
+// goes from a reference to the path referring to it.
+func parseGraph(buf []byte) *referenceGraph {
+	graph := &referenceGraph{references: map[string][]string{}}
+	seen := map[string]bool{}
+
+	addNode := func(node string) {
+		if !seen[node] {
+			seen[node] = true
+			graph.nodes = append(graph.nodes, node)
+		}
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(buf))
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		if res := graphEdgeRegex.FindStringSubmatch(line); res != nil {
+			addNode(res[1])
+			addNode(res[2])
+			graph.references[res[2]] = append(graph.references[res[2]], res[1])
+		} else if res := graphNodeRegex.FindStringSubmatch(line); res != nil {
+			addNode(res[1])
+		}
+	}
+
+	// Sort references to get the same chains on every run
+	for _, refs := range graph.references {
+		slices.Sort(refs)
+	}
+	slices.Sort(graph.nodes)
+
+	return graph
+}
+
+// roots returns the nodes no other node refers to.
+func (g *referenceGraph) roots() []string {
+	referenced := map[string]bool{}
+	for _, refs := range g.references {
+		for _, ref := range refs {
+			referenced[ref] = true
+		}
+	}
+
+	roots := []string{}
+	for _, node := range g.nodes {
+		if !referenced[node] {
+			roots = append(roots, node)
+		}
+	}
+	return roots
+}
+
+// shortestChains returns the shortest chain of references from the roots
+// to every node matching.
+func (g *referenceGraph) shortestChains(match func(node string) bool) [][]string {
+	parents := map[string]string{}
+	visited := map[string]bool{}
+
+	queue := g.roots()
+	for _, root := range queue {
+		visited[root] = true
+	}
+
+	// Breadth first search finds the shortest chains
+	targets := []string{}
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+
+		if match(node) {
+			targets = append(targets, node)
+		}
+
+		for _, ref := range g.references[node] {
+			if !visited[ref] {
+				visited[ref] = true
+				parents[ref] = node
+				queue = append(queue, ref)
+			}
+		}
+	}
+	slices.Sort(targets)
+
+	chains := [][]string{}
+	for _, target := range targets {
+		chain := []string{target}
+		for node := target; ; {
+			parent, ok := parents[node]
+			if !ok {
+				break
+			}
+			chain = append(chain, parent)
+			node = parent
+		}
+		slices.Reverse(chain)
+
+		chains = append(chains, chain)
+	}
+
+	return chains
+}
+
+// Why returns the shortest chain of references from the generation
+// to every store path of the package in its closure.
+func Why(gen *Generation, pname string) ([][]string, error) {
+	source, err := gen.source()
+	if err != nil {
+		return nil, err
+	}
+
+	graph, err := source.graph()
+	if err != nil {
+		return nil, err
+	}
+
+	chains := graph.shortestChains(func(node string) bool {
+		pkg := ParsePackageFromPath(storePath(node))
+		return pkg != nil && pkg.pname == pname
+	})
+
+	// Use full store paths, so they can be used with other tools
+	for _, chain := range chains {
+		for i, node := range chain {
+			chain[i] = storePath(node)
+		}
+	}
+
+	return chains, nil
+}
+
+// graph returns the graph of references in the closure.
+func (c *cliClosure) graph() (*referenceGraph, error) {
+	out, err := exec.Output(c.gen.Executor, "nix-store", "--query", "--graph", c.gen.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseGraph(out), nil
+}
+
+func storePath(node string) string {
+	return fmt.Sprintf("/nix/store/%s", node)
+}
+
+// RenderWhy renders the chains of references returned by Why.
+func RenderWhy(pname string, chains [][]string) string {
+	if len(chains) < 1 {
+		return fmt.Sprintf("%s is not in the closure\n", pname)
+	}
+
+	strb := &strings.Builder{}
+	for i, chain := range chains {
+		if i > 0 {
+			strb.WriteString("\n")
+		}
+
+		for depth, path := range chain {
+			style := lipgloss.NewStyle()
+			if depth == len(chain)-1 {
+				style = style.Foreground(lipgloss.Color("10"))
+			}
+
+			prefix := ""
+			if depth > 0 {
+				prefix = strings.Repeat("   ", depth-1) + "└─ "
+			}
+
+			fmt.Fprintf(strb, "%s%s\n", prefix, style.SetString(path).String())
+		}
+	}
+
+	return strb.String()
+}
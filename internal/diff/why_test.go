@@ -0,0 +1,74 @@
+package diff
+
+import (
+	"testing"
+
+	"github.com/go-test/deep"
+)
+
+// Output of `nix-store --query --graph` for a small closure
+var testGraph = `digraph G {
+"aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa-nixos-system-foo-24.11" [label = "nixos-system-foo-24.11", shape = box, style = filled, fillcolor = "#ff0000"];
+"bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb-system-path" -> "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa-nixos-system-foo-24.11" [color = "black"];
+"cccccccccccccccccccccccccccccccc-etc" -> "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa-nixos-system-foo-24.11" [color = "black"];
+"bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb-system-path" [label = "system-path", shape = box, style = filled, fillcolor = "#ff0000"];
+"dddddddddddddddddddddddddddddddd-gnutar-1.35" -> "bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb-system-path" [color = "black"];
+"cccccccccccccccccccccccccccccccc-etc" [label = "etc", shape = box, style = filled, fillcolor = "#ff0000"];
+"eeeeeeeeeeeeeeeeeeeeeeeeeeeeeeee-unit-foo.service" -> "cccccccccccccccccccccccccccccccc-etc" [color = "black"];
+"eeeeeeeeeeeeeeeeeeeeeeeeeeeeeeee-unit-foo.service" [label = "unit-foo.service", shape = box, style = filled, fillcolor = "#ff0000"];
+"ffffffffffffffffffffffffffffffff-gzip-1.13" -> "eeeeeeeeeeeeeeeeeeeeeeeeeeeeeeee-unit-foo.service" [color = "black"];
+"dddddddddddddddddddddddddddddddd-gnutar-1.35" [label = "gnutar-1.35", shape = box, style = filled, fillcolor = "#ff0000"];
+"ffffffffffffffffffffffffffffffff-gzip-1.13" -> "dddddddddddddddddddddddddddddddd-gnutar-1.35" [color = "black"];
+"ffffffffffffffffffffffffffffffff-gzip-1.13" [label = "gzip-1.13", shape = box, style = filled, fillcolor = "#ff0000"];
+}
+`
+
+func TestShortestChains(t *testing.T) {
+	graph := parseGraph([]byte(testGraph))
+
+	if diff := deep.Equal(graph.roots(), []string{"aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa-nixos-system-foo-24.11"}); diff != nil {
+		t.Error(diff)
+	}
+
+	chains := graph.shortestChains(func(node string) bool {
+		pkg := ParsePackageFromPath(storePath(node))
+		return pkg != nil && pkg.pname == "gzip"
+	})
+
+	// Both paths to gzip are equally short, the first one in
+	// sorted order is chosen
+	expected := [][]string{
+		{
+			"aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa-nixos-system-foo-24.11",
+			"bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb-system-path",
+			"dddddddddddddddddddddddddddddddd-gnutar-1.35",
+			"ffffffffffffffffffffffffffffffff-gzip-1.13",
+		},
+	}
+
+	if diff := deep.Equal(chains, expected); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestRenderWhy(t *testing.T) {
+	chains := [][]string{
+		{
+			"/nix/store/aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa-nixos-system-foo-24.11",
+			"/nix/store/bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb-system-path",
+			"/nix/store/ffffffffffffffffffffffffffffffff-gzip-1.13",
+		},
+	}
+
+	expected := "/nix/store/aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa-nixos-system-foo-24.11\n" +
+		"└─ /nix/store/bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb-system-path\n" +
+		"   └─ /nix/store/ffffffffffffffffffffffffffffffff-gzip-1.13\n"
+
+	if out := RenderWhy("gzip", chains); out != expected {
+		t.Errorf("unexpected output:\n%s", out)
+	}
+
+	if out := RenderWhy("gzip", nil); out != "gzip is not in the closure\n" {
+		t.Errorf("unexpected output: \"%s\"", out)
+	}
+}
@@ -1,6 +1,7 @@
 package exec
 
 import (
+	"bytes"
 	"context"
 	"io"
 )
@@ -23,3 +24,23 @@ type Command interface {
 	StdoutPipe() (io.Reader, error)
 	StderrPipe() (io.Reader, error)
 }
+
+// Output runs the command with the executor and returns its standard output.
+func Output(executor Executor, cmd string, args ...string) ([]byte, error) {
+	// Create buffer for output
+	buf := &bytes.Buffer{}
+
+	// Create command from executor
+	c, err := executor.Command(cmd, args...)
+	if err != nil {
+		return nil, err
+	}
+	c.SetStdout(buf)
+
+	// Run command
+	if err := c.Run(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
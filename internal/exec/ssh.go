@@ -65,8 +65,8 @@ func (e *sshExecutor) PathExists(path string) (bool, error) {
 	}
 
 	if err := cmd.Run(); err != nil {
-		if eerr, ok := err.(*ssh.ExitError); ok {
-			return eerr.ExitStatus() != 0, nil
+		if _, ok := err.(*ssh.ExitError); ok {
+			return false, nil
 		}
 		return false, err
 	}
@@ -122,11 +122,15 @@ func (c *sshCommand) Run() error {
 }
 
 func (c *sshCommand) Start() error {
-	// Build command string
-	cmd := fmt.Sprintf("%s %s", c.cmd, strings.Join(c.args, " "))
+	// Build command string, quoting every argument so that
+	// they reach the command unchanged like with a local command
+	cmd := shellQuote(c.cmd)
+	for _, arg := range c.args {
+		cmd += " " + shellQuote(arg)
+	}
 
-	// If we're running sudo, we should request a pty
-	if c.cmd == "sudo" && c.sess.Stdin != nil {
+	// If we're running sudo or doas, we should request a pty
+	if (c.cmd == "sudo" || c.cmd == "doas") && c.sess.Stdin != nil {
 		// Set up terminal modes
 		modes := ssh.TerminalModes{
 			ssh.ECHO:          0,     // disable echoing
@@ -206,6 +210,15 @@ func (c *sshCommand) cleanup() {
 	}
 }
 
+func shellQuote(s string) string {
+	// Leave simple strings unquoted
+	if s != "" && strings.Trim(s, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789@%+=:,./_-") == "" {
+		return s
+	}
+
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
 func parseTarget(target string) (user string, host string, port string) {
 	// Split on @
 	parts := strings.SplitN(target, "@", 2)
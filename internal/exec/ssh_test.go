@@ -56,3 +56,42 @@ func TestParseTarget(t *testing.T) {
 		})
 	}
 }
+
+func TestShellQuote(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		out  string
+	}{
+		{
+			name: "simple string",
+			in:   "/nix/store/2467crcbg119q5jb5nwqxm0c87ls3wnv-source",
+			out:  "/nix/store/2467crcbg119q5jb5nwqxm0c87ls3wnv-source",
+		},
+		{
+			name: "empty string",
+			in:   "",
+			out:  "''",
+		},
+		{
+			name: "with spaces",
+			in:   "echo $HOME",
+			out:  "'echo $HOME'",
+		},
+		{
+			name: "with single quotes",
+			in:   "it's",
+			out:  `'it'\''s'`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := shellQuote(tt.in)
+
+			if out != tt.out {
+				t.Errorf("unexpected quoted string: \"%s\" != \"%s\"", out, tt.out)
+			}
+		})
+	}
+}
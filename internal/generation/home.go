@@ -2,6 +2,7 @@ package generation
 
 import (
 	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io/fs"
@@ -9,11 +10,17 @@ import (
 	"path/filepath"
 	"regexp"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/arnarg/nilla-utils/internal/exec"
 	"github.com/arnarg/nilla-utils/internal/util"
 )
 
+// ErrNoCurrentGeneration is returned when there is no current generation,
+// e.g. before the first home-manager configuration was activated.
+var ErrNoCurrentGeneration = errors.New("current generation not found")
+
 func CurrentHomeGeneration() (*HomeGeneration, error) {
 	// Check in /nix/var/nix/profiles
 	if user := util.GetUser(); user != "" {
@@ -38,7 +45,49 @@ func CurrentHomeGeneration() (*HomeGeneration, error) {
 			return gen, nil
 		}
 	}
-	return nil, errors.New("current generation not found")
+	return nil, ErrNoCurrentGeneration
+}
+
+// CurrentHomeGenerationPath finds the path to the current home-manager
+// profile of the user the executor runs commands as. Unlike
+// `CurrentHomeGeneration` this works on remote hosts as well.
+func CurrentHomeGenerationPath(executor exec.Executor) (string, error) {
+	// Get the username of the executor
+	out, err := exec.Output(executor, "id", "-un")
+	if err != nil {
+		return "", err
+	}
+	user := strings.TrimSpace(string(out))
+
+	// Get the home directory of the executor
+	out, err = exec.Output(executor, "printenv", "HOME")
+	if err != nil {
+		return "", err
+	}
+	home := strings.TrimSpace(string(out))
+
+	// Check the same profile directories as `CurrentHomeGeneration`
+	dirs := []string{}
+	if user != "" {
+		dirs = append(dirs, fmt.Sprintf("/nix/var/nix/profiles/per-user/%s", user))
+	}
+	if home != "" {
+		dirs = append(dirs, fmt.Sprintf("%s/.local/state/nix/profiles", home))
+	}
+
+	for _, dir := range dirs {
+		p := fmt.Sprintf("%s/home-manager", dir)
+
+		exists, err := executor.PathExists(p)
+		if err != nil {
+			return "", err
+		}
+		if exists {
+			return p, nil
+		}
+	}
+
+	return "", ErrNoCurrentGeneration
 }
 
 func currentHomeGeneration(dir string) (*HomeGeneration, error) {
@@ -80,9 +129,10 @@ func currentHomeGeneration(dir string) (*HomeGeneration, error) {
 }
 
 type HomeGeneration struct {
-	ID        int
-	BuildDate time.Time
-	Version   string
+	ID             int
+	BuildDate      time.Time
+	Version        string
+	Specialisation string
 
 	path string
 }
@@ -107,14 +157,73 @@ func NewHomeGeneration(root string, info fs.FileInfo) (*HomeGeneration, error) {
 		return nil, err
 	}
 
+	// Look up specialisation, if one was recorded
+	spec := ""
+	if target, err := filepath.EvalSymlinks(path); err == nil {
+		spec = loadHomeSpecialisations()[target]
+	}
+
 	return &HomeGeneration{
-		ID:        id,
-		BuildDate: info.ModTime(),
-		Version:   string(bytes.TrimSpace(homeVer)),
-		path:      path,
+		ID:             id,
+		BuildDate:      info.ModTime(),
+		Version:        string(bytes.TrimSpace(homeVer)),
+		Specialisation: spec,
+		path:           path,
 	}, nil
 }
 
+func homeSpecialisationsFile() string {
+	return filepath.Join(stateDir(), "home-specialisations.json")
+}
+
+func loadHomeSpecialisations() map[string]string {
+	specs := map[string]string{}
+
+	buf, err := os.ReadFile(homeSpecialisationsFile())
+	if err != nil {
+		return specs
+	}
+
+	if err := json.Unmarshal(buf, &specs); err != nil {
+		return map[string]string{}
+	}
+
+	return specs
+}
+
+// RecordHomeSpecialisation records that the activation package at path
+// is the specialisation with the provided name, so that it can be
+// displayed when listing generations.
+func RecordHomeSpecialisation(path, name string) error {
+	// Resolve the specialisation link to the real store path
+	target, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return err
+	}
+
+	specs := loadHomeSpecialisations()
+	specs[target] = name
+
+	// Drop entries for store paths that no longer exist
+	for p := range specs {
+		if _, err := os.Stat(p); err != nil {
+			delete(specs, p)
+		}
+	}
+
+	buf, err := json.MarshalIndent(specs, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	file := homeSpecialisationsFile()
+	if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		return err
+	}
+
+	return os.WriteFile(file, buf, 0o644)
+}
+
 func (g *HomeGeneration) Delete() error {
 	if err := os.Remove(g.path); err != nil {
 		if os.IsNotExist(err) {
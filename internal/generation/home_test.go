@@ -0,0 +1,43 @@
+package generation
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/arnarg/nilla-utils/internal/exec"
+)
+
+func TestCurrentHomeGenerationPath(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	matches, _ := filepath.Glob("/nix/var/nix/profiles/per-user/*/home-manager")
+	if len(matches) > 0 {
+		t.Skip("home-manager profiles exist in /nix/var/nix/profiles/per-user")
+	}
+
+	executor := remoteExecutor{exec.NewLocalExecutor()}
+
+	// Users that never activated home-manager have no current generation
+	if _, err := CurrentHomeGenerationPath(executor); !errors.Is(err, ErrNoCurrentGeneration) {
+		t.Fatalf("expected ErrNoCurrentGeneration, got %v", err)
+	}
+
+	profiles := filepath.Join(home, ".local/state/nix/profiles")
+	if err := os.MkdirAll(filepath.Join(profiles, "home-manager-1-link"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("home-manager-1-link", filepath.Join(profiles, "home-manager")); err != nil {
+		t.Fatal(err)
+	}
+
+	path, err := CurrentHomeGenerationPath(executor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := filepath.Join(profiles, "home-manager"); path != expected {
+		t.Errorf("unexpected path: \"%s\" != \"%s\"", path, expected)
+	}
+}
@@ -10,6 +10,8 @@ import (
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/arnarg/nilla-utils/internal/exec"
 )
 
 const PROFILES_DIR = "/nix/var/nix/profiles"
@@ -152,3 +154,96 @@ func ListNixOSGenerations() ([]*NixOSGeneration, error) {
 
 	return generations, nil
 }
+
+// Shell script printing one line per generation in the profiles directory
+// with tab separated link name, modification time, NixOS version and kernel
+// modules directories, followed by a line with the current generation.
+const listNixOSGenerationsScript = `
+cd /nix/var/nix/profiles || exit 1
+for l in system-*-link; do
+	[ -L "$l" ] || continue
+	printf 'gen\t%s\t%s\t%s\t%s\n' \
+		"$l" \
+		"$(stat -c %Y "$l")" \
+		"$(cat "$l/nixos-version" 2>/dev/null)" \
+		"$(ls "$l/kernel-modules/lib/modules" 2>/dev/null | tr '\n' ' ')"
+done
+printf 'current\t%s\n' "$(readlink system)"
+`
+
+// ListNixOSGenerationsOn lists the NixOS generations on the host of the executor,
+// along with the current generation. Unlike `ListNixOSGenerations` this works on
+// remote hosts as well.
+func ListNixOSGenerationsOn(executor exec.Executor) ([]*NixOSGeneration, *NixOSGeneration, error) {
+	out, err := exec.Output(executor, "sh", "-c", listNixOSGenerationsScript)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return parseNixOSGenerations(strings.TrimSpace(string(out)))
+}
+
+func parseNixOSGenerations(out string) ([]*NixOSGeneration, *NixOSGeneration, error) {
+	generations := []*NixOSGeneration{}
+	currentName := ""
+	regex := regexp.MustCompile(`^system-(\d+)-link$`)
+	kernelRegex := regexp.MustCompile(`^\d+\.\d+\.\d+$`)
+
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Split(line, "\t")
+
+		switch fields[0] {
+		case "current":
+			if len(fields) > 1 {
+				currentName = filepath.Base(fields[1])
+			}
+
+		case "gen":
+			if len(fields) < 5 {
+				continue
+			}
+
+			// Get ID from name
+			strID := regex.FindStringSubmatch(fields[1])
+			if strID == nil {
+				continue
+			}
+			id, err := strconv.Atoi(strID[1])
+			if err != nil {
+				return nil, nil, err
+			}
+
+			// Parse modification time
+			mtime, err := strconv.ParseInt(fields[2], 10, 64)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			// Find the first kernel version matching semver
+			kernelVer := "Unknown"
+			for _, dir := range strings.Fields(fields[4]) {
+				if kernelRegex.MatchString(dir) {
+					kernelVer = dir
+					break
+				}
+			}
+
+			generations = append(generations, &NixOSGeneration{
+				ID:            id,
+				BuildDate:     time.Unix(mtime, 0),
+				Version:       fields[3],
+				KernelVersion: kernelVer,
+				path:          fmt.Sprintf("%s/%s", PROFILES_DIR, fields[1]),
+			})
+		}
+	}
+
+	// Find current generation
+	for _, gen := range generations {
+		if filepath.Base(gen.path) == currentName {
+			return generations, gen, nil
+		}
+	}
+
+	return nil, nil, ErrNoCurrentGeneration
+}
@@ -0,0 +1,42 @@
+package generation
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseNixOSGenerations(t *testing.T) {
+	out := "gen\tsystem-41-link\t1700000000\t24.11.20241130.62c435d (Vicuna)\t6.6.63 \n" +
+		"gen\tsystem-42-link\t1700086400\t24.11.20241205.a0f3e10 (Vicuna)\t6.6.64 6.6.64-modules \n" +
+		"current\tsystem-42-link\n"
+
+	generations, current, err := parseNixOSGenerations(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(generations) != 2 {
+		t.Fatalf("unexpected number of generations: %d != 2", len(generations))
+	}
+
+	if current.ID != 42 {
+		t.Errorf("unexpected current generation: %d != 42", current.ID)
+	}
+	if current.KernelVersion != "6.6.64" {
+		t.Errorf("unexpected kernel version: \"%s\" != \"6.6.64\"", current.KernelVersion)
+	}
+	if current.Path() != "/nix/var/nix/profiles/system-42-link" {
+		t.Errorf("unexpected path: \"%s\"", current.Path())
+	}
+	if !generations[0].BuildDate.Equal(time.Unix(1700000000, 0)) {
+		t.Errorf("unexpected build date: %s", generations[0].BuildDate)
+	}
+}
+
+func TestParseNixOSGenerationsWithoutCurrent(t *testing.T) {
+	out := "gen\tsystem-41-link\t1700000000\t24.11\t6.6.63\n"
+
+	if _, _, err := parseNixOSGenerations(out); err == nil {
+		t.Error("expected error when current generation is missing")
+	}
+}
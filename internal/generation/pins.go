@@ -0,0 +1,117 @@
+package generation
+
+import (
+	"encoding/json"
+	"os"
+	"os/user"
+	"path/filepath"
+	"slices"
+
+	"github.com/arnarg/nilla-utils/internal/exec"
+	"github.com/arnarg/nilla-utils/internal/util"
+)
+
+// Returns the directory nilla-utils keeps its state in. When running
+// elevated with sudo, the state of the invoking user is used.
+func stateDir() string {
+	if sudoUser := os.Getenv("SUDO_USER"); sudoUser != "" && util.IsRoot() {
+		if u, err := user.Lookup(sudoUser); err == nil && u.HomeDir != "" {
+			return filepath.Join(u.HomeDir, ".local", "state", "nilla-utils")
+		}
+	}
+
+	state := os.Getenv("XDG_STATE_HOME")
+	if state == "" {
+		state = filepath.Join(util.GetHomeDir(), ".local", "state")
+	}
+	return filepath.Join(state, "nilla-utils")
+}
+
+func pinsFile() string {
+	return filepath.Join(stateDir(), "pinned-generations.json")
+}
+
+// Prints the pins file of the user running it, if it exists.
+const readPinsScript = `
+f="${XDG_STATE_HOME:-$HOME/.local/state}/nilla-utils/pinned-generations.json"
+if [ -f "$f" ]; then
+	cat "$f"
+fi
+`
+
+func loadPins() map[string]bool {
+	buf, err := os.ReadFile(pinsFile())
+	if err != nil {
+		return map[string]bool{}
+	}
+
+	return parsePins(buf)
+}
+
+func parsePins(buf []byte) map[string]bool {
+	pins := map[string]bool{}
+
+	paths := []string{}
+	if err := json.Unmarshal(buf, &paths); err != nil {
+		return pins
+	}
+
+	for _, p := range paths {
+		pins[p] = true
+	}
+
+	return pins
+}
+
+// PinsOn returns the generation links pinned on the host of the executor.
+// Unlike `IsPinned` this works on remote hosts as well.
+func PinsOn(executor exec.Executor) (map[string]bool, error) {
+	if executor.IsLocal() {
+		return loadPins(), nil
+	}
+
+	out, err := exec.Output(executor, "sh", "-c", readPinsScript)
+	if err != nil {
+		return nil, err
+	}
+
+	return parsePins(out), nil
+}
+
+// IsPinned returns true if the generation link at path has been pinned.
+// Pinned generations are never removed by `generations clean`.
+func IsPinned(path string) bool {
+	return loadPins()[path]
+}
+
+// SetPinned pins or unpins the generation link at path.
+func SetPinned(path string, pinned bool) error {
+	pins := loadPins()
+	if pinned {
+		pins[path] = true
+	} else {
+		delete(pins, path)
+	}
+
+	// Drop entries for generations that no longer exist
+	paths := []string{}
+	for p := range pins {
+		if _, err := os.Lstat(p); err == nil {
+			paths = append(paths, p)
+		}
+	}
+
+	slices.Sort(paths)
+
+	buf, err := json.MarshalIndent(paths, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	file := pinsFile()
+	if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		return err
+	}
+
+	return os.WriteFile(file, buf, 0o644)
+}
@@ -0,0 +1,92 @@
+package generation
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/arnarg/nilla-utils/internal/exec"
+)
+
+func TestPins(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	t.Setenv("SUDO_USER", "")
+
+	links := t.TempDir()
+	gen1 := filepath.Join(links, "system-1-link")
+	gen2 := filepath.Join(links, "system-2-link")
+	for _, link := range []string{gen1, gen2} {
+		if err := os.Symlink("/nix/store/missing", link); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if IsPinned(gen1) {
+		t.Error("generation should not be pinned before pinning")
+	}
+
+	if err := SetPinned(gen1, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetPinned(gen2, true); err != nil {
+		t.Fatal(err)
+	}
+	if !IsPinned(gen1) || !IsPinned(gen2) {
+		t.Error("generations should be pinned")
+	}
+
+	// Pins of removed generations are dropped on the next change
+	if err := os.Remove(gen2); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetPinned(gen1, false); err != nil {
+		t.Fatal(err)
+	}
+	if IsPinned(gen1) {
+		t.Error("generation should not be pinned after unpinning")
+	}
+	if IsPinned(gen2) {
+		t.Error("pin of removed generation should be dropped")
+	}
+}
+
+// remoteExecutor runs commands locally while pretending to be remote.
+type remoteExecutor struct {
+	exec.Executor
+}
+
+func (e remoteExecutor) IsLocal() bool {
+	return false
+}
+
+func TestPinsOn(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	t.Setenv("SUDO_USER", "")
+
+	// No pins file
+	pins, err := PinsOn(remoteExecutor{exec.NewLocalExecutor()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pins) != 0 {
+		t.Errorf("expected no pins, got %v", pins)
+	}
+
+	link := filepath.Join(t.TempDir(), "system-1-link")
+	if err := os.Symlink("/nix/store/missing", link); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetPinned(link, true); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, executor := range []exec.Executor{exec.NewLocalExecutor(), remoteExecutor{exec.NewLocalExecutor()}} {
+		pins, err := PinsOn(executor)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !pins[link] {
+			t.Errorf("expected %s to be pinned (local: %t)", link, executor.IsLocal())
+		}
+	}
+}
@@ -0,0 +1,240 @@
+package nix
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Position is a location in a nix file referenced by an error.
+type Position struct {
+	File   string
+	Line   int
+	Column int
+}
+
+func (p *Position) String() string {
+	if p.Column > 0 {
+		return fmt.Sprintf("%s:%d:%d", p.File, p.Line, p.Column)
+	}
+	return fmt.Sprintf("%s:%d", p.File, p.Line)
+}
+
+// TraceFrame is a single `… while evaluating` frame of an evaluation trace.
+type TraceFrame struct {
+	Description string
+	Position    *Position
+}
+
+// EvalError is an error raised while evaluating nix code.
+type EvalError struct {
+	Message  string
+	Position *Position
+	Trace    []TraceFrame
+	// Hint is an explanation of a likely cause of the error, if known.
+	Hint string
+}
+
+func (e *EvalError) Error() string {
+	return e.Message
+}
+
+// MissingAttributeError is an evaluation error caused by accessing an
+// attribute that does not exist.
+type MissingAttributeError struct {
+	*EvalError
+	Attribute   string
+	Suggestions []string
+}
+
+// BuildError is an error raised when a builder of a derivation fails.
+type BuildError struct {
+	Drv     string
+	Message string
+	// Log contains the last log lines of the builder.
+	Log []string
+	// LogFile is the path to the complete build log, if it was saved.
+	LogFile string
+}
+
+func (e *BuildError) Error() string {
+	return e.Message
+}
+
+var (
+	ansiRegex       = regexp.MustCompile(`\x1b\[[0-9;]*[a-zA-Z]`)
+	positionRegex   = regexp.MustCompile(`^at (.+?):(\d+)(?::(\d+))?:?$`)
+	missingRegex    = regexp.MustCompile(`^attribute '(.+)' missing`)
+	notProvideRegex = regexp.MustCompile(`does not provide attribute '(.+)'`)
+	builderRegex    = regexp.MustCompile(`(?:builder for|Cannot build) '(/nix/store/[^']+\.drv)'`)
+	noPathRegex     = regexp.MustCompile(`path '(/nix/store/[^']+)' does not exist|getting status of '(/nix/store/[^']+)': No such file or directory`)
+)
+
+const untrackedHint = "Files in a git repository need to be tracked by git to be part of " +
+	"the project, make sure the file has been added with `git add`."
+
+// ParseError parses the first error in nix's error output into a typed
+// error. The result is either a *BuildError, *MissingAttributeError or
+// *EvalError. If no error message could be found, the trimmed output is
+// returned as a plain error.
+func ParseError(stderr string) error {
+	stderr = strings.TrimSpace(ansiRegex.ReplaceAllString(stderr, ""))
+	lines := strings.Split(stderr, "\n")
+
+	// Builder failures are reported in a single message
+	// that includes the last lines of the build log
+	if m := builderRegex.FindStringSubmatch(stderr); m != nil {
+		return parseBuildError(m[1], lines)
+	}
+
+	eerr := &EvalError{}
+	found := false
+
+	var frame *TraceFrame
+	inMessage := false
+scan:
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+
+		switch {
+		case line == "":
+			inMessage = false
+
+		case strings.HasPrefix(line, "… "):
+			inMessage = false
+			eerr.Trace = append(eerr.Trace, TraceFrame{
+				Description: strings.TrimPrefix(line, "… "),
+			})
+			frame = &eerr.Trace[len(eerr.Trace)-1]
+
+		case strings.HasPrefix(line, "error:"):
+			// Errors following the first one are
+			// usually caused by it
+			if found {
+				break scan
+			}
+
+			rest := strings.TrimSpace(strings.TrimPrefix(line, "error:"))
+			if rest == "" {
+				// Header of an error with a trace
+				continue
+			}
+
+			// The innermost error of the trace is the actual error
+			found = true
+			inMessage = true
+			frame = nil
+			eerr.Message = rest
+			eerr.Position = nil
+
+		case positionRegex.MatchString(line):
+			inMessage = false
+			pos := parsePosition(line)
+			if frame != nil {
+				frame.Position = pos
+				frame = nil
+			} else if found && eerr.Position == nil {
+				eerr.Position = pos
+			}
+
+		case inMessage:
+			eerr.Message = fmt.Sprintf("%s\n%s", eerr.Message, line)
+		}
+	}
+
+	if !found {
+		return errors.New(stderr)
+	}
+
+	// Missing attributes get suggestions
+	attr := ""
+	if m := missingRegex.FindStringSubmatch(eerr.Message); m != nil {
+		attr = m[1]
+	} else if m := notProvideRegex.FindStringSubmatch(eerr.Message); m != nil {
+		attr = m[1]
+	}
+	if attr != "" {
+		return &MissingAttributeError{
+			EvalError:   eerr,
+			Attribute:   attr,
+			Suggestions: parseSuggestions(lines),
+		}
+	}
+
+	// Paths missing from a project source are usually untracked files
+	if m := noPathRegex.FindStringSubmatch(eerr.Message); m != nil {
+		p := m[1] + m[2]
+		if strings.Contains(p, "-source/") {
+			eerr.Hint = untrackedHint
+		}
+	}
+
+	return eerr
+}
+
+func parseBuildError(drv string, lines []string) *BuildError {
+	berr := &BuildError{Drv: drv}
+
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+
+		// Log lines are prefixed with "> "
+		if rest, ok := strings.CutPrefix(line, ">"); ok {
+			berr.Log = append(berr.Log, strings.TrimPrefix(rest, " "))
+			continue
+		}
+
+		if berr.Message == "" && strings.Contains(line, drv) {
+			berr.Message = strings.TrimSuffix(
+				strings.TrimSpace(strings.TrimPrefix(line, "error:")),
+				";",
+			)
+		}
+	}
+
+	if berr.Message == "" {
+		berr.Message = fmt.Sprintf("builder for '%s' failed", drv)
+	}
+
+	return berr
+}
+
+func parsePosition(line string) *Position {
+	m := positionRegex.FindStringSubmatch(line)
+	if m == nil {
+		return nil
+	}
+
+	pos := &Position{File: m[1]}
+	pos.Line, _ = strconv.Atoi(m[2])
+	if m[3] != "" {
+		pos.Column, _ = strconv.Atoi(m[3])
+	}
+
+	return pos
+}
+
+// Parses "Did you mean one of foo, bar or baz?" and "Did you mean foo?".
+func parseSuggestions(lines []string) []string {
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+
+		rest, ok := strings.CutPrefix(line, "Did you mean ")
+		if !ok {
+			continue
+		}
+		rest = strings.TrimSuffix(strings.TrimPrefix(rest, "one of "), "?")
+
+		suggestions := []string{}
+		for _, s := range strings.Split(strings.ReplaceAll(rest, " or ", ", "), ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				suggestions = append(suggestions, s)
+			}
+		}
+		return suggestions
+	}
+
+	return nil
+}
@@ -0,0 +1,139 @@
+package nix
+
+import (
+	"testing"
+
+	"github.com/go-test/deep"
+)
+
+func TestParseError(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		out  error
+	}{
+		{
+			name: "simple eval error",
+			in:   "error: undefined variable 'pkgs'\n\n       at «string»:1:1:\n\n            1| pkgs\n             | ^\n",
+			out: &EvalError{
+				Message:  "undefined variable 'pkgs'",
+				Position: &Position{File: "«string»", Line: 1, Column: 1},
+			},
+		},
+		{
+			name: "eval error with trace",
+			in: "\x1b[31;1merror:\x1b[0m\n" +
+				"       … while evaluating the attribute 'config.system.build.toplevel'\n" +
+				"\n" +
+				"         at /nix/store/abc-source/nixos/modules/system/activation/top-level.nix:71:5:\n" +
+				"\n" +
+				"           70|\n" +
+				"           71|     system.build.toplevel = if config.system.forbiddenDependenciesRegexes == [] then baseSystemAssertWarn else failedAssertions;\n" +
+				"             |     ^\n" +
+				"\n" +
+				"       … while calling the 'throw' builtin\n" +
+				"\n" +
+				"       (stack trace truncated; use '--show-trace' to show the full trace)\n" +
+				"\n" +
+				"       error: The option `services.foo' does not exist. Definition values:\n" +
+				"       - In `/nix/store/xyz-source/configuration.nix'\n",
+			out: &EvalError{
+				Message: "The option `services.foo' does not exist. Definition values:\n" +
+					"- In `/nix/store/xyz-source/configuration.nix'",
+				Trace: []TraceFrame{
+					{
+						Description: "while evaluating the attribute 'config.system.build.toplevel'",
+						Position: &Position{
+							File:   "/nix/store/abc-source/nixos/modules/system/activation/top-level.nix",
+							Line:   71,
+							Column: 5,
+						},
+					},
+					{
+						Description: "while calling the 'throw' builtin",
+					},
+				},
+			},
+		},
+		{
+			name: "missing attribute with suggestions",
+			in: "error: attribute 'fooo' missing\n" +
+				"\n" +
+				"       at /nix/store/abc-source/nilla.nix:5:3:\n" +
+				"\n" +
+				"            4|\n" +
+				"            5|   x = systems.fooo;\n" +
+				"             |   ^\n" +
+				"       Did you mean one of foo, foa or bar?\n",
+			out: &MissingAttributeError{
+				EvalError: &EvalError{
+					Message:  "attribute 'fooo' missing",
+					Position: &Position{File: "/nix/store/abc-source/nilla.nix", Line: 5, Column: 3},
+				},
+				Attribute:   "fooo",
+				Suggestions: []string{"foo", "foa", "bar"},
+			},
+		},
+		{
+			name: "untracked file",
+			in: "error: path '/nix/store/2467crcbg119q5jb5nwqxm0c87ls3wnv-source/hosts/new.nix' does not exist\n" +
+				"\n" +
+				"       at /nix/store/2467crcbg119q5jb5nwqxm0c87ls3wnv-source/nilla.nix:10:5:\n",
+			out: &EvalError{
+				Message:  "path '/nix/store/2467crcbg119q5jb5nwqxm0c87ls3wnv-source/hosts/new.nix' does not exist",
+				Position: &Position{File: "/nix/store/2467crcbg119q5jb5nwqxm0c87ls3wnv-source/nilla.nix", Line: 10, Column: 5},
+				Hint:     untrackedHint,
+			},
+		},
+		{
+			name: "multiple errors",
+			in: "error: undefined variable 'pkgs'\n" +
+				"\n" +
+				"       at /nix/store/abc-source/hosts/laptop.nix:3:5:\n" +
+				"\n" +
+				"error:\n" +
+				"       … while evaluating the attribute 'config'\n" +
+				"\n" +
+				"       error: cannot coerce a set to a string\n",
+			out: &EvalError{
+				Message:  "undefined variable 'pkgs'",
+				Position: &Position{File: "/nix/store/abc-source/hosts/laptop.nix", Line: 3, Column: 5},
+			},
+		},
+		{
+			name: "builder failure",
+			in: "error: builder for '/nix/store/2467crcbg119q5jb5nwqxm0c87ls3wnv-hello-2.12.drv' failed with exit code 2;\n" +
+				"       last 3 log lines:\n" +
+				"       > make: *** [Makefile:10: all] Error 1\n" +
+				">\n" +
+				"       > error: build failed\n" +
+				"       For full logs, run 'nix log /nix/store/2467crcbg119q5jb5nwqxm0c87ls3wnv-hello-2.12.drv'.\n",
+			out: &BuildError{
+				Drv:     "/nix/store/2467crcbg119q5jb5nwqxm0c87ls3wnv-hello-2.12.drv",
+				Message: "builder for '/nix/store/2467crcbg119q5jb5nwqxm0c87ls3wnv-hello-2.12.drv' failed with exit code 2",
+				Log:     []string{"make: *** [Makefile:10: all] Error 1", "", "error: build failed"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := ParseError(tt.in)
+
+			if diff := deep.Equal(out, tt.out); diff != nil {
+				t.Error(diff)
+			}
+		})
+	}
+}
+
+func TestParseErrorPlain(t *testing.T) {
+	err := ParseError("ssh: connect to host foo port 22: Connection refused\n")
+
+	if _, ok := err.(*EvalError); ok {
+		t.Fatal("expected plain error")
+	}
+	if err.Error() != "ssh: connect to host foo port 22: Connection refused" {
+		t.Errorf("unexpected error: \"%s\"", err.Error())
+	}
+}
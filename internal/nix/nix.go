@@ -12,6 +12,10 @@ import (
 	"github.com/sourcegraph/conc/pool"
 )
 
+// Experimental features enabled for every nix command. Flakes are needed
+// to evaluate flake projects through `builtins.getFlake`.
+const experimentalFeatures = "nix-command flakes"
+
 type NixCommand struct {
 	cmd   string
 	args  []string
@@ -66,7 +70,7 @@ func (c NixCommand) Run(ctx context.Context) ([]byte, error) {
 	}
 
 	// Append rest of arguments
-	args = append(args, c.cmd, "--extra-experimental-features", "nix-command")
+	args = append(args, c.cmd, "--extra-experimental-features", experimentalFeatures)
 	args = append(args, c.args...)
 	if c.cmd == "build" {
 		args = append(args, "--print-out-paths")
@@ -129,6 +133,27 @@ func (c NixCommand) runWithReporter(ctx context.Context, cmd string, args []stri
 		return nil, err
 	}
 
+	// Record progress stream if requested
+	var progress io.Reader = stderr
+	if path := nextRecordPath(); path != "" {
+		f, err := os.Create(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		timing, err := os.Create(path + TimingSuffix)
+		if err != nil {
+			return nil, err
+		}
+		defer timing.Close()
+
+		rec := RecordProgress(stderr, f, timing)
+		defer rec.Close()
+
+		progress = rec
+	}
+
 	// Plug stdin if provided
 	if c.stdin != nil {
 		nixc.SetStdin(c.stdin)
@@ -146,8 +171,10 @@ func (c NixCommand) runWithReporter(ctx context.Context, cmd string, args []stri
 		WithFirstError()
 
 	// Run progress reporter
+	var reportErr error
 	p.Go(func(ctx context.Context) error {
-		return c.reporter.Run(ctx, NewProgressDecoder(stderr))
+		reportErr = c.reporter.Run(ctx, NewProgressDecoder(progress))
+		return reportErr
 	})
 
 	// Wait for nix command
@@ -157,6 +184,11 @@ func (c NixCommand) runWithReporter(ctx context.Context, cmd string, args []stri
 
 	// Wait for pool
 	if err := p.Wait(); err != nil {
+		// The error reported by nix is more descriptive
+		// than the exit status of the command
+		if reportErr != nil {
+			return nil, reportErr
+		}
 		return nil, err
 	}
 
@@ -45,6 +45,16 @@ const (
 	protoResultTypeFetchStatus      = 108
 )
 
+// ActivityType is the type of a nix activity, as referenced by
+// ResultSetExpectedEvent.
+type ActivityType int
+
+const (
+	ActivityTypeCopyPath     ActivityType = protoEventTypeCopyPath
+	ActivityTypeFileTransfer ActivityType = protoEventTypeFileTransfer
+	ActivityTypeBuild        ActivityType = protoEventTypeBuild
+)
+
 type ActionType int
 
 const (
@@ -115,6 +125,67 @@ func (e StartFileTransferEvent) Action() ActionType {
 	return ActionTypeStart
 }
 
+// StartSubstituteEvent
+type StartSubstituteEvent struct {
+	ID     int64
+	Parent int64
+	Path   string
+	URI    string
+	Text   string
+}
+
+func (e StartSubstituteEvent) Action() ActionType {
+	return ActionTypeStart
+}
+
+// StartQueryPathInfoEvent
+type StartQueryPathInfoEvent struct {
+	ID     int64
+	Parent int64
+	Path   string
+	URI    string
+	Text   string
+}
+
+func (e StartQueryPathInfoEvent) Action() ActionType {
+	return ActionTypeStart
+}
+
+// StartPostBuildHookEvent
+type StartPostBuildHookEvent struct {
+	ID     int64
+	Parent int64
+	Path   string
+	Text   string
+}
+
+func (e StartPostBuildHookEvent) Action() ActionType {
+	return ActionTypeStart
+}
+
+// StartBuildWaitingEvent is started when a build is waiting,
+// e.g. for a lock or a remote builder.
+type StartBuildWaitingEvent struct {
+	ID     int64
+	Parent int64
+	Text   string
+}
+
+func (e StartBuildWaitingEvent) Action() ActionType {
+	return ActionTypeStart
+}
+
+// StartFetchTreeEvent
+type StartFetchTreeEvent struct {
+	ID     int64
+	Parent int64
+	Text   string
+}
+
+func (e StartFetchTreeEvent) Action() ActionType {
+	return ActionTypeStart
+}
+
 // ResultProgressEvent
 type ResultProgressEvent struct {
 	ID       int64
@@ -148,6 +219,70 @@ func (e ResultBuildLogLineEvent) Action() ActionType {
 	return ActionTypeResult
 }
 
+// ResultFileLinkedEvent is reported when store optimisation
+// hard links a file.
+type ResultFileLinkedEvent struct {
+	ID     int64
+	Bytes  int64
+	Blocks int64
+}
+
+func (e ResultFileLinkedEvent) Action() ActionType {
+	return ActionTypeResult
+}
+
+// ResultUntrustedPathEvent
+type ResultUntrustedPathEvent struct {
+	ID   int64
+	Path string
+}
+
+func (e ResultUntrustedPathEvent) Action() ActionType {
+	return ActionTypeResult
+}
+
+// ResultCorruptedPathEvent
+type ResultCorruptedPathEvent struct {
+	ID   int64
+	Path string
+}
+
+func (e ResultCorruptedPathEvent) Action() ActionType {
+	return ActionTypeResult
+}
+
+// ResultSetExpectedEvent sets the expected number of child
+// activities of a type, or bytes for file transfers.
+type ResultSetExpectedEvent struct {
+	ID           int64
+	ActivityType ActivityType
+	Expected     int64
+}
+
+func (e ResultSetExpectedEvent) Action() ActionType {
+	return ActionTypeResult
+}
+
+// ResultPostBuildLogLineEvent
+type ResultPostBuildLogLineEvent struct {
+	ID   int64
+	Text string
+}
+
+func (e ResultPostBuildLogLineEvent) Action() ActionType {
+	return ActionTypeResult
+}
+
+// ResultFetchStatusEvent
+type ResultFetchStatusEvent struct {
+	ID   int64
+	Text string
+}
+
+func (e ResultFetchStatusEvent) Action() ActionType {
+	return ActionTypeResult
+}
+
 // StopEvent
 type StopEvent struct {
 	ID int64
@@ -260,6 +395,16 @@ func decodeRawStartEvent(val *fastjson.Value) Event {
 		return decodeRawStartBuildEvent(val)
 	case protoEventTypeFileTransfer:
 		return decodeRawStartFileTransferEvent(val)
+	case protoEventTypeSubstitute:
+		return decodeRawStartSubstituteEvent(val)
+	case protoEventTypeQueryPathInfo:
+		return decodeRawStartQueryPathInfoEvent(val)
+	case protoEventTypePostBuildHook:
+		return decodeRawStartPostBuildHookEvent(val)
+	case protoEventTypeBuildWaiting:
+		return decodeRawStartBuildWaitingEvent(val)
+	case protoEventTypeFetchTree:
+		return decodeRawStartFetchTreeEvent(val)
 	}
 
 	return nil
@@ -413,6 +558,126 @@ func decodeRawStartFileTransferEvent(val *fastjson.Value) Event {
 	}
 }
 
+// Decodes a start event whose fields are a store path and a substituter URI.
+func decodeRawStartPathURIFields(val *fastjson.Value) (path, uri string, ok bool) {
+	// Parse fields
+	fields := val.GetArray("fields")
+	if len(fields) < 2 {
+		return "", "", false
+	}
+
+	// Get path
+	p := fields[0].GetStringBytes()
+	if p == nil {
+		return "", "", false
+	}
+
+	// Get URI
+	u := fields[1].GetStringBytes()
+	if u == nil {
+		return "", "", false
+	}
+
+	return string(p), string(u), true
+}
+
+func decodeRawStartSubstituteEvent(val *fastjson.Value) Event {
+	id := val.GetInt64("id")
+	// If ID is 0, we just ignore the event
+	if id < 1 {
+		return nil
+	}
+
+	p, uri, ok := decodeRawStartPathURIFields(val)
+	if !ok {
+		return nil
+	}
+
+	return StartSubstituteEvent{
+		ID:     id,
+		Parent: val.GetInt64("parent"),
+		Path:   p,
+		URI:    uri,
+		Text:   string(val.GetStringBytes("text")),
+	}
+}
+
+func decodeRawStartQueryPathInfoEvent(val *fastjson.Value) Event {
+	id := val.GetInt64("id")
+	// If ID is 0, we just ignore the event
+	if id < 1 {
+		return nil
+	}
+
+	p, uri, ok := decodeRawStartPathURIFields(val)
+	if !ok {
+		return nil
+	}
+
+	return StartQueryPathInfoEvent{
+		ID:     id,
+		Parent: val.GetInt64("parent"),
+		Path:   p,
+		URI:    uri,
+		Text:   string(val.GetStringBytes("text")),
+	}
+}
+
+func decodeRawStartPostBuildHookEvent(val *fastjson.Value) Event {
+	id := val.GetInt64("id")
+	// If ID is 0, we just ignore the event
+	if id < 1 {
+		return nil
+	}
+
+	// Parse fields
+	fields := val.GetArray("fields")
+	if len(fields) < 1 {
+		return nil
+	}
+
+	// Get derivation path
+	p := fields[0].GetStringBytes()
+	if p == nil {
+		return nil
+	}
+
+	return StartPostBuildHookEvent{
+		ID:     id,
+		Parent: val.GetInt64("parent"),
+		Path:   string(p),
+		Text:   string(val.GetStringBytes("text")),
+	}
+}
+
+func decodeRawStartBuildWaitingEvent(val *fastjson.Value) Event {
+	id := val.GetInt64("id")
+	// If ID is 0, we just ignore the event
+	if id < 1 {
+		return nil
+	}
+
+	return StartBuildWaitingEvent{
+		ID:     id,
+		Parent: val.GetInt64("parent"),
+		Text:   string(val.GetStringBytes("text")),
+	}
+}
+
+func decodeRawStartFetchTreeEvent(val *fastjson.Value) Event {
+	id := val.GetInt64("id")
+	// If ID is 0, we just ignore the event
+	if id < 1 {
+		return nil
+	}
+
+	return StartFetchTreeEvent{
+		ID:     id,
+		Parent: val.GetInt64("parent"),
+		Text:   string(val.GetStringBytes("text")),
+	}
+}
+
 func decodeRawResultEvent(val *fastjson.Value) Event {
 	switch val.GetInt("type") {
 	case protoResultTypeProgress:
@@ -421,6 +686,18 @@ func decodeRawResultEvent(val *fastjson.Value) Event {
 		return decodeRawResultSetPhaseEvent(val)
 	case protoResultTypeBuildLogLine:
 		return decodeRawResultBuildLogLineEvent(val)
+	case protoResultTypeFileLinked:
+		return decodeRawResultFileLinkedEvent(val)
+	case protoResultTypeUntrustedPath:
+		return decodeRawResultUntrustedPathEvent(val)
+	case protoResultTypeCorruptedPath:
+		return decodeRawResultCorruptedPathEvent(val)
+	case protoResultTypeSetExpected:
+		return decodeRawResultSetExpectedEvent(val)
+	case protoResultTypePostBuildLogLine:
+		return decodeRawResultPostBuildLogLineEvent(val)
+	case protoResultTypeFetchStatus:
+		return decodeRawResultFetchStatusEvent(val)
 	}
 
 	return nil
@@ -500,3 +777,98 @@ func decodeRawResultBuildLogLineEvent(val *fastjson.Value) Event {
 
 	return ResultBuildLogLineEvent{id, string(text)}
 }
+
+// Decodes a result event whose only field is a string.
+func decodeRawResultStringField(val *fastjson.Value) (int64, string, bool) {
+	id := val.GetInt64("id")
+	// If ID is 0, we just ignore the event
+	if id < 1 {
+		return 0, "", false
+	}
+
+	// Parse fields
+	fields := val.GetArray("fields")
+	if len(fields) < 1 {
+		return 0, "", false
+	}
+
+	// Parse string
+	str := fields[0].GetStringBytes()
+	if str == nil {
+		return 0, "", false
+	}
+
+	return id, string(str), true
+}
+
+func decodeRawResultFileLinkedEvent(val *fastjson.Value) Event {
+	id := val.GetInt64("id")
+	// If ID is 0, we just ignore the event
+	if id < 1 {
+		return nil
+	}
+
+	// Parse fields
+	fields := val.GetArray("fields")
+	if len(fields) < 2 {
+		return nil
+	}
+
+	return ResultFileLinkedEvent{id, fields[0].GetInt64(), fields[1].GetInt64()}
+}
+
+func decodeRawResultUntrustedPathEvent(val *fastjson.Value) Event {
+	id, p, ok := decodeRawResultStringField(val)
+	if !ok {
+		return nil
+	}
+
+	return ResultUntrustedPathEvent{id, p}
+}
+
+func decodeRawResultCorruptedPathEvent(val *fastjson.Value) Event {
+	id, p, ok := decodeRawResultStringField(val)
+	if !ok {
+		return nil
+	}
+
+	return ResultCorruptedPathEvent{id, p}
+}
+
+func decodeRawResultSetExpectedEvent(val *fastjson.Value) Event {
+	id := val.GetInt64("id")
+	// If ID is 0, we just ignore the event
+	if id < 1 {
+		return nil
+	}
+
+	// Parse fields
+	fields := val.GetArray("fields")
+	if len(fields) < 2 {
+		return nil
+	}
+
+	return ResultSetExpectedEvent{
+		ID:           id,
+		ActivityType: ActivityType(fields[0].GetInt()),
+		Expected:     fields[1].GetInt64(),
+	}
+}
+
+func decodeRawResultPostBuildLogLineEvent(val *fastjson.Value) Event {
+	id, text, ok := decodeRawResultStringField(val)
+	if !ok {
+		return nil
+	}
+
+	return ResultPostBuildLogLineEvent{id, text}
+}
+
+func decodeRawResultFetchStatusEvent(val *fastjson.Value) Event {
+	id, text, ok := decodeRawResultStringField(val)
+	if !ok {
+		return nil
+	}
+
+	return ResultFetchStatusEvent{id, text}
+}
@@ -0,0 +1,107 @@
+package nix
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/go-test/deep"
+)
+
+func TestProgressDecoder(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		out  Event
+	}{
+		{
+			name: "substitute",
+			in:   `@nix {"action":"start","id":2,"level":4,"parent":1,"text":"copying '/nix/store/2467crcbg119q5jb5nwqxm0c87ls3wnv-hello-2.12' from 'https://cache.nixos.org'","type":108,"fields":["/nix/store/2467crcbg119q5jb5nwqxm0c87ls3wnv-hello-2.12","https://cache.nixos.org"]}`,
+			out: StartSubstituteEvent{
+				ID:     2,
+				Parent: 1,
+				Path:   "/nix/store/2467crcbg119q5jb5nwqxm0c87ls3wnv-hello-2.12",
+				URI:    "https://cache.nixos.org",
+				Text:   "copying '/nix/store/2467crcbg119q5jb5nwqxm0c87ls3wnv-hello-2.12' from 'https://cache.nixos.org'",
+			},
+		},
+		{
+			name: "query path info",
+			in:   `@nix {"action":"start","id":3,"level":4,"parent":0,"text":"querying info about '/nix/store/2467crcbg119q5jb5nwqxm0c87ls3wnv-hello-2.12' on 'https://cache.nixos.org'","type":109,"fields":["/nix/store/2467crcbg119q5jb5nwqxm0c87ls3wnv-hello-2.12","https://cache.nixos.org"]}`,
+			out: StartQueryPathInfoEvent{
+				ID:   3,
+				Path: "/nix/store/2467crcbg119q5jb5nwqxm0c87ls3wnv-hello-2.12",
+				URI:  "https://cache.nixos.org",
+				Text: "querying info about '/nix/store/2467crcbg119q5jb5nwqxm0c87ls3wnv-hello-2.12' on 'https://cache.nixos.org'",
+			},
+		},
+		{
+			name: "post-build hook",
+			in:   `@nix {"action":"start","id":4,"level":3,"parent":0,"text":"running post-build-hook","type":110,"fields":["/nix/store/2467crcbg119q5jb5nwqxm0c87ls3wnv-hello-2.12.drv"]}`,
+			out: StartPostBuildHookEvent{
+				ID:   4,
+				Path: "/nix/store/2467crcbg119q5jb5nwqxm0c87ls3wnv-hello-2.12.drv",
+				Text: "running post-build-hook",
+			},
+		},
+		{
+			name: "build waiting",
+			in:   `@nix {"action":"start","id":5,"level":1,"parent":0,"text":"waiting for lock on '/nix/store/2467crcbg119q5jb5nwqxm0c87ls3wnv-hello-2.12'","type":111,"fields":[]}`,
+			out: StartBuildWaitingEvent{
+				ID:   5,
+				Text: "waiting for lock on '/nix/store/2467crcbg119q5jb5nwqxm0c87ls3wnv-hello-2.12'",
+			},
+		},
+		{
+			name: "fetch tree",
+			in:   `@nix {"action":"start","id":6,"level":3,"parent":0,"text":"fetching git input 'git+https://github.com/arnarg/nilla'","type":112}`,
+			out: StartFetchTreeEvent{
+				ID:   6,
+				Text: "fetching git input 'git+https://github.com/arnarg/nilla'",
+			},
+		},
+		{
+			name: "file linked",
+			in:   `@nix {"action":"result","id":7,"type":100,"fields":[4096,8]}`,
+			out:  ResultFileLinkedEvent{7, 4096, 8},
+		},
+		{
+			name: "untrusted path",
+			in:   `@nix {"action":"result","id":8,"type":102,"fields":["/nix/store/2467crcbg119q5jb5nwqxm0c87ls3wnv-hello-2.12"]}`,
+			out:  ResultUntrustedPathEvent{8, "/nix/store/2467crcbg119q5jb5nwqxm0c87ls3wnv-hello-2.12"},
+		},
+		{
+			name: "corrupted path",
+			in:   `@nix {"action":"result","id":9,"type":103,"fields":["/nix/store/2467crcbg119q5jb5nwqxm0c87ls3wnv-hello-2.12"]}`,
+			out:  ResultCorruptedPathEvent{9, "/nix/store/2467crcbg119q5jb5nwqxm0c87ls3wnv-hello-2.12"},
+		},
+		{
+			name: "set expected",
+			in:   `@nix {"action":"result","id":10,"type":106,"fields":[105,12]}`,
+			out:  ResultSetExpectedEvent{ID: 10, ActivityType: ActivityTypeBuild, Expected: 12},
+		},
+		{
+			name: "post-build log line",
+			in:   `@nix {"action":"result","id":4,"type":107,"fields":["uploading to cache"]}`,
+			out:  ResultPostBuildLogLineEvent{4, "uploading to cache"},
+		},
+		{
+			name: "fetch status",
+			in:   `@nix {"action":"result","id":6,"type":108,"fields":["Receiving objects: 50%"]}`,
+			out:  ResultFetchStatusEvent{6, "Receiving objects: 50%"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events := slices.Collect(NewProgressDecoder(strings.NewReader(tt.in)).Events)
+			if len(events) != 1 {
+				t.Fatalf("unexpected number of events: %d", len(events))
+			}
+
+			if diff := deep.Equal(events[0], tt.out); diff != nil {
+				t.Error(diff)
+			}
+		})
+	}
+}
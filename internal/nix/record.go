@@ -0,0 +1,141 @@
+package nix
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	recordMu      sync.Mutex
+	recordPath    string
+	recordStreams int
+)
+
+// SetRecordProgress makes every command run with a progress reporter write
+// its raw progress stream to path, and its timing to path with a ".timing"
+// suffix. When more than one command is run, the streams after the first one
+// are written to path with a ".N" suffix.
+func SetRecordProgress(path string) {
+	recordMu.Lock()
+	defer recordMu.Unlock()
+	recordPath = path
+	recordStreams = 0
+}
+
+// Returns the path to record the next progress stream to,
+// or an empty string if progress is not being recorded.
+func nextRecordPath() string {
+	recordMu.Lock()
+	defer recordMu.Unlock()
+
+	if recordPath == "" {
+		return ""
+	}
+
+	recordStreams++
+	if recordStreams == 1 {
+		return recordPath
+	}
+	return fmt.Sprintf("%s.%d", recordPath, recordStreams)
+}
+
+// Suffix of the file next to a recording holding the time every line
+// was read, in milliseconds since the recording started.
+const TimingSuffix = ".timing"
+
+// progressRecorder writes the stream read through it unchanged to stream,
+// and the time every line was read to timing.
+type progressRecorder struct {
+	r       io.Reader
+	stream  io.Writer
+	timing  io.Writer
+	start   time.Time
+	partial bool
+}
+
+// RecordProgress returns a reader that reads from r and records the raw stream
+// to stream and its timing to timing, so that it can later be replayed with
+// ReplayProgress. Closing the reader records the timing of a trailing line
+// without a newline.
+func RecordProgress(r io.Reader, stream, timing io.Writer) io.ReadCloser {
+	return &progressRecorder{r: r, stream: stream, timing: timing, start: time.Now()}
+}
+
+func (r *progressRecorder) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		// Recording is only for debugging, write errors
+		// should never interrupt the command
+		r.stream.Write(p[:n])
+
+		for range bytes.Count(p[:n], []byte{'\n'}) {
+			r.mark()
+		}
+		r.partial = p[n-1] != '\n'
+	}
+
+	return n, err
+}
+
+func (r *progressRecorder) Close() error {
+	if r.partial {
+		r.mark()
+		r.partial = false
+	}
+	return nil
+}
+
+func (r *progressRecorder) mark() {
+	fmt.Fprintf(r.timing, "%d\n", time.Since(r.start).Milliseconds())
+}
+
+// ReplayProgress returns a reader producing the progress stream recorded in r.
+// Lines are produced with the timing recorded in timing, sped up by a factor
+// of speed. When speed is 0, or timing is nil, all lines are produced without
+// delay.
+func ReplayProgress(ctx context.Context, r io.Reader, timing io.Reader, speed float64) io.Reader {
+	pr, pw := io.Pipe()
+
+	go func() {
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(nil, 1024*1024)
+
+		var offsets *bufio.Scanner
+		if timing != nil && speed > 0 {
+			offsets = bufio.NewScanner(timing)
+		}
+
+		var prev int64
+		for scanner.Scan() {
+			// Wait until the line was originally produced
+			if offsets != nil && offsets.Scan() {
+				ms, err := strconv.ParseInt(strings.TrimSpace(offsets.Text()), 10, 64)
+				if err == nil && ms > prev {
+					delay := time.Duration(float64(ms-prev) * float64(time.Millisecond) / speed)
+					select {
+					case <-time.After(delay):
+					case <-ctx.Done():
+						pw.CloseWithError(ctx.Err())
+						return
+					}
+					prev = ms
+				}
+			}
+
+			if _, err := pw.Write(append(scanner.Bytes(), '\n')); err != nil {
+				return
+			}
+		}
+
+		pw.CloseWithError(scanner.Err())
+	}()
+
+	return pr
+}
@@ -0,0 +1,98 @@
+package nix
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+func TestRecordReplay(t *testing.T) {
+	// The last line has no trailing newline
+	in := "@nix {\"action\":\"start\",\"id\":2,\"type\":104}\n" +
+		"@nix {\"action\":\"stop\",\"id\":2}"
+
+	// Record in small chunks to make sure lines are counted
+	stream, timing := &bytes.Buffer{}, &bytes.Buffer{}
+	r := RecordProgress(&chunkReader{strings.NewReader(in), 7}, stream, timing)
+	if _, err := io.ReadAll(r); err != nil {
+		t.Fatal(err)
+	}
+	r.Close()
+
+	// The stream is recorded unchanged
+	if stream.String() != in {
+		t.Errorf("unexpected recording: \"%s\" != \"%s\"", stream.String(), in)
+	}
+	if lines := strings.Count(timing.String(), "\n"); lines != 2 {
+		t.Fatalf("unexpected number of timed lines: %d", lines)
+	}
+
+	out, err := io.ReadAll(ReplayProgress(context.Background(), stream, timing, 1000))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(out) != in+"\n" {
+		t.Errorf("unexpected replay: \"%s\" != \"%s\"", out, in)
+	}
+}
+
+type chunkReader struct {
+	r io.Reader
+	n int
+}
+
+func (c *chunkReader) Read(p []byte) (int, error) {
+	if len(p) > c.n {
+		p = p[:c.n]
+	}
+	return c.r.Read(p)
+}
+
+func TestProgressDecoderGolden(t *testing.T) {
+	fixtures, err := filepath.Glob("testdata/*.progress")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, fixture := range fixtures {
+		t.Run(filepath.Base(fixture), func(t *testing.T) {
+			f, err := os.Open(fixture)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+
+			decoder := NewProgressDecoder(ReplayProgress(context.Background(), f, nil, 0))
+
+			out := &strings.Builder{}
+			for ev := range decoder.Events {
+				fmt.Fprintf(out, "%#v\n", ev)
+			}
+
+			golden := strings.TrimSuffix(fixture, ".progress") + ".golden"
+			if *update {
+				if err := os.WriteFile(golden, []byte(out.String()), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			expected, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if out.String() != string(expected) {
+				t.Errorf("decoded events do not match %s:\n%s", golden, out.String())
+			}
+		})
+	}
+}
@@ -2,12 +2,16 @@ package nix
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
+	"os"
 	"os/exec"
 	"strconv"
 	"strings"
+
+	"github.com/arnarg/nilla-utils/internal/cache"
+	"github.com/arnarg/nilla-utils/internal/store"
 )
 
 type FixedOutputStoreEntry struct {
@@ -22,7 +26,7 @@ func AddPathToStore(path string) (*FixedOutputStoreEntry, error) {
 	).Output()
 	if err != nil {
 		if xerr, ok := err.(*exec.ExitError); ok {
-			return nil, errors.New(string(xerr.Stderr))
+			return nil, ParseError(string(xerr.Stderr))
 		}
 		return nil, err
 	}
@@ -54,13 +58,13 @@ func AddGitPathToStore(path string) (*FixedOutputStoreEntry, error) {
 	// Evaluate code
 	eval, err := exec.Command(
 		"nix", "eval",
-		"--extra-experimental-features", "nix-command",
+		"--extra-experimental-features", experimentalFeatures,
 		"--raw", "--impure",
 		"--expr", code,
 	).Output()
 	if err != nil {
 		if xerr, ok := err.(*exec.ExitError); ok {
-			return nil, errors.New(string(xerr.Stderr))
+			return nil, ParseError(string(xerr.Stderr))
 		}
 		return nil, err
 	}
@@ -81,12 +85,26 @@ func AddGitPathToStore(path string) (*FixedOutputStoreEntry, error) {
 }
 
 func GetStoreHash(path string) ([]byte, error) {
+	// Prefer querying the nix daemon directly
+	if client, err := store.ConnectLocal(); err == nil {
+		info, err := client.QueryPathInfo(path)
+		if err != nil {
+			return nil, err
+		}
+		if info == nil {
+			return nil, fmt.Errorf("path '%s' is not valid", path)
+		}
+
+		_, hash, _ := strings.Cut(info.NarHash, ":")
+		return []byte(hash), nil
+	}
+
 	out, err := exec.Command(
 		"nix-store", "--query", path, "--hash",
 	).Output()
 	if err != nil {
 		if xerr, ok := err.(*exec.ExitError); ok {
-			return nil, errors.New(string(xerr.Stderr))
+			return nil, ParseError(string(xerr.Stderr))
 		}
 		return nil, err
 	}
@@ -111,6 +129,12 @@ func GetStorePathName(path string) (string, error) {
 }
 
 func ListAttrsInProject(file string, entry *FixedOutputStoreEntry, attr string) ([]string, error) {
+	// The store hash fully identifies the project, so results can be reused
+	key := cache.Key("list", entry.Hash, file, attr)
+	if names := []string{}; cache.Get(key, &names) {
+		return names, nil
+	}
+
 	storePathName, err := GetStorePathName(entry.Path)
 	if err != nil {
 		return nil, err
@@ -133,12 +157,12 @@ func ListAttrsInProject(file string, entry *FixedOutputStoreEntry, attr string)
 	// Execute code
 	eval, err := exec.Command(
 		"nix", "eval",
-		"--extra-experimental-features", "nix-command",
+		"--extra-experimental-features", experimentalFeatures,
 		"--json", "--expr", code,
 	).Output()
 	if err != nil {
 		if xerr, ok := err.(*exec.ExitError); ok {
-			return nil, errors.New(string(xerr.Stderr))
+			return nil, ParseError(string(xerr.Stderr))
 		}
 		return nil, err
 	}
@@ -149,10 +173,18 @@ func ListAttrsInProject(file string, entry *FixedOutputStoreEntry, attr string)
 		return nil, err
 	}
 
+	cache.Set(key, names)
+
 	return names, nil
 }
 
 func ExistsInProject(file string, entry *FixedOutputStoreEntry, name string) (bool, error) {
+	// The store hash fully identifies the project, so results can be reused
+	key := cache.Key("exists", entry.Hash, file, name)
+	if exists := false; cache.Get(key, &exists) {
+		return exists, nil
+	}
+
 	storePathName, err := GetStorePathName(entry.Path)
 	if err != nil {
 		return false, err
@@ -195,12 +227,12 @@ func ExistsInProject(file string, entry *FixedOutputStoreEntry, name string) (bo
 	// Execute code
 	eval, err := exec.Command(
 		"nix", "eval",
-		"--extra-experimental-features", "nix-command",
+		"--extra-experimental-features", experimentalFeatures,
 		"--json", "--expr", code,
 	).Output()
 	if err != nil {
 		if xerr, ok := err.(*exec.ExitError); ok {
-			return false, errors.New(string(xerr.Stderr))
+			return false, ParseError(string(xerr.Stderr))
 		}
 		return false, err
 	}
@@ -210,54 +242,154 @@ func ExistsInProject(file string, entry *FixedOutputStoreEntry, name string) (bo
 		return false, nil
 	}
 
+	cache.Set(key, val)
+
 	return val, nil
 }
 
-type FetchGitOptions struct {
-	Revision   string `json:"rev,omitempty"`
-	Reference  string `json:"ref,omitempty"`
-	Submodules bool   `json:"submodules"`
+// EvalInProject evaluates an attribute in the project and decodes its JSON
+// representation into v. If the attribute does not exist v is decoded from null.
+func EvalInProject(file string, entry *FixedOutputStoreEntry, attr string, v any) error {
+	return EvalAppliedInProject(file, entry, attr, "x: x", v)
 }
 
-func FetchGit(url string, opts *FetchGitOptions) (*FixedOutputStoreEntry, error) {
-	// Serialize options to json
-	buf, err := json.Marshal(opts)
+// EvalAppliedInProject evaluates an attribute in the project, applies the nix
+// function `apply` to it and decodes the JSON representation of the result into v.
+// If the attribute does not exist, null is passed to `apply`.
+func EvalAppliedInProject(file string, entry *FixedOutputStoreEntry, attr, apply string, v any) error {
+	// The store hash fully identifies the project, so results can be reused
+	key := cache.Key("eval", entry.Hash, file, attr, apply)
+	if cache.Get(key, v) {
+		return nil
+	}
+
+	storePathName, err := GetStorePathName(entry.Path)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	// Template code for fetching git repo
+	// Create nix code that evaluates the attribute
 	code := fmt.Sprintf(
 		`
 			let
-				info = builtins.fromJSON ''%s'';
+				source = builtins.path {path = "%s"; sha256 = "%s"; name = "%s";};
+				project = import "${source}/%s";
 			in
-				builtins.fetchGit (
-					{ url = "%s"; }
-					// (if info ? rev && info.rev != null then { inherit (info) rev; } else {})
-					// (if info ? ref && info.ref != null then { inherit (info) ref; } else {})
-					// (
-						if info ? submodules && info.submodules != null
-						then { inherit (info) submodules; } else {}
-					)
-				)
+				(%s) (project.%s or null)
 		`,
-		buf, url,
+		entry.Path, entry.Hash, storePathName,
+		file,
+		apply, attr,
 	)
 
 	// Execute code
 	eval, err := exec.Command(
 		"nix", "eval",
-		"--extra-experimental-features", "nix-command",
-		"--raw", "--impure", "--expr", code,
+		"--extra-experimental-features", experimentalFeatures,
+		"--json", "--expr", code,
 	).Output()
 	if err != nil {
 		if xerr, ok := err.(*exec.ExitError); ok {
-			return nil, errors.New(string(xerr.Stderr))
+			return ParseError(string(xerr.Stderr))
+		}
+		return err
+	}
+
+	// Parse json
+	if err := json.Unmarshal(eval, v); err != nil {
+		return err
+	}
+
+	cache.Set(key, json.RawMessage(eval))
+
+	return nil
+}
+
+type FetchGitOptions struct {
+	Revision   string `json:"rev,omitempty"`
+	Reference  string `json:"ref,omitempty"`
+	Submodules bool   `json:"submodules"`
+}
+
+// Fetcher expressions read their arguments as JSON from this environment
+// variable, so that a URL can't escape the expression.
+const fetchArgsEnv = "NILLA_UTILS_FETCH_ARGS"
+
+// Code for fetching a git repository.
+const fetchGitCode = `
+	let
+		info = builtins.fromJSON (builtins.getEnv "` + fetchArgsEnv + `");
+	in
+		builtins.fetchGit (
+			{ inherit (info) url; }
+			// (if info ? rev && info.rev != null then { inherit (info) rev; } else {})
+			// (if info ? ref && info.ref != null then { inherit (info) ref; } else {})
+			// (
+				if info ? submodules && info.submodules != null
+				then { inherit (info) submodules; } else {}
+			)
+		)
+`
+
+type fetchGitArgs struct {
+	URL string `json:"url"`
+	*FetchGitOptions
+}
+
+func FetchGit(url string, opts *FetchGitOptions) (*FixedOutputStoreEntry, error) {
+	return fetchToStore(fetchGitCode, &fetchGitArgs{url, opts})
+}
+
+// Code for fetching a tarball.
+const fetchTarballCode = `
+	let
+		info = builtins.fromJSON (builtins.getEnv "` + fetchArgsEnv + `");
+	in
+		builtins.fetchTarball { inherit (info) url; }
+`
+
+type fetchTarballArgs struct {
+	URL string `json:"url"`
+}
+
+func FetchTarball(url string) (*FixedOutputStoreEntry, error) {
+	return fetchToStore(fetchTarballCode, &fetchTarballArgs{url})
+}
+
+// evalWithArgs evaluates a fetcher expression to a string, passing
+// the arguments to it as JSON.
+func evalWithArgs(code string, args any) ([]byte, error) {
+	buf, err := json.Marshal(args)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := exec.Command(
+		"nix", "eval",
+		"--extra-experimental-features", experimentalFeatures,
+		"--raw", "--impure", "--expr", code,
+	)
+	cmd.Env = append(os.Environ(), fmt.Sprintf("%s=%s", fetchArgsEnv, buf))
+
+	eval, err := cmd.Output()
+	if err != nil {
+		if xerr, ok := err.(*exec.ExitError); ok {
+			return nil, ParseError(string(xerr.Stderr))
 		}
 		return nil, err
 	}
 
+	return eval, nil
+}
+
+// fetchToStore evaluates a fetcher expression with the arguments and
+// returns the resulting store entry.
+func fetchToStore(code string, args any) (*FixedOutputStoreEntry, error) {
+	eval, err := evalWithArgs(code, args)
+	if err != nil {
+		return nil, err
+	}
+
 	// Trim output
 	storePath := strings.TrimSpace(string(eval))
 
@@ -272,3 +404,39 @@ func FetchGit(url string, opts *FetchGitOptions) (*FixedOutputStoreEntry, error)
 		Hash: strings.TrimSpace(string(hash)),
 	}, nil
 }
+
+// EvalDrvPath evaluates the derivation path of an attribute in a nix file.
+func EvalDrvPath(ctx context.Context, file, attr string) (string, error) {
+	eval, err := exec.CommandContext(
+		ctx,
+		"nix", "eval",
+		"--extra-experimental-features", experimentalFeatures,
+		"--raw", "-f", file, fmt.Sprintf("%s.drvPath", attr),
+	).Output()
+	if err != nil {
+		if xerr, ok := err.(*exec.ExitError); ok {
+			return "", ParseError(string(xerr.Stderr))
+		}
+		return "", err
+	}
+
+	return strings.TrimSpace(string(eval)), nil
+}
+
+// Realise builds all outputs of a derivation without creating any links to them.
+func Realise(ctx context.Context, drv string) ([]string, error) {
+	out, err := exec.CommandContext(
+		ctx,
+		"nix", "build",
+		"--extra-experimental-features", experimentalFeatures,
+		"--no-link", "--print-out-paths", fmt.Sprintf("%s^*", drv),
+	).Output()
+	if err != nil {
+		if xerr, ok := err.(*exec.ExitError); ok {
+			return nil, ParseError(string(xerr.Stderr))
+		}
+		return nil, err
+	}
+
+	return strings.Fields(string(out)), nil
+}
@@ -1,6 +1,9 @@
 package nix
 
-import "testing"
+import (
+	"os/exec"
+	"testing"
+)
 
 func TestGetStorePathName(t *testing.T) {
 	tests := []struct {
@@ -33,3 +36,30 @@ func TestGetStorePathName(t *testing.T) {
 		})
 	}
 }
+
+func TestEvalWithArgs(t *testing.T) {
+	if _, err := exec.LookPath("nix"); err != nil {
+		t.Skip("nix is not installed")
+	}
+
+	// Tries to break out of a string literal in the nix expression
+	url := `https://example.com/a.tar.gz"; x = builtins.readFile /etc/shadow; y = "${builtins.currentTime}''`
+	ref := `main''${builtins.currentTime}`
+
+	// Evaluates the arguments like the fetchers read them
+	code := `
+		let
+			info = builtins.fromJSON (builtins.getEnv "` + fetchArgsEnv + `");
+		in
+			"${info.url} ${info.ref}"
+	`
+
+	out, err := evalWithArgs(code, &fetchGitArgs{url, &FetchGitOptions{Reference: ref}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expected := url + " " + ref; string(out) != expected {
+		t.Errorf("unexpected result: \"%s\" != \"%s\"", out, expected)
+	}
+}
@@ -0,0 +1,182 @@
+package project
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/arnarg/nilla-utils/internal/nix"
+	"github.com/urfave/cli/v3"
+)
+
+// Elevation methods for running privileged commands on a target.
+const (
+	ElevationSudo = "sudo"
+	ElevationDoas = "doas"
+	ElevationNone = "none"
+)
+
+// Deployment holds the deployment settings of a system, as configured
+// in the `deployment` option of a system in the nilla project.
+type Deployment struct {
+	Target    string   `json:"target"`
+	BuildHost string   `json:"buildHost"`
+	Elevation string   `json:"elevation"`
+	Tags      []string `json:"tags"`
+	Confirm   bool     `json:"confirm"`
+	// Defaults of command line flags, by flag name.
+	Flags map[string]any `json:"flags"`
+}
+
+// deploymentFlags are the command line flags that can get a default in
+// `deployment.flags`, by kind of system, with whether they are boolean.
+// Flags that are used before a system is selected, like `--project` or
+// `--refresh`, can't have a default per system.
+var deploymentFlags = map[string]map[string]bool{
+	"nixos": {
+		"no-link":  true,
+		"out-link": false,
+		"verbose":  true,
+	},
+	"home": {
+		"no-link":        true,
+		"out-link":       false,
+		"verbose":        true,
+		"specialisation": false,
+	},
+}
+
+// LoadDeployment evaluates the deployment settings of the system `name` in
+// `systems.<kind>`. Systems in projects using a version of nilla-utils without
+// the `deployment` option get the default settings.
+func (s *ProjectSource) LoadDeployment(kind, name string) (*Deployment, error) {
+	deployment := &Deployment{}
+
+	attr := fmt.Sprintf("systems.%s.\"%s\".deployment", kind, name)
+	if err := nix.EvalInProject(s.NillaPath, s.FixedOutputStoreEntry(), attr, deployment); err != nil {
+		return nil, err
+	}
+
+	if err := deployment.validate(kind, name); err != nil {
+		return nil, err
+	}
+
+	return deployment, nil
+}
+
+// validate sets defaults of the deployment settings of the system `name`
+// in `systems.<kind>` and checks that they are valid.
+func (d *Deployment) validate(kind, name string) error {
+	switch d.Elevation {
+	case "":
+		d.Elevation = ElevationSudo
+	case ElevationSudo, ElevationDoas, ElevationNone:
+	default:
+		return fmt.Errorf(
+			"Invalid deployment.elevation \"%s\" for system \"%s\", expected one of \"%s\", \"%s\" or \"%s\"",
+			d.Elevation, name, ElevationSudo, ElevationDoas, ElevationNone,
+		)
+	}
+
+	for flag, value := range d.Flags {
+		isBool, ok := deploymentFlags[kind][flag]
+		if !ok {
+			return fmt.Errorf("Unsupported flag \"%s\" in deployment.flags for system \"%s\"", flag, name)
+		}
+
+		if _, ok := value.(bool); isBool && !ok {
+			return fmt.Errorf("Flag \"%s\" in deployment.flags for system \"%s\" must be a boolean", flag, name)
+		}
+		if _, ok := value.(string); !isBool && !ok {
+			return fmt.Errorf("Flag \"%s\" in deployment.flags for system \"%s\" must be a string", flag, name)
+		}
+	}
+
+	return nil
+}
+
+// Bool returns the value of the boolean flag `name`. A flag passed on the
+// command line takes precedence over its default in `deployment.flags`.
+func (d *Deployment) Bool(cmd *cli.Command, name string) bool {
+	if value, ok := d.Flags[name].(bool); ok && !cmd.IsSet(name) {
+		return value
+	}
+	return cmd.Bool(name)
+}
+
+// String returns the value of the string flag `name`. A flag passed on the
+// command line takes precedence over its default in `deployment.flags`.
+func (d *Deployment) String(cmd *cli.Command, name string) string {
+	if value, ok := d.Flags[name].(string); ok && !cmd.IsSet(name) {
+		return value
+	}
+	return cmd.String(name)
+}
+
+// Elevate prefixes a command and its arguments with the configured
+// elevation method.
+func (d *Deployment) Elevate(args ...string) []string {
+	switch d.Elevation {
+	case ElevationNone:
+		return args
+	case ElevationDoas:
+		return append([]string{ElevationDoas}, args...)
+	default:
+		return append([]string{ElevationSudo}, args...)
+	}
+}
+
+// SelectSystems returns the names of all systems in `systems.<kind>` matching any
+// of the selectors. A selector is either the name of a system or a tag prefixed
+// with `@`, matching every system with that tag in `deployment.tags`.
+func (s *ProjectSource) SelectSystems(kind string, selectors []string) ([]string, error) {
+	attr := fmt.Sprintf("systems.%s", kind)
+
+	// Get a list of all systems
+	systems, err := nix.ListAttrsInProject(s.NillaPath, s.FixedOutputStoreEntry(), attr)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get tags of all systems, only if needed
+	tags := map[string][]string{}
+	if slices.ContainsFunc(selectors, isTagSelector) {
+		apply := "systems: builtins.mapAttrs (_: s: s.deployment.tags or []) (if systems == null then {} else systems)"
+		if err := nix.EvalAppliedInProject(s.NillaPath, s.FixedOutputStoreEntry(), attr, apply, &tags); err != nil {
+			return nil, err
+		}
+	}
+
+	selected := []string{}
+	for _, selector := range selectors {
+		matched := false
+
+		for _, system := range systems {
+			if isTagSelector(selector) {
+				if !slices.Contains(tags[system], strings.TrimPrefix(selector, "@")) {
+					continue
+				}
+			} else if system != selector {
+				continue
+			}
+
+			matched = true
+			if !slices.Contains(selected, system) {
+				selected = append(selected, system)
+			}
+		}
+
+		if !matched {
+			if isTagSelector(selector) {
+				return nil, fmt.Errorf("No systems tagged \"%s\" found", strings.TrimPrefix(selector, "@"))
+			}
+			return nil, fmt.Errorf("System \"%s\" not found", selector)
+		}
+	}
+
+	return selected, nil
+}
+
+func isTagSelector(selector string) bool {
+	return strings.HasPrefix(selector, "@")
+}
@@ -0,0 +1,164 @@
+package project
+
+import (
+	"context"
+	"testing"
+
+	"github.com/urfave/cli/v3"
+)
+
+func TestDeploymentValidate(t *testing.T) {
+	tests := []struct {
+		name      string
+		elevation string
+		out       string
+		err       bool
+	}{
+		{
+			name:      "default",
+			elevation: "",
+			out:       ElevationSudo,
+		},
+		{
+			name:      "doas",
+			elevation: "doas",
+			out:       ElevationDoas,
+		},
+		{
+			name:      "none",
+			elevation: "none",
+			out:       ElevationNone,
+		},
+		{
+			name:      "typo",
+			elevation: "suod",
+			err:       true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &Deployment{Elevation: tt.elevation}
+
+			err := d.validate("nixos", "laptop")
+			if tt.err {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if d.Elevation != tt.out {
+				t.Errorf("unexpected elevation: \"%s\" != \"%s\"", d.Elevation, tt.out)
+			}
+		})
+	}
+}
+
+func TestDeploymentValidateFlags(t *testing.T) {
+	tests := []struct {
+		name  string
+		kind  string
+		flags map[string]any
+		err   bool
+	}{
+		{
+			name:  "supported",
+			kind:  "nixos",
+			flags: map[string]any{"verbose": true, "out-link": "result-laptop"},
+		},
+		{
+			name:  "specialisation",
+			kind:  "home",
+			flags: map[string]any{"specialisation": "dark"},
+		},
+		{
+			name:  "unsupported kind",
+			kind:  "nixos",
+			flags: map[string]any{"specialisation": "dark"},
+			err:   true,
+		},
+		{
+			name:  "unsupported",
+			kind:  "nixos",
+			flags: map[string]any{"project": "./other"},
+			err:   true,
+		},
+		{
+			name:  "wrong type",
+			kind:  "nixos",
+			flags: map[string]any{"no-link": "yes"},
+			err:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &Deployment{Flags: tt.flags}
+
+			err := d.validate(tt.kind, "laptop")
+			if tt.err && err == nil {
+				t.Fatal("expected error")
+			}
+			if !tt.err && err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestDeploymentFlags(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		verbose bool
+		outLink string
+	}{
+		{
+			name:    "deployment defaults",
+			args:    []string{"nilla-os"},
+			verbose: true,
+			outLink: "result-laptop",
+		},
+		{
+			name:    "command line",
+			args:    []string{"nilla-os", "--verbose=false", "--out-link", "result-cli"},
+			verbose: false,
+			outLink: "result-cli",
+		},
+	}
+
+	d := &Deployment{Flags: map[string]any{"verbose": true, "out-link": "result-laptop"}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var verbose bool
+			var outLink string
+
+			cmd := &cli.Command{
+				Flags: []cli.Flag{
+					&cli.BoolFlag{Name: "verbose"},
+					&cli.StringFlag{Name: "out-link", Value: "result"},
+				},
+				Action: func(ctx context.Context, cmd *cli.Command) error {
+					verbose = d.Bool(cmd, "verbose")
+					outLink = d.String(cmd, "out-link")
+					return nil
+				},
+			}
+			if err := cmd.Run(context.Background(), tt.args); err != nil {
+				t.Fatal(err)
+			}
+
+			if verbose != tt.verbose {
+				t.Errorf("unexpected verbose: %t != %t", verbose, tt.verbose)
+			}
+			if outLink != tt.outLink {
+				t.Errorf("unexpected out-link: \"%s\" != \"%s\"", outLink, tt.outLink)
+			}
+		})
+	}
+}
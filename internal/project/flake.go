@@ -0,0 +1,116 @@
+package project
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"github.com/arnarg/nilla-utils/internal/nix"
+	"github.com/arnarg/nilla-utils/internal/store"
+	"github.com/charmbracelet/log"
+)
+
+// Files a project can be defined in, in order of preference.
+var projectFiles = []string{"nilla.nix", "flake.nix"}
+
+// Name of the store path containing the generated nilla.nix of a flake project.
+const flakeShimName = "nilla-flake"
+
+// flakeShimTemplate is a nilla.nix exposing the NixOS and Home Manager
+// configurations of a flake in the same attributes as a nilla project. This
+// maps `nixosConfigurations.<name>.config.system.build.toplevel` to
+// `systems.nixos.<name>.result.config.system.build.toplevel` and
+// `homeConfigurations.<name>.activationPackage` to
+// `systems.home.<name>.result.config.home.activationPackage`.
+const flakeShimTemplate = `let
+  flake = builtins.getFlake "%s";
+
+  # Home Manager configurations are only required to have an activation package
+  home = cfg: cfg // {
+    config = (cfg.config or { }) // {
+      home = (cfg.config.home or { }) // {
+        inherit (cfg) activationPackage;
+      };
+    };
+  };
+in
+{
+  systems.nixos = builtins.mapAttrs (_: result: { inherit result; }) (flake.nixosConfigurations or { });
+  systems.home = builtins.mapAttrs (_: cfg: { result = home cfg; }) (flake.homeConfigurations or { });
+}
+`
+
+// newSource creates a project source for the project file at path `file`
+// relative to the store entry.
+func newSource(entry *nix.FixedOutputStoreEntry, file string) (*ProjectSource, error) {
+	if filepath.Base(file) == "flake.nix" {
+		return newFlakeSource(entry, file)
+	}
+
+	return &ProjectSource{
+		NillaPath: file,
+		StorePath: entry.Path,
+		StoreHash: entry.Hash,
+	}, nil
+}
+
+// newFlakeSource creates a project source for a flake, which is loaded
+// through a generated nilla.nix added to the nix store next to it.
+func newFlakeSource(entry *nix.FixedOutputStoreEntry, file string) (*ProjectSource, error) {
+	ref, err := flakeRef(entry, filepath.Dir(file))
+	if err != nil {
+		return nil, err
+	}
+
+	log.Debugf("Loading flake \"%s\"", ref)
+
+	// Write nilla.nix to a directory with a fixed name,
+	// so that the store path only depends on the flake
+	tmp, err := os.MkdirTemp("", "nilla-utils-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmp)
+
+	dir := filepath.Join(tmp, flakeShimName)
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	shim := fmt.Sprintf(flakeShimTemplate, ref)
+	if err := os.WriteFile(filepath.Join(dir, "nilla.nix"), []byte(shim), 0o644); err != nil {
+		return nil, err
+	}
+
+	// Add generated nilla.nix to nix store
+	shimEntry, err := nix.AddPathToStore(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ProjectSource{
+		NillaPath: "./nilla.nix",
+		StorePath: shimEntry.Path,
+		StoreHash: shimEntry.Hash,
+		FlakePath: filepath.Clean(filepath.Join(entry.Path, file)),
+	}, nil
+}
+
+// flakeRef returns a flake reference to the flake in directory `dir` of the
+// store entry, locked to its NAR hash so that it can be used in pure evaluation.
+func flakeRef(entry *nix.FixedOutputStoreEntry, dir string) (string, error) {
+	hash, err := store.FromBase32(entry.Hash)
+	if err != nil {
+		return "", err
+	}
+
+	query := url.Values{}
+	if dir != "." && dir != "" {
+		query.Set("dir", dir)
+	}
+	query.Set("narHash", "sha256-"+base64.StdEncoding.EncodeToString(hash))
+
+	return fmt.Sprintf("path:%s?%s", entry.Path, query.Encode()), nil
+}
@@ -1,7 +1,10 @@
 package project
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"io/fs"
 	"net/url"
 	"os"
 	"os/exec"
@@ -10,6 +13,7 @@ import (
 	"strconv"
 	"strings"
 
+	"github.com/arnarg/nilla-utils/internal/cache"
 	"github.com/arnarg/nilla-utils/internal/nix"
 	"github.com/arnarg/nilla-utils/internal/util"
 	"github.com/charmbracelet/log"
@@ -20,11 +24,22 @@ type ProjectSource struct {
 	NillaPath string
 	StorePath string
 	StoreHash string
+	// Full path to the `flake.nix` file of a flake project, which is loaded
+	// through a generated `nilla.nix`.
+	FlakePath string `json:",omitempty"`
+}
+
+// IsFlake returns true if the project is a flake.
+func (s *ProjectSource) IsFlake() bool {
+	return s.FlakePath != ""
 }
 
 // FullProjectPath returns a full path to the directory containing the `nilla.nix`
-// file in the project.
+// or `flake.nix` file in the project.
 func (s *ProjectSource) FullProjectPath() string {
+	if s.IsFlake() {
+		return filepath.Dir(s.FlakePath)
+	}
 	return filepath.Dir(s.FullNillaPath())
 }
 
@@ -43,11 +58,15 @@ func (s *ProjectSource) FixedOutputStoreEntry() *nix.FixedOutputStoreEntry {
 }
 
 // Resolve takes in a project uri and tries to resolve it into a nix store path.
+// The project is defined in a `nilla.nix` or, if there is none, in a `flake.nix`.
 // Currently supported URIs:
 //   - Paths starting with `./`, `/` or `~`
 //   - URI starting with `path:`
 //   - URI starting with `github:` (example: `github:owner/repo`)
 //   - URI starting with `gitlab:` (example: `gitlab:owner/repo`)
+//   - URI starting with `sourcehut:` (example: `sourcehut:~owner/repo`)
+//   - URI starting with `git+https://`, `git+ssh://` or `git+file://`
+//   - URI starting with `https://` or `http://` pointing to a tarball
 func Resolve(uri string) (*ProjectSource, error) {
 	var source *ProjectSource
 
@@ -85,6 +104,30 @@ func Resolve(uri string) (*ProjectSource, error) {
 			return nil, err
 		}
 		source = resolved
+
+	// With `sourcehut:` prefix
+	case strings.HasPrefix(uri, "sourcehut:"):
+		resolved, err := ResolveSourcehut(uri)
+		if err != nil {
+			return nil, err
+		}
+		source = resolved
+
+	// With `git+` prefix
+	case strings.HasPrefix(uri, "git+"):
+		resolved, err := ResolveGit(uri)
+		if err != nil {
+			return nil, err
+		}
+		source = resolved
+
+	// Tarball over http(s)
+	case isTarball(uri):
+		resolved, err := ResolveTarball(uri)
+		if err != nil {
+			return nil, err
+		}
+		source = resolved
 	}
 
 	if source != nil {
@@ -97,8 +140,8 @@ func Resolve(uri string) (*ProjectSource, error) {
 
 // ResolvePath resolves a path based project uri and loads it into the nix store.
 func ResolvePath(path string) (*ProjectSource, error) {
-	// Strip nilla.nix suffix, if provided
-	if strings.HasSuffix(path, "nilla.nix") {
+	// Strip nilla.nix or flake.nix suffix, if provided
+	if strings.HasSuffix(path, "nilla.nix") || strings.HasSuffix(path, "flake.nix") {
 		path = filepath.Dir(path)
 	}
 
@@ -108,8 +151,8 @@ func ResolvePath(path string) (*ProjectSource, error) {
 		return nil, err
 	}
 
-	// Look up the directory tree for nilla.nix
-	resolved, err := searchUpForNillaNix(fullpath)
+	// Look up the directory tree for nilla.nix or flake.nix
+	resolved, file, err := searchUpForProject(fullpath)
 	if err != nil {
 		return nil, err
 	}
@@ -117,99 +160,255 @@ func ResolvePath(path string) (*ProjectSource, error) {
 	// Check if we're in a git repository
 	if root, err := searchUpForGitDir(resolved); err == nil {
 		log.Debugf("Found git repository root at %s", root)
-		return resolveGitPath(root, resolved)
+		return resolveGitPath(root, resolved, file)
 	}
 
 	log.Debugf("Found path %s", resolved)
 
+	// Check if this exact tree has been added before
+	key := ""
+	if sum, err := hashTree(resolved); err == nil {
+		key = cache.Key("project", "path", resolved, sum)
+		if source, ok := cachedSource(key); ok {
+			return source, nil
+		}
+	}
+
 	// Add resolved path to nix store
 	entry, err := nix.AddPathToStore(resolved)
 	if err != nil {
 		return nil, err
 	}
 
-	return &ProjectSource{
-		NillaPath: "./nilla.nix",
-		StorePath: entry.Path,
-		StoreHash: entry.Hash,
-	}, nil
+	source, err := newSource(entry, "./"+file)
+	if err != nil {
+		return nil, err
+	}
+
+	if key != "" {
+		cache.Set(key, source)
+	}
+
+	return source, nil
 }
 
 // ResolveGithub resolves a uri starting with `github:` and loads it into the nix store
 // from a github repository.
 func ResolveGithub(uri string) (*ProjectSource, error) {
-	// Parse as url
-	gitURL, err := url.Parse(fmt.Sprintf("github://%s", strings.TrimPrefix(uri, "github:")))
+	src, err := parseForgeURI(uri, "github:", "github.com")
 	if err != nil {
 		return nil, err
 	}
 
-	return resolveGenericGit(uri, gitURL, "github.com")
+	return resolveGitSource(src)
 }
 
 // ResolveGitlab resolves a uri starting with `gitlab:` and loads it into the nix store
 // form a gitlab repository.
 func ResolveGitlab(uri string) (*ProjectSource, error) {
-	// Parse as url
-	gitURL, err := url.Parse(fmt.Sprintf("gitlab://%s", strings.TrimPrefix(uri, "gitlab:")))
+	src, err := parseForgeURI(uri, "gitlab:", "gitlab.com")
 	if err != nil {
 		return nil, err
 	}
 
-	return resolveGenericGit(uri, gitURL, "gitlab.com")
+	return resolveGitSource(src)
 }
 
-func resolveGenericGit(uri string, gitURL *url.URL, defaultHost string) (*ProjectSource, error) {
+// ResolveSourcehut resolves a uri starting with `sourcehut:` and loads it into the nix
+// store from a sourcehut repository.
+func ResolveSourcehut(uri string) (*ProjectSource, error) {
+	src, err := parseForgeURI(uri, "sourcehut:", "git.sr.ht")
+	if err != nil {
+		return nil, err
+	}
+
+	return resolveGitSource(src)
+}
+
+// ResolveGit resolves a uri starting with `git+https://`, `git+ssh://` or `git+file://`
+// and loads it into the nix store from a generic git repository.
+func ResolveGit(uri string) (*ProjectSource, error) {
+	src, err := parseGitURI(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	return resolveGitSource(src)
+}
+
+// ResolveTarball resolves a uri pointing to a tarball over http(s) and loads it into the
+// nix store.
+func ResolveTarball(uri string) (*ProjectSource, error) {
+	tarURL, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	// Take dir out of the query as it's not part of the tarball url
+	query := tarURL.Query()
+	dir := query.Get("dir")
+	query.Del("dir")
+	tarURL.RawQuery = query.Encode()
+
+	// Fetch tarball
+	log.Debugf("Fetching \"%s\"", tarURL.String())
+	entry, err := nix.FetchTarball(tarURL.String())
+	if err != nil {
+		return nil, err
+	}
+
+	return newFetchedSource(entry, dir)
+}
+
+// gitSource is a git repository to be fetched with `builtins.fetchGit`.
+type gitSource struct {
+	url  string
+	dir  string
+	opts nix.FetchGitOptions
+}
+
+// parseForgeURI parses a uri like `github:owner/repo?ref=main` into a git source.
+// The transport used to fetch the repository can be set with the `transport` query
+// parameter as either `ssh` (default) or `https`.
+func parseForgeURI(uri, prefix, defaultHost string) (*gitSource, error) {
+	// Parse as url
+	scheme := strings.TrimSuffix(prefix, ":")
+	gitURL, err := url.Parse(fmt.Sprintf("%s://%s", scheme, strings.TrimPrefix(uri, prefix)))
+	if err != nil {
+		return nil, err
+	}
+
 	// Parse various fields from url
 	owner := gitURL.Host
 	paths := strings.Split(strings.TrimPrefix(gitURL.Path, "/"), "/")
-	if len(paths) < 1 {
+	if owner == "" || len(paths) < 1 || paths[0] == "" {
 		return nil, fmt.Errorf("Project URL \"%s\" missing repository", uri)
 	}
 	repo := paths[0]
 
 	// Parse query options
-	rev := gitURL.Query().Get("rev")
-	ref := gitURL.Query().Get("ref")
+	query := gitURL.Query()
+	host := defaultHost
+	if query.Has("host") {
+		host = query.Get("host")
+	}
+
+	// Build fetch url depending on transport
+	var fetchURL string
+	switch transport := query.Get("transport"); transport {
+	case "", "ssh":
+		fetchURL = fmt.Sprintf("git@%s:%s/%s.git", host, owner, repo)
+	case "https":
+		fetchURL = fmt.Sprintf("https://%s/%s/%s.git", host, owner, repo)
+	default:
+		return nil, fmt.Errorf("Unsupported transport \"%s\" in project URL \"%s\"", transport, uri)
+	}
+
+	// Sourcehut does not use the .git suffix
+	if scheme == "sourcehut" {
+		fetchURL = strings.TrimSuffix(fetchURL, ".git")
+	}
+
+	return &gitSource{
+		url:  fetchURL,
+		dir:  query.Get("dir"),
+		opts: gitOptionsFromQuery(query),
+	}, nil
+}
+
+// parseGitURI parses a uri like `git+https://example.com/repo.git?ref=main` into a
+// git source.
+func parseGitURI(uri string) (*gitSource, error) {
+	gitURL, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	// Strip `git+` from scheme
+	switch gitURL.Scheme {
+	case "git+https", "git+http", "git+ssh", "git+file":
+		gitURL.Scheme = strings.TrimPrefix(gitURL.Scheme, "git+")
+	default:
+		return nil, fmt.Errorf("Unsupported git scheme \"%s\" in project URL \"%s\"", gitURL.Scheme, uri)
+	}
+
+	// Take options out of the query
+	query := gitURL.Query()
+	src := &gitSource{
+		dir:  query.Get("dir"),
+		opts: gitOptionsFromQuery(query),
+	}
+	for _, key := range []string{"dir", "rev", "ref", "submodules"} {
+		query.Del(key)
+	}
+	gitURL.RawQuery = query.Encode()
+
+	src.url = gitURL.String()
+
+	return src, nil
+}
+
+func gitOptionsFromQuery(query url.Values) nix.FetchGitOptions {
 	submodules := false
-	if sub, err := strconv.ParseBool(gitURL.Query().Get("submodules")); err == nil {
+	if sub, err := strconv.ParseBool(query.Get("submodules")); err == nil {
 		submodules = sub
 	}
-	host := defaultHost
-	if gitURL.Query().Has("host") {
-		host = gitURL.Query().Get("host")
+
+	return nix.FetchGitOptions{
+		Revision:   query.Get("rev"),
+		Reference:  query.Get("ref"),
+		Submodules: submodules,
 	}
+}
 
+func resolveGitSource(src *gitSource) (*ProjectSource, error) {
 	// Fetch git repo
-	fetchURL := fmt.Sprintf("git@%s:%s/%s.git", host, owner, repo)
-	log.Debugf("Fetching \"%s\"", fetchURL)
-	entry, err := nix.FetchGit(
-		fetchURL,
-		&nix.FetchGitOptions{
-			Revision:   rev,
-			Reference:  ref,
-			Submodules: submodules,
-		},
-	)
+	log.Debugf("Fetching \"%s\"", src.url)
+	entry, err := nix.FetchGit(src.url, &src.opts)
 	if err != nil {
 		return nil, err
 	}
 
-	// Check dir
-	nilla := "./nilla.nix"
-	if gitURL.Query().Has("dir") {
-		nilla = filepath.Join(gitURL.Query().Get("dir"), "nilla.nix")
+	return newFetchedSource(entry, src.dir)
+}
+
+func newFetchedSource(entry *nix.FixedOutputStoreEntry, dir string) (*ProjectSource, error) {
+	// Check dir for a project file
+	for _, file := range projectFiles {
+		path := "./" + file
+		if dir != "" {
+			path = filepath.Join(dir, file)
+		}
+
+		if info, err := os.Stat(filepath.Join(entry.Path, path)); err == nil && !info.IsDir() {
+			return newSource(entry, path)
+		}
 	}
 
-	if info, err := os.Stat(filepath.Join(entry.Path, nilla)); err != nil || info.IsDir() {
-		return nil, fmt.Errorf("Directory \"%s\" does not contain a nilla.nix", filepath.Dir(nilla))
+	if dir == "" {
+		dir = "."
 	}
 
-	return &ProjectSource{
-		NillaPath: nilla,
-		StorePath: entry.Path,
-		StoreHash: entry.Hash,
-	}, nil
+	return nil, fmt.Errorf("Directory \"%s\" does not contain a nilla.nix or flake.nix", dir)
+}
+
+func isTarball(uri string) bool {
+	if !strings.HasPrefix(uri, "https://") && !strings.HasPrefix(uri, "http://") {
+		return false
+	}
+
+	u, err := url.Parse(uri)
+	if err != nil {
+		return false
+	}
+
+	for _, ext := range []string{".tar.gz", ".tgz", ".tar.xz", ".tar.bz2", ".tar.zst", ".tar", ".zip"} {
+		if strings.HasSuffix(u.Path, ext) {
+			return true
+		}
+	}
+
+	return false
 }
 
 func isPath(uri string) bool {
@@ -226,23 +425,53 @@ func resolveRealPath(path string) (string, error) {
 	return filepath.Abs(path)
 }
 
-func searchUpForNillaNix(path string) (string, error) {
+// searchUpForProject returns the directory containing a project file and the
+// name of that file. Directories are searched up to the root of the git
+// repository, or the filesystem outside of one, and a preferred project file
+// wins over the closer ones. That way flakes nested in a nilla project, e.g.
+// for packages, don't shadow its nilla.nix.
+func searchUpForProject(path string) (string, string, error) {
+	start := path
+	found := map[string]string{}
+
 	for {
-		_, err := os.Stat(filepath.Join(path, "nilla.nix"))
-		if err == nil {
-			return path, nil
+		for _, file := range projectFiles {
+			if _, ok := found[file]; ok {
+				continue
+			}
+
+			_, err := os.Stat(filepath.Join(path, file))
+			if err == nil {
+				found[file] = path
+			} else if !os.IsNotExist(err) {
+				return "", "", err
+			}
 		}
-		if !os.IsNotExist(err) {
-			return "", err
+
+		// Nothing is preferred over the first project file
+		if _, ok := found[projectFiles[0]]; ok {
+			break
 		}
 
-		if dir := filepath.Dir(path); dir != path {
-			path = dir
-			continue
+		// Don't search past the root of the git repository
+		if _, err := os.Stat(filepath.Join(path, ".git")); err == nil {
+			break
 		}
 
-		return "", fmt.Errorf("could not find nilla.nix in %s", path)
+		dir := filepath.Dir(path)
+		if dir == path {
+			break
+		}
+		path = dir
 	}
+
+	for _, file := range projectFiles {
+		if dir, ok := found[file]; ok {
+			return dir, file, nil
+		}
+	}
+
+	return "", "", fmt.Errorf("could not find nilla.nix or flake.nix in %s", start)
 }
 
 func searchUpForGitDir(path string) (string, error) {
@@ -264,7 +493,7 @@ func searchUpForGitDir(path string) (string, error) {
 	}
 }
 
-func resolveGitPath(root, path string) (*ProjectSource, error) {
+func resolveGitPath(root, path, file string) (*ProjectSource, error) {
 	// Get untracked files
 	untracked := getUntrackedFiles(path)
 	if len(untracked) > 0 {
@@ -275,20 +504,114 @@ func resolveGitPath(root, path string) (*ProjectSource, error) {
 		log.Warn("If you experience issues, try adding these files to your git repository with `git add`")
 	}
 
+	// Strip root prefix from path
+	stripped := strings.TrimPrefix(path, root)
+
+	// Check if this exact git tree has been added before
+	key := ""
+	if state, err := gitTreeState(root); err == nil {
+		key = cache.Key("project", "git", root, stripped, state)
+		if source, ok := cachedSource(key); ok {
+			return source, nil
+		}
+	}
+
 	// Add git path to nix store
 	entry, err := nix.AddGitPathToStore(root)
 	if err != nil {
 		return nil, err
 	}
 
-	// Strip root prefix from path
-	stripped := strings.TrimPrefix(path, root)
+	source, err := newSource(entry, filepath.Join("./", stripped, file))
+	if err != nil {
+		return nil, err
+	}
 
-	return &ProjectSource{
-		NillaPath: filepath.Join("./", stripped, "nilla.nix"),
-		StorePath: entry.Path,
-		StoreHash: entry.Hash,
-	}, nil
+	if key != "" {
+		cache.Set(key, source)
+	}
+
+	return source, nil
+}
+
+// cachedSource looks up a previously resolved project source in the cache,
+// making sure that its store path has not been garbage collected since.
+func cachedSource(key string) (*ProjectSource, bool) {
+	source := &ProjectSource{}
+	if !cache.Get(key, source) {
+		return nil, false
+	}
+
+	if _, err := os.Stat(source.StorePath); err != nil {
+		return nil, false
+	}
+
+	// The flake of a flake project is a separate store path
+	if source.IsFlake() {
+		if _, err := os.Stat(source.FlakePath); err != nil {
+			return nil, false
+		}
+	}
+
+	log.Debugf("Using cached store path %s", source.StorePath)
+
+	return source, true
+}
+
+// gitTreeState returns a string identifying the state of the tracked files in a
+// git repository, this is the current HEAD and a hash of all uncommitted changes.
+func gitTreeState(root string) (string, error) {
+	head, err := gitOutput(root, "rev-parse", "HEAD")
+	if err != nil {
+		return "", err
+	}
+
+	changes, err := gitOutput(root, "diff", "HEAD", "--binary")
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(changes)
+
+	return fmt.Sprintf("%s:%s", strings.TrimSpace(string(head)), hex.EncodeToString(sum[:])), nil
+}
+
+func gitOutput(dir string, args ...string) ([]byte, error) {
+	gitp, err := exec.LookPath("git")
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := exec.Command(gitp, args...)
+	cmd.Dir = dir
+
+	return cmd.Output()
+}
+
+// hashTree hashes the names, sizes, modes and modification times of all files
+// in a directory tree, which is much cheaper than hashing the contents.
+func hashTree(root string) (string, error) {
+	h := sha256.New()
+
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(h, "%s\x00%d\x00%d\x00%d\n", p, info.Size(), info.Mode(), info.ModTime().UnixNano())
+
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
 }
 
 func getUntrackedFiles(path string) []string {
@@ -0,0 +1,294 @@
+package project
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/arnarg/nilla-utils/internal/nix"
+	"github.com/go-test/deep"
+)
+
+func TestParseForgeURI(t *testing.T) {
+	tests := []struct {
+		name   string
+		in     string
+		prefix string
+		host   string
+		out    *gitSource
+	}{
+		{
+			name:   "github with defaults",
+			in:     "github:owner/repo",
+			prefix: "github:",
+			host:   "github.com",
+			out: &gitSource{
+				url: "git@github.com:owner/repo.git",
+			},
+		},
+		{
+			name:   "github over https with options",
+			in:     "github:owner/repo?transport=https&ref=main&dir=sub&submodules=1",
+			prefix: "github:",
+			host:   "github.com",
+			out: &gitSource{
+				url: "https://github.com/owner/repo.git",
+				dir: "sub",
+				opts: nix.FetchGitOptions{
+					Reference:  "main",
+					Submodules: true,
+				},
+			},
+		},
+		{
+			name:   "gitlab with custom host",
+			in:     "gitlab:owner/repo?host=git.example.com&rev=abc",
+			prefix: "gitlab:",
+			host:   "gitlab.com",
+			out: &gitSource{
+				url: "git@git.example.com:owner/repo.git",
+				opts: nix.FetchGitOptions{
+					Revision: "abc",
+				},
+			},
+		},
+		{
+			name:   "sourcehut over https",
+			in:     "sourcehut:~owner/repo?transport=https",
+			prefix: "sourcehut:",
+			host:   "git.sr.ht",
+			out: &gitSource{
+				url: "https://git.sr.ht/~owner/repo",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src, err := parseForgeURI(tt.in, tt.prefix, tt.host)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if src.url != tt.out.url {
+				t.Errorf("unexpected url: \"%s\" != \"%s\"", src.url, tt.out.url)
+			}
+			if src.dir != tt.out.dir {
+				t.Errorf("unexpected dir: \"%s\" != \"%s\"", src.dir, tt.out.dir)
+			}
+			if diff := deep.Equal(src.opts, tt.out.opts); diff != nil {
+				t.Error(diff)
+			}
+		})
+	}
+}
+
+func TestParseGitURI(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		out  *gitSource
+	}{
+		{
+			name: "git over https",
+			in:   "git+https://example.com/owner/repo.git?ref=main&dir=sub",
+			out: &gitSource{
+				url: "https://example.com/owner/repo.git",
+				dir: "sub",
+				opts: nix.FetchGitOptions{
+					Reference: "main",
+				},
+			},
+		},
+		{
+			name: "git over ssh",
+			in:   "git+ssh://git@example.com:2222/owner/repo",
+			out: &gitSource{
+				url: "ssh://git@example.com:2222/owner/repo",
+			},
+		},
+		{
+			name: "git from file",
+			in:   "git+file:///home/user/repo?rev=abc",
+			out: &gitSource{
+				url: "file:///home/user/repo",
+				opts: nix.FetchGitOptions{
+					Revision: "abc",
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src, err := parseGitURI(tt.in)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if src.url != tt.out.url {
+				t.Errorf("unexpected url: \"%s\" != \"%s\"", src.url, tt.out.url)
+			}
+			if src.dir != tt.out.dir {
+				t.Errorf("unexpected dir: \"%s\" != \"%s\"", src.dir, tt.out.dir)
+			}
+			if diff := deep.Equal(src.opts, tt.out.opts); diff != nil {
+				t.Error(diff)
+			}
+		})
+	}
+}
+
+func TestSearchUpForProject(t *testing.T) {
+	root := t.TempDir()
+	for _, file := range []string{
+		".git/HEAD",
+		"nilla.nix",
+		"flakes/.git/HEAD",
+		"flakes/flake.nix",
+		"flakes/host/default.nix",
+		"both/nilla.nix",
+		"both/flake.nix",
+		"other/default.nix",
+		"pkgs/foo/flake.nix",
+		"pkgs/foo/src/main.go",
+	} {
+		path := filepath.Join(root, file)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte("{}"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name string
+		in   string
+		dir  string
+		file string
+	}{
+		{
+			name: "nilla project",
+			in:   root,
+			dir:  root,
+			file: "nilla.nix",
+		},
+		{
+			name: "flake in parent directory",
+			in:   filepath.Join(root, "flakes/host"),
+			dir:  filepath.Join(root, "flakes"),
+			file: "flake.nix",
+		},
+		{
+			name: "nilla.nix is preferred",
+			in:   filepath.Join(root, "both"),
+			dir:  filepath.Join(root, "both"),
+			file: "nilla.nix",
+		},
+		{
+			name: "nilla project in parent directory",
+			in:   filepath.Join(root, "other"),
+			dir:  root,
+			file: "nilla.nix",
+		},
+		{
+			name: "flake nested in nilla project",
+			in:   filepath.Join(root, "pkgs/foo"),
+			dir:  root,
+			file: "nilla.nix",
+		},
+		{
+			name: "below flake nested in nilla project",
+			in:   filepath.Join(root, "pkgs/foo/src"),
+			dir:  root,
+			file: "nilla.nix",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, file, err := searchUpForProject(tt.in)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if dir != tt.dir || file != tt.file {
+				t.Errorf("expected %s in %s, got %s in %s", tt.file, tt.dir, file, dir)
+			}
+		})
+	}
+}
+
+func TestNewFetchedSource(t *testing.T) {
+	root := t.TempDir()
+	if err := os.Mkdir(filepath.Join(root, "sub"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "sub", "nilla.nix"), []byte("{}"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	entry := &nix.FixedOutputStoreEntry{Path: root, Hash: "0mdqa9w1p6cmli6976v4wi0sw9r4p5prkj7lzfd1877wk11c9c73"}
+
+	source, err := newFetchedSource(entry, "sub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &ProjectSource{
+		NillaPath: "sub/nilla.nix",
+		StorePath: root,
+		StoreHash: entry.Hash,
+	}
+	if diff := deep.Equal(source, expected); diff != nil {
+		t.Error(diff)
+	}
+	if source.IsFlake() {
+		t.Error("expected nilla project not to be a flake")
+	}
+
+	if _, err := newFetchedSource(entry, ""); err == nil {
+		t.Error("expected error for directory without project file")
+	}
+}
+
+func TestFlakeRef(t *testing.T) {
+	entry := &nix.FixedOutputStoreEntry{
+		Path: "/nix/store/nc394xps4al1r99ziabqvajbkrhxr5b7-source",
+		Hash: "0mdqa9w1p6cmli6976v4wi0sw9r4p5prkj7lzfd1877wk11c9c73",
+	}
+
+	tests := []struct {
+		name string
+		dir  string
+		out  string
+	}{
+		{
+			name: "flake at root",
+			dir:  ".",
+			out:  "path:/nix/store/nc394xps4al1r99ziabqvajbkrhxr5b7-source?narHash=sha256-47DEQpj8HBSa%2B%2FTImW%2B5JCeuQeRkm5NMpJWZG3hSuFU%3D",
+		},
+		{
+			name: "flake in sub directory",
+			dir:  "hosts/laptop",
+			out:  "path:/nix/store/nc394xps4al1r99ziabqvajbkrhxr5b7-source?dir=hosts%2Flaptop&narHash=sha256-47DEQpj8HBSa%2B%2FTImW%2B5JCeuQeRkm5NMpJWZG3hSuFU%3D",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ref, err := flakeRef(entry, tt.dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if ref != tt.out {
+				t.Errorf("expected \"%s\", got \"%s\"", tt.out, ref)
+			}
+		})
+	}
+
+	if _, err := flakeRef(&nix.FixedOutputStoreEntry{Path: entry.Path, Hash: "not-a-hash"}, "."); err == nil {
+		t.Error("expected error for invalid hash")
+	}
+}
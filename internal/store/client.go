@@ -0,0 +1,593 @@
+package store
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+const (
+	workerMagic1 = 0x6e697863
+	workerMagic2 = 0x6478696f
+
+	// Version 1.35 of the worker protocol, which is the version
+	// the client implements. The daemon may speak an older one.
+	protocolVersion = 1<<8 | 35
+
+	// Oldest minor version of the worker protocol supported.
+	minMinorVersion = 17
+)
+
+// Messages the daemon sends while processing an operation.
+const (
+	stderrNext          = 0x6f6c6d67
+	stderrRead          = 0x64617461
+	stderrWrite         = 0x64617416
+	stderrLast          = 0x616c7473
+	stderrError         = 0x63787470
+	stderrStartActivity = 0x53545254
+	stderrStopActivity  = 0x53544f50
+	stderrResult        = 0x52534c54
+)
+
+// Worker protocol operations.
+const (
+	opIsValidPath     = 1
+	opQueryPathInfo   = 26
+	opQueryValidPaths = 31
+)
+
+// PathInfo is the information the nix store has about a valid store path.
+type PathInfo struct {
+	Path             string
+	Deriver          string
+	NarHash          string
+	References       []string
+	RegistrationTime time.Time
+	NarSize          int64
+	Ultimate         bool
+	Signatures       []string
+	CA               string
+}
+
+// Error is an error reported by the nix daemon while processing an
+// operation. The connection can still be used after it.
+type Error struct {
+	Message string
+	Traces  []string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Client is a client for the nix daemon worker protocol. It is safe for
+// concurrent use, but operations are processed one at a time.
+type Client struct {
+	mu sync.Mutex
+
+	conn io.ReadWriteCloser
+	r    wireReader
+	w    wireWriter
+
+	minor   uint64
+	version string
+
+	// Error that broke the connection
+	err error
+}
+
+// NewClient performs the handshake with the nix daemon on the other
+// end of the connection. The connection is closed if it fails.
+func NewClient(conn io.ReadWriteCloser) (*Client, error) {
+	c := &Client{
+		conn: conn,
+		r:    wireReader{r: bufio.NewReader(conn)},
+		w:    wireWriter{w: bufio.NewWriter(conn)},
+	}
+
+	if err := c.handshake(); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("nix daemon handshake failed: %w", err)
+	}
+
+	return c, nil
+}
+
+func (c *Client) handshake() error {
+	if err := c.w.writeInt(workerMagic1); err != nil {
+		return err
+	}
+	if err := c.w.flush(); err != nil {
+		return err
+	}
+
+	magic, err := c.r.readInt()
+	if err != nil {
+		return err
+	}
+	if magic != workerMagic2 {
+		return errors.New("protocol mismatch")
+	}
+
+	version, err := c.r.readInt()
+	if err != nil {
+		return err
+	}
+	if version>>8 != 1 || version&0xff < minMinorVersion {
+		return fmt.Errorf("unsupported protocol version %d.%d", version>>8, version&0xff)
+	}
+
+	// Use the features of the older version
+	c.minor = min(version, protocolVersion) & 0xff
+
+	if err := c.w.writeInt(protocolVersion); err != nil {
+		return err
+	}
+	// Obsolete CPU affinity
+	if err := c.w.writeInt(0); err != nil {
+		return err
+	}
+	// Obsolete reserve space
+	if err := c.w.writeBool(false); err != nil {
+		return err
+	}
+	if err := c.w.flush(); err != nil {
+		return err
+	}
+
+	if c.minor >= 33 {
+		if c.version, err = c.r.readString(); err != nil {
+			return err
+		}
+	}
+	if c.minor >= 35 {
+		// Whether we're trusted, which doesn't matter for queries
+		if _, err := c.r.readInt(); err != nil {
+			return err
+		}
+	}
+
+	return c.processStderr()
+}
+
+// Version returns the version of nix the daemon runs, if it told us.
+func (c *Client) Version() string {
+	return c.version
+}
+
+// Close closes the connection to the daemon.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.err == nil {
+		c.err = errors.New("connection closed")
+	}
+
+	return c.conn.Close()
+}
+
+// Broken returns true if the connection can't be used anymore.
+func (c *Client) Broken() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.err != nil
+}
+
+// fail breaks the connection after an error that leaves the
+// stream in an unknown state.
+func (c *Client) fail(err error) error {
+	if c.err == nil {
+		c.err = err
+		c.conn.Close()
+	}
+	return err
+}
+
+// processStderr reads the messages sent by the daemon while processing an
+// operation until it is done. Logs and activities are discarded.
+func (c *Client) processStderr() error {
+	for {
+		msg, err := c.r.readInt()
+		if err != nil {
+			return err
+		}
+
+		switch msg {
+		case stderrLast:
+			return nil
+
+		case stderrError:
+			return c.readError()
+
+		case stderrNext, stderrWrite:
+			if _, err := c.r.readString(); err != nil {
+				return err
+			}
+
+		case stderrStartActivity:
+			// ID, level and type
+			for range 3 {
+				if _, err := c.r.readInt(); err != nil {
+					return err
+				}
+			}
+			if _, err := c.r.readString(); err != nil {
+				return err
+			}
+			if err := c.readFields(); err != nil {
+				return err
+			}
+			// Parent
+			if _, err := c.r.readInt(); err != nil {
+				return err
+			}
+
+		case stderrStopActivity:
+			if _, err := c.r.readInt(); err != nil {
+				return err
+			}
+
+		case stderrResult:
+			// ID and type
+			for range 2 {
+				if _, err := c.r.readInt(); err != nil {
+					return err
+				}
+			}
+			if err := c.readFields(); err != nil {
+				return err
+			}
+
+		case stderrRead:
+			return errors.New("daemon requested data")
+
+		default:
+			return fmt.Errorf("unknown message 0x%x from daemon", msg)
+		}
+	}
+}
+
+func (c *Client) readError() error {
+	if c.minor < 26 {
+		msg, err := c.r.readString()
+		if err != nil {
+			return err
+		}
+		// Exit status
+		if _, err := c.r.readInt(); err != nil {
+			return err
+		}
+
+		return &Error{Message: msg}
+	}
+
+	// Type, level and name
+	if _, err := c.r.readString(); err != nil {
+		return err
+	}
+	if _, err := c.r.readInt(); err != nil {
+		return err
+	}
+	if _, err := c.r.readString(); err != nil {
+		return err
+	}
+
+	msg, err := c.r.readString()
+	if err != nil {
+		return err
+	}
+	// Position, which is never sent
+	if _, err := c.r.readInt(); err != nil {
+		return err
+	}
+
+	n, err := c.r.readInt()
+	if err != nil {
+		return err
+	}
+
+	traces := []string{}
+	for range n {
+		// Position, which is never sent
+		if _, err := c.r.readInt(); err != nil {
+			return err
+		}
+		trace, err := c.r.readString()
+		if err != nil {
+			return err
+		}
+		traces = append(traces, trace)
+	}
+
+	return &Error{Message: msg, Traces: traces}
+}
+
+func (c *Client) readFields() error {
+	n, err := c.r.readInt()
+	if err != nil {
+		return err
+	}
+
+	for range n {
+		typ, err := c.r.readInt()
+		if err != nil {
+			return err
+		}
+
+		switch typ {
+		case 0:
+			_, err = c.r.readInt()
+		case 1:
+			_, err = c.r.readString()
+		default:
+			err = fmt.Errorf("unknown field type %d", typ)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// do sends a single operation and reads its result.
+func (c *Client) do(write func() error, read func() error) error {
+	if c.err != nil {
+		return c.err
+	}
+
+	if err := write(); err != nil {
+		return c.fail(err)
+	}
+	if err := c.w.flush(); err != nil {
+		return c.fail(err)
+	}
+
+	return c.finish(read)
+}
+
+// finish reads the result of an operation. Errors reported by the
+// daemon keep the stream in sync, other errors break the connection.
+func (c *Client) finish(read func() error) error {
+	if err := c.processStderr(); err != nil {
+		var derr *Error
+		if errors.As(err, &derr) {
+			return err
+		}
+		return c.fail(err)
+	}
+
+	if err := read(); err != nil {
+		return c.fail(err)
+	}
+
+	return nil
+}
+
+// IsValidPath returns true if the store path is valid.
+func (c *Client) IsValidPath(path string) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var valid bool
+	err := c.do(
+		func() error {
+			if err := c.w.writeInt(opIsValidPath); err != nil {
+				return err
+			}
+			return c.w.writeString(path)
+		},
+		func() (err error) {
+			valid, err = c.r.readBool()
+			return err
+		},
+	)
+
+	return valid, err
+}
+
+// QueryValidPaths returns the store paths that are valid.
+func (c *Client) QueryValidPaths(paths []string) ([]string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var valid []string
+	err := c.do(
+		func() error {
+			if err := c.w.writeInt(opQueryValidPaths); err != nil {
+				return err
+			}
+			if err := c.w.writeStrings(paths); err != nil {
+				return err
+			}
+			if c.minor >= 27 {
+				// Don't substitute
+				return c.w.writeBool(false)
+			}
+			return nil
+		},
+		func() (err error) {
+			valid, err = c.r.readStrings()
+			return err
+		},
+	)
+
+	return valid, err
+}
+
+// QueryPathInfo returns the information about a store path,
+// or nil if it's not valid.
+func (c *Client) QueryPathInfo(path string) (*PathInfo, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	infos, err := c.queryPathInfos([]string{path})
+	if err != nil {
+		return nil, err
+	}
+
+	return infos[0], nil
+}
+
+// QueryReferences returns the store paths a store path refers to.
+func (c *Client) QueryReferences(path string) ([]string, error) {
+	info, err := c.QueryPathInfo(path)
+	if err != nil {
+		return nil, err
+	}
+	if info == nil {
+		return nil, fmt.Errorf("path '%s' is not valid", path)
+	}
+
+	return info.References, nil
+}
+
+// QueryClosure returns the information about every store path in the
+// closure of the store paths, like `nix-store --query --requisites`.
+func (c *Client) QueryClosure(paths ...string) (map[string]*PathInfo, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	closure := map[string]*PathInfo{}
+	queued := map[string]bool{}
+
+	queue := []string{}
+	for _, path := range paths {
+		if !queued[path] {
+			queued[path] = true
+			queue = append(queue, path)
+		}
+	}
+
+	// Query one level of references at a time, so that each level
+	// only costs a single round trip to the daemon
+	for len(queue) > 0 {
+		infos, err := c.queryPathInfos(queue)
+		if err != nil {
+			return nil, err
+		}
+
+		next := []string{}
+		for i, info := range infos {
+			if info == nil {
+				return nil, fmt.Errorf("path '%s' is not valid", queue[i])
+			}
+			closure[info.Path] = info
+
+			for _, ref := range info.References {
+				if !queued[ref] {
+					queued[ref] = true
+					next = append(next, ref)
+				}
+			}
+		}
+
+		queue = next
+	}
+
+	return closure, nil
+}
+
+// queryPathInfos sends an operation for every path before reading any of
+// the results.
+func (c *Client) queryPathInfos(paths []string) ([]*PathInfo, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+
+	// Write in the background, as the daemon stops reading
+	// when it can't write the results we're not reading yet
+	written := make(chan error, 1)
+	go func() {
+		for _, path := range paths {
+			if err := c.w.writeInt(opQueryPathInfo); err != nil {
+				written <- err
+				return
+			}
+			if err := c.w.writeString(path); err != nil {
+				written <- err
+				return
+			}
+		}
+		written <- c.w.flush()
+	}()
+
+	// Read every result to keep the stream in sync, even
+	// after the daemon reported an error
+	var first error
+	infos := make([]*PathInfo, len(paths))
+	for i, path := range paths {
+		err := c.finish(func() (err error) {
+			infos[i], err = c.readPathInfo(path)
+			return err
+		})
+		if err != nil && first == nil {
+			first = err
+		}
+		if c.err != nil {
+			break
+		}
+	}
+
+	if err := <-written; err != nil {
+		return nil, c.fail(err)
+	}
+	if first != nil {
+		return nil, first
+	}
+
+	return infos, nil
+}
+
+func (c *Client) readPathInfo(path string) (*PathInfo, error) {
+	valid, err := c.r.readBool()
+	if err != nil || !valid {
+		return nil, err
+	}
+
+	info := &PathInfo{Path: path}
+
+	if info.Deriver, err = c.r.readString(); err != nil {
+		return nil, err
+	}
+
+	hash, err := c.r.readString()
+	if err != nil {
+		return nil, err
+	}
+	info.NarHash = normalizeHash(hash)
+
+	if info.References, err = c.r.readStrings(); err != nil {
+		return nil, err
+	}
+
+	registered, err := c.r.readInt()
+	if err != nil {
+		return nil, err
+	}
+	info.RegistrationTime = time.Unix(int64(registered), 0)
+
+	size, err := c.r.readInt()
+	if err != nil {
+		return nil, err
+	}
+	info.NarSize = int64(size)
+
+	if info.Ultimate, err = c.r.readBool(); err != nil {
+		return nil, err
+	}
+	if info.Signatures, err = c.r.readStrings(); err != nil {
+		return nil, err
+	}
+	if info.CA, err = c.r.readString(); err != nil {
+		return nil, err
+	}
+
+	return info, nil
+}
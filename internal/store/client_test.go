@@ -0,0 +1,367 @@
+package store
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"net"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/go-test/deep"
+)
+
+const (
+	storeTop    = "/nix/store/nc394xps4al1r99ziabqvajbkrhxr5b7-nixos-system-laptop-24.11"
+	storeSw     = "/nix/store/3bl0g75vyjgg8gnggwiavbwdxyg6gv20-system-path"
+	storeGzip   = "/nix/store/i6fl7i35dacvxqpzya6h78nacciwryfh-gzip-1.13"
+	storeGlibc  = "/nix/store/0a8xq2l0dfhkxvn5hqmz4gndp4kiqbv0-glibc-2.40-36"
+	storeBroken = "/nix/store/4x2hbfwzcq4gy7rz7m0ja8hk6kqqxdmd-broken"
+	storeError  = "/nix/store/bsx8s8v5lzdj7a2zq3q0qscdwn3f1mif-error"
+)
+
+var testPaths = map[string]*PathInfo{
+	storeTop: {
+		Path:       storeTop,
+		Deriver:    "/nix/store/ym0b8zzd3rs1kr2fj0x6fzp9mhgwz5wc-nixos-system-laptop-24.11.drv",
+		NarHash:    "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+		References: []string{storeSw, storeGlibc},
+		NarSize:    1024,
+		Signatures: []string{},
+	},
+	storeSw: {
+		Path:       storeSw,
+		NarHash:    "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+		References: []string{storeSw, storeGzip},
+		NarSize:    2048,
+		Signatures: []string{},
+	},
+	storeGzip: {
+		Path:       storeGzip,
+		NarHash:    "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+		References: []string{storeGlibc},
+		NarSize:    4096,
+		Ultimate:   true,
+		Signatures: []string{"cache.nixos.org-1:abc"},
+	},
+	storeGlibc: {
+		Path:       storeGlibc,
+		NarHash:    "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+		References: []string{},
+		NarSize:    8192,
+		Signatures: []string{},
+		CA:         "fixed:r:sha256:0mdqa9w1p6cmli6976v4wi0sw9r4p5prkj7lzfd1877wk11c9c73",
+	},
+	storeBroken: {
+		Path:       storeBroken,
+		NarHash:    "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+		References: []string{storeError, storeGzip},
+		Signatures: []string{},
+	},
+}
+
+// fakeDaemon speaks the daemon side of the worker protocol.
+type fakeDaemon struct {
+	minor uint64
+	r     wireReader
+	w     wireWriter
+}
+
+func startFakeDaemon(t *testing.T, minor uint64) *Client {
+	server, conn := net.Pipe()
+
+	d := &fakeDaemon{
+		minor: minor,
+		r:     wireReader{r: bufio.NewReader(server)},
+		w:     wireWriter{w: bufio.NewWriter(server)},
+	}
+	go func() {
+		defer server.Close()
+		d.serve()
+	}()
+
+	client, err := NewClient(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	return client
+}
+
+func (d *fakeDaemon) serve() error {
+	if _, err := d.r.readInt(); err != nil {
+		return err
+	}
+	d.w.writeInt(workerMagic2)
+	d.w.writeInt(1<<8 | d.minor)
+	d.w.flush()
+
+	// Client version, CPU affinity and reserve space
+	for range 3 {
+		if _, err := d.r.readInt(); err != nil {
+			return err
+		}
+	}
+
+	if d.minor >= 33 {
+		d.w.writeString("2.24.10")
+	}
+	if d.minor >= 35 {
+		d.w.writeInt(1)
+	}
+	d.w.writeInt(stderrLast)
+	d.w.flush()
+
+	for {
+		op, err := d.r.readInt()
+		if err != nil {
+			return err
+		}
+
+		switch op {
+		case opIsValidPath:
+			path, _ := d.r.readString()
+			d.w.writeInt(stderrNext)
+			d.w.writeString("checking validity")
+			d.w.writeInt(stderrLast)
+			d.w.writeBool(testPaths[path] != nil)
+
+		case opQueryValidPaths:
+			paths, _ := d.r.readStrings()
+			if d.minor >= 27 {
+				d.r.readBool()
+			}
+			valid := []string{}
+			for _, path := range paths {
+				if testPaths[path] != nil {
+					valid = append(valid, path)
+				}
+			}
+			d.w.writeInt(stderrLast)
+			d.w.writeStrings(valid)
+
+		case opQueryPathInfo:
+			path, _ := d.r.readString()
+			if path == storeError {
+				d.writeError("path is broken")
+				break
+			}
+
+			d.w.writeInt(stderrStartActivity)
+			d.w.writeInt(1)
+			d.w.writeInt(3)
+			d.w.writeInt(100)
+			d.w.writeString("querying info")
+			d.w.writeInt(2)
+			d.w.writeInt(0)
+			d.w.writeInt(42)
+			d.w.writeInt(1)
+			d.w.writeString(path)
+			d.w.writeInt(0)
+			d.w.writeInt(stderrResult)
+			d.w.writeInt(1)
+			d.w.writeInt(105)
+			d.w.writeInt(0)
+			d.w.writeInt(stderrStopActivity)
+			d.w.writeInt(1)
+			d.w.writeInt(stderrLast)
+
+			info := testPaths[path]
+			d.w.writeBool(info != nil)
+			if info != nil {
+				d.w.writeString(info.Deriver)
+				d.w.writeString(info.NarHash)
+				d.w.writeStrings(info.References)
+				d.w.writeInt(1700000000)
+				d.w.writeInt(uint64(info.NarSize))
+				d.w.writeBool(info.Ultimate)
+				d.w.writeStrings(info.Signatures)
+				d.w.writeString(info.CA)
+			}
+
+		default:
+			d.writeError("unknown operation")
+		}
+
+		d.w.flush()
+	}
+}
+
+func (d *fakeDaemon) writeError(msg string) {
+	d.w.writeInt(stderrError)
+	if d.minor < 26 {
+		d.w.writeString(msg)
+		d.w.writeInt(1)
+		return
+	}
+
+	d.w.writeString("Error")
+	d.w.writeInt(0)
+	d.w.writeString("Error")
+	d.w.writeString(msg)
+	d.w.writeInt(0)
+	d.w.writeInt(1)
+	d.w.writeInt(0)
+	d.w.writeString("while querying")
+}
+
+func TestClient(t *testing.T) {
+	for _, minor := range []uint64{21, 35} {
+		client := startFakeDaemon(t, minor)
+
+		if minor >= 33 && client.Version() != "2.24.10" {
+			t.Errorf("1.%d: unexpected version \"%s\"", minor, client.Version())
+		}
+
+		valid, err := client.IsValidPath(storeGzip)
+		if err != nil || !valid {
+			t.Errorf("1.%d: expected %s to be valid: %v", minor, storeGzip, err)
+		}
+
+		valid, err = client.IsValidPath(storeError)
+		if err != nil || valid {
+			t.Errorf("1.%d: expected %s not to be valid: %v", minor, storeError, err)
+		}
+
+		paths, err := client.QueryValidPaths([]string{storeGzip, storeError, storeGlibc})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := deep.Equal(paths, []string{storeGzip, storeGlibc}); diff != nil {
+			t.Errorf("1.%d: %v", minor, diff)
+		}
+
+		info, err := client.QueryPathInfo(storeGzip)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := &PathInfo{
+			Path:             storeGzip,
+			NarHash:          "sha256:0mdqa9w1p6cmli6976v4wi0sw9r4p5prkj7lzfd1877wk11c9c73",
+			References:       []string{storeGlibc},
+			RegistrationTime: time.Unix(1700000000, 0),
+			NarSize:          4096,
+			Ultimate:         true,
+			Signatures:       []string{"cache.nixos.org-1:abc"},
+		}
+		if diff := deep.Equal(info, expected); diff != nil {
+			t.Errorf("1.%d: %v", minor, diff)
+		}
+
+		refs, err := client.QueryReferences(storeSw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := deep.Equal(refs, []string{storeSw, storeGzip}); diff != nil {
+			t.Errorf("1.%d: %v", minor, diff)
+		}
+
+		closure, err := client.QueryClosure(storeTop)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys := []string{}
+		for path := range closure {
+			keys = append(keys, path)
+		}
+		slices.Sort(keys)
+		if diff := deep.Equal(keys, []string{storeGlibc, storeSw, storeGzip, storeTop}); diff != nil {
+			t.Errorf("1.%d: %v", minor, diff)
+		}
+
+		// Errors from the daemon don't break the connection
+		var derr *Error
+		if _, err := client.QueryClosure(storeBroken); !errors.As(err, &derr) || derr.Message != "path is broken" {
+			t.Errorf("1.%d: expected daemon error, got: %v", minor, err)
+		}
+		if client.Broken() {
+			t.Errorf("1.%d: expected connection to survive daemon error", minor)
+		}
+
+		info, err = client.QueryPathInfo(storeTop)
+		if err != nil || info == nil || info.NarSize != 1024 {
+			t.Errorf("1.%d: unexpected path info after error: %v, %v", minor, info, err)
+		}
+
+		// Invalid paths have no info
+		info, err = client.QueryPathInfo("/nix/store/ym0b8zzd3rs1kr2fj0x6fzp9mhgwz5wc-missing")
+		if err != nil || info != nil {
+			t.Errorf("1.%d: expected no info for invalid path, got: %v, %v", minor, info, err)
+		}
+	}
+}
+
+func TestClientClosed(t *testing.T) {
+	client := startFakeDaemon(t, 35)
+	client.Close()
+
+	if !client.Broken() {
+		t.Error("expected closed client to be broken")
+	}
+	if _, err := client.IsValidPath(storeGzip); err == nil {
+		t.Error("expected error from closed client")
+	}
+}
+
+func TestHandshakeMismatch(t *testing.T) {
+	server, conn := net.Pipe()
+
+	go func() {
+		defer server.Close()
+
+		w := wireWriter{w: bufio.NewWriter(server)}
+		r := wireReader{r: bufio.NewReader(server)}
+		r.readInt()
+		w.writeInt(0x1234)
+		w.writeInt(1<<8 | 35)
+		w.flush()
+	}()
+
+	if _, err := NewClient(conn); err == nil {
+		t.Error("expected handshake to fail")
+	}
+}
+
+func TestToBase32(t *testing.T) {
+	tests := []struct {
+		in  string
+		out string
+	}{
+		{
+			in:  "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+			out: "sha256:0mdqa9w1p6cmli6976v4wi0sw9r4p5prkj7lzfd1877wk11c9c73",
+		},
+		{
+			in:  "sha256:0mdqa9w1p6cmli6976v4wi0sw9r4p5prkj7lzfd1877wk11c9c73",
+			out: "sha256:0mdqa9w1p6cmli6976v4wi0sw9r4p5prkj7lzfd1877wk11c9c73",
+		},
+		{
+			in:  "not a hash",
+			out: "not a hash",
+		},
+	}
+
+	for _, tt := range tests {
+		if out := normalizeHash(tt.in); out != tt.out {
+			t.Errorf("expected \"%s\", got \"%s\"", tt.out, out)
+		}
+	}
+}
+
+func TestFromBase32(t *testing.T) {
+	hash, err := FromBase32("0mdqa9w1p6cmli6976v4wi0sw9r4p5prkj7lzfd1877wk11c9c73")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hex.EncodeToString(hash) != "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855" {
+		t.Errorf("unexpected hash %x", hash)
+	}
+
+	for _, invalid := range []string{"0mdqa9w1p6cmli6976v4wi0sw9r4p5prkj7lzfd1877wk11c9c7e", "zzdqa9w1p6cmli6976v4wi0sw9r4p5prkj7lzfd1877wk11c9c73"} {
+		if _, err := FromBase32(invalid); err == nil {
+			t.Errorf("expected error for \"%s\"", invalid)
+		}
+	}
+}
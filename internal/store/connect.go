@@ -0,0 +1,161 @@
+package store
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/arnarg/nilla-utils/internal/exec"
+	"github.com/charmbracelet/log"
+)
+
+const defaultSocketPath = "/nix/var/nix/daemon-socket/socket"
+
+var (
+	mu          sync.Mutex
+	connections = map[any]*connection{}
+)
+
+type connection struct {
+	client *Client
+	err    error
+}
+
+// All local executors share a connection.
+type localKey struct{}
+
+// Connect returns a client for the nix daemon on the machine the executor
+// runs commands on. Locally the daemon socket is used if possible, otherwise
+// `nix-daemon --stdio` is run through the executor. Connections are opened
+// once and reused until they break.
+func Connect(executor exec.Executor) (*Client, error) {
+	var key any = executor
+	if executor.IsLocal() {
+		key = localKey{}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if conn, ok := connections[key]; ok && (conn.client == nil || !conn.client.Broken()) {
+		return conn.client, conn.err
+	}
+
+	client, err := connect(executor)
+	if err != nil {
+		log.Debugf("Could not connect to nix daemon, falling back to nix CLI: %s", err)
+	}
+	connections[key] = &connection{client, err}
+
+	return client, err
+}
+
+// ConnectLocal returns a client for the local nix daemon.
+func ConnectLocal() (*Client, error) {
+	return Connect(exec.NewLocalExecutor())
+}
+
+func connect(executor exec.Executor) (*Client, error) {
+	if executor.IsLocal() {
+		if client, err := Dial(socketPath()); err == nil {
+			return client, nil
+		}
+	}
+
+	return DialCommand(executor)
+}
+
+// PathExists checks if a path exists on the machine the executor runs
+// commands on. Store paths are checked with the nix daemon if possible.
+func PathExists(executor exec.Executor, path string) (bool, error) {
+	if IsStorePath(path) {
+		if client, err := Connect(executor); err == nil {
+			return client.IsValidPath(path)
+		}
+	}
+
+	return executor.PathExists(path)
+}
+
+// IsStorePath returns true if path is a path directly in the nix store.
+func IsStorePath(path string) bool {
+	name, ok := strings.CutPrefix(path, "/nix/store/")
+	return ok && name != "" && !strings.Contains(name, "/")
+}
+
+func socketPath() string {
+	if path := os.Getenv("NIX_DAEMON_SOCKET_PATH"); path != "" {
+		return path
+	}
+	return defaultSocketPath
+}
+
+// Dial connects to a nix daemon listening on a unix socket.
+func Dial(path string) (*Client, error) {
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewClient(conn)
+}
+
+// DialCommand connects to a nix daemon by running `nix-daemon --stdio`
+// through the executor.
+func DialCommand(executor exec.Executor) (*Client, error) {
+	cmd, err := executor.Command("nix-daemon", "--stdio")
+	if err != nil {
+		return nil, err
+	}
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	stderr := &bytes.Buffer{}
+	cmd.SetStderr(stderr)
+
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	client, err := NewClient(&commandConn{stdout, stdin, cmd})
+	if err != nil {
+		// The command has exited when the connection is closed
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%w: %s", err, msg)
+		}
+		return nil, err
+	}
+
+	return client, nil
+}
+
+// commandConn is a connection to the standard input
+// and output of a command.
+type commandConn struct {
+	io.Reader
+	stdin io.WriteCloser
+	cmd   exec.Command
+}
+
+func (c *commandConn) Write(p []byte) (int, error) {
+	return c.stdin.Write(p)
+}
+
+func (c *commandConn) Close() error {
+	// The daemon exits at the end of its input, but may
+	// be blocked writing output nobody is going to read
+	c.stdin.Close()
+	go io.Copy(io.Discard, c.Reader)
+
+	return c.cmd.Wait()
+}
@@ -0,0 +1,74 @@
+package store
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// The alphabet of the base32 encoding used by nix, which omits e, o, u and t.
+const base32Chars = "0123456789abcdfghijklmnpqrsvwxyz"
+
+// ToBase32 encodes a hash in the base32 encoding used by nix.
+func ToBase32(hash []byte) string {
+	size := (len(hash)*8-1)/5 + 1
+
+	buf := make([]byte, 0, size)
+	for n := size - 1; n >= 0; n-- {
+		b := n * 5
+		i := b / 8
+		j := b % 8
+
+		c := hash[i] >> j
+		if i+1 < len(hash) {
+			c |= hash[i+1] << (8 - j)
+		}
+
+		buf = append(buf, base32Chars[c&0x1f])
+	}
+
+	return string(buf)
+}
+
+// FromBase32 decodes a hash in the base32 encoding used by nix.
+func FromBase32(s string) ([]byte, error) {
+	hash := make([]byte, len(s)*5/8)
+
+	for n := range len(s) {
+		digit := strings.IndexByte(base32Chars, s[len(s)-n-1])
+		if digit < 0 {
+			return nil, fmt.Errorf("invalid character in base32 hash \"%s\"", s)
+		}
+
+		b := n * 5
+		i := b / 8
+		j := b % 8
+
+		hash[i] |= byte(digit << j)
+
+		carry := byte(digit >> (8 - j))
+		if i+1 < len(hash) {
+			hash[i+1] |= carry
+		} else if carry != 0 {
+			return nil, fmt.Errorf("invalid base32 hash \"%s\"", s)
+		}
+	}
+
+	return hash, nil
+}
+
+// normalizeHash turns the NAR hash sent by the daemon, which is a
+// base16 SHA-256 hash without a prefix, into the form printed by
+// `nix-store --query --hash`.
+func normalizeHash(hash string) string {
+	if strings.Contains(hash, ":") {
+		return hash
+	}
+
+	raw, err := hex.DecodeString(hash)
+	if err != nil || len(raw) != 32 {
+		return hash
+	}
+
+	return "sha256:" + ToBase32(raw)
+}
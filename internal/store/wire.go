@@ -0,0 +1,122 @@
+package store
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Maximum length of a string read from the daemon, to avoid
+// allocating huge buffers when the stream is out of sync.
+const maxStringLen = 64 * 1024 * 1024
+
+// wireReader reads the little endian 64-bit integers and
+// padded strings the worker protocol is made of.
+type wireReader struct {
+	r   *bufio.Reader
+	buf [8]byte
+}
+
+func (r *wireReader) readInt() (uint64, error) {
+	if _, err := io.ReadFull(r.r, r.buf[:]); err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint64(r.buf[:]), nil
+}
+
+func (r *wireReader) readBool() (bool, error) {
+	n, err := r.readInt()
+	return n != 0, err
+}
+
+func (r *wireReader) readString() (string, error) {
+	n, err := r.readInt()
+	if err != nil {
+		return "", err
+	}
+	if n > maxStringLen {
+		return "", fmt.Errorf("string of %d bytes is too long", n)
+	}
+
+	// Strings are padded with zeroes to a multiple of 8 bytes
+	buf := make([]byte, (n+7)&^7)
+	if _, err := io.ReadFull(r.r, buf); err != nil {
+		return "", err
+	}
+
+	return string(buf[:n]), nil
+}
+
+func (r *wireReader) readStrings() ([]string, error) {
+	n, err := r.readInt()
+	if err != nil {
+		return nil, err
+	}
+
+	strs := make([]string, 0, min(n, 1024))
+	for range n {
+		s, err := r.readString()
+		if err != nil {
+			return nil, err
+		}
+		strs = append(strs, s)
+	}
+
+	return strs, nil
+}
+
+// wireWriter writes the little endian 64-bit integers and
+// padded strings the worker protocol is made of.
+type wireWriter struct {
+	w   *bufio.Writer
+	buf [8]byte
+}
+
+func (w *wireWriter) writeInt(n uint64) error {
+	binary.LittleEndian.PutUint64(w.buf[:], n)
+	_, err := w.w.Write(w.buf[:])
+	return err
+}
+
+func (w *wireWriter) writeBool(b bool) error {
+	if b {
+		return w.writeInt(1)
+	}
+	return w.writeInt(0)
+}
+
+func (w *wireWriter) writeString(s string) error {
+	if err := w.writeInt(uint64(len(s))); err != nil {
+		return err
+	}
+	if _, err := w.w.WriteString(s); err != nil {
+		return err
+	}
+
+	// Pad with zeroes to a multiple of 8 bytes
+	if pad := (8 - len(s)%8) % 8; pad > 0 {
+		clear(w.buf[:pad])
+		if _, err := w.w.Write(w.buf[:pad]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (w *wireWriter) writeStrings(strs []string) error {
+	if err := w.writeInt(uint64(len(strs))); err != nil {
+		return err
+	}
+	for _, s := range strs {
+		if err := w.writeString(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *wireWriter) flush() error {
+	return w.w.Flush()
+}
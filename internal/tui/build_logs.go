@@ -0,0 +1,156 @@
+package tui
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/arnarg/nilla-utils/internal/util"
+	"github.com/charmbracelet/log"
+)
+
+const (
+	// Number of log lines kept in memory for every build.
+	logTailLines = 50
+	// Number of run directories kept in the logs directory.
+	maxLogRuns = 20
+)
+
+// ringBuffer keeps the last n lines written to it.
+type ringBuffer struct {
+	lines []string
+	next  int
+	full  bool
+}
+
+func newRingBuffer(n int) *ringBuffer {
+	return &ringBuffer{lines: make([]string, n)}
+}
+
+func (r *ringBuffer) add(line string) {
+	r.lines[r.next] = line
+	r.next = (r.next + 1) % len(r.lines)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// tail returns the buffered lines, oldest first.
+func (r *ringBuffer) tail() []string {
+	if !r.full {
+		return slices.Clone(r.lines[:r.next])
+	}
+	return append(slices.Clone(r.lines[r.next:]), r.lines[:r.next]...)
+}
+
+// buildLogs collects the log lines of every build in a run. The last lines
+// of every build are kept in memory and complete logs are written to a
+// directory for the run.
+type buildLogs struct {
+	dir   string
+	files map[int64]*os.File
+	drvs  map[int64]string
+	tails map[string]*ringBuffer
+	paths map[string]string
+}
+
+func newBuildLogs() *buildLogs {
+	return &buildLogs{
+		files: map[int64]*os.File{},
+		drvs:  map[int64]string{},
+		tails: map[string]*ringBuffer{},
+		paths: map[string]string{},
+	}
+}
+
+func logsDir() string {
+	state := os.Getenv("XDG_STATE_HOME")
+	if state == "" {
+		state = filepath.Join(util.GetHomeDir(), ".local", "state")
+	}
+	return filepath.Join(state, "nilla-utils", "logs")
+}
+
+func (l *buildLogs) start(id int64, drv string) {
+	l.drvs[id] = drv
+	l.tails[drv] = newRingBuffer(logTailLines)
+
+	// Logs are only written to disk on a best effort basis
+	if l.dir == "" {
+		dir := filepath.Join(
+			logsDir(),
+			fmt.Sprintf("%s-%d", time.Now().Format("20060102-150405"), os.Getpid()),
+		)
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			log.Debugf("Could not create build log directory: %s", err)
+			return
+		}
+		l.dir = dir
+		pruneLogRuns()
+	}
+
+	// The store hash keeps derivations with the same name apart
+	path := filepath.Join(l.dir, fmt.Sprintf("%s.log", strings.TrimSuffix(filepath.Base(drv), ".drv")))
+	f, err := os.Create(path)
+	if err != nil {
+		log.Debugf("Could not create build log: %s", err)
+		return
+	}
+
+	l.files[id] = f
+	l.paths[drv] = path
+}
+
+func (l *buildLogs) add(id int64, line string) {
+	drv, ok := l.drvs[id]
+	if !ok {
+		return
+	}
+
+	l.tails[drv].add(line)
+
+	if f, ok := l.files[id]; ok {
+		fmt.Fprintln(f, line)
+	}
+}
+
+func (l *buildLogs) stop(id int64) {
+	if f, ok := l.files[id]; ok {
+		f.Close()
+		delete(l.files, id)
+	}
+	delete(l.drvs, id)
+}
+
+func (l *buildLogs) close() {
+	for id := range l.files {
+		l.stop(id)
+	}
+}
+
+// tail returns the last log lines of a derivation and the path to its
+// complete log, if it was written.
+func (l *buildLogs) tail(drv string) ([]string, string) {
+	r, ok := l.tails[drv]
+	if !ok {
+		return nil, ""
+	}
+	return r.tail(), l.paths[drv]
+}
+
+// Removes the oldest run directories. Directory names start with
+// a timestamp so they sort chronologically.
+func pruneLogRuns() {
+	entries, err := os.ReadDir(logsDir())
+	if err != nil {
+		return
+	}
+
+	for len(entries) > maxLogRuns {
+		os.RemoveAll(filepath.Join(logsDir(), entries[0].Name()))
+		entries = entries[1:]
+	}
+}
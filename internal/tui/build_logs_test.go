@@ -0,0 +1,73 @@
+package tui
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/go-test/deep"
+)
+
+func TestRingBuffer(t *testing.T) {
+	r := newRingBuffer(3)
+
+	if diff := deep.Equal(r.tail(), []string{}); diff != nil {
+		t.Error(diff)
+	}
+
+	r.add("a")
+	r.add("b")
+	if diff := deep.Equal(r.tail(), []string{"a", "b"}); diff != nil {
+		t.Error(diff)
+	}
+
+	r.add("c")
+	r.add("d")
+	r.add("e")
+	if diff := deep.Equal(r.tail(), []string{"c", "d", "e"}); diff != nil {
+		t.Error(diff)
+	}
+}
+
+func TestBuildLogs(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+
+	drv := "/nix/store/2467crcbg119q5jb5nwqxm0c87ls3wnv-hello-2.12.drv"
+
+	l := newBuildLogs()
+	l.start(1, drv)
+	for i := range logTailLines + 10 {
+		l.add(1, strings.Repeat("x", i))
+	}
+	l.stop(1)
+
+	tail, path := l.tail(drv)
+	if len(tail) != logTailLines {
+		t.Errorf("unexpected tail length: %d != %d", len(tail), logTailLines)
+	}
+
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(string(buf), "\n"); lines != logTailLines+10 {
+		t.Errorf("unexpected number of lines in log file: %d != %d", lines, logTailLines+10)
+	}
+
+	// Derivations with the same name get separate log files
+	other := "/nix/store/i6fl7i35dacvxqpzya6h78nacciwryfh-hello-2.12.drv"
+	l.start(2, other)
+	l.add(2, "other")
+	l.stop(2)
+
+	if _, otherPath := l.tail(other); otherPath == path {
+		t.Errorf("expected separate log files, both written to %s", path)
+	}
+	buf, err = os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(buf), "other") {
+		t.Error("expected log file not to be overwritten")
+	}
+}
@@ -3,10 +3,11 @@ package tui
 import (
 	"cmp"
 	"context"
-	"errors"
 	"fmt"
+	"os"
 	"slices"
 	"strings"
+	"time"
 
 	"github.com/arnarg/nilla-utils/internal/nix"
 	"github.com/charmbracelet/bubbles/spinner"
@@ -16,14 +17,39 @@ import (
 
 type BuildReporter struct {
 	verbose bool
+	stats   StatsOptions
 }
 
 func NewBuildReporter(verbose bool) *BuildReporter {
-	return &BuildReporter{verbose}
+	return &BuildReporter{verbose: verbose}
+}
+
+// WithStats makes the reporter report build statistics when done.
+func (r *BuildReporter) WithStats(opts StatsOptions) *BuildReporter {
+	r.stats = opts
+	return r
 }
 
 func (r *BuildReporter) Run(ctx context.Context, decoder *nix.ProgressDecoder) error {
-	return runTUIModel(ctx, initBuildModel(r.verbose), decoder)
+	m := initBuildModel(r.verbose)
+	defer m.logs.close()
+
+	err := runTUIModel(ctx, m, decoder)
+
+	if r.stats.enabled() {
+		m.stats.finish()
+
+		if r.stats.Print {
+			fmt.Fprint(os.Stderr, m.stats.summary(r.stats.Top))
+		}
+		if r.stats.JSONPath != "" {
+			if jerr := m.stats.writeJSON(r.stats.JSONPath); jerr != nil && err == nil {
+				err = jerr
+			}
+		}
+	}
+
+	return err
 }
 
 func extractName(p string) string {
@@ -54,6 +80,13 @@ type buildModel struct {
 	downloads map[int64]*copy
 	transfers map[int64]int64
 	builds    map[int64]*build
+	hooks     map[int64]string
+
+	expectedBuilds expectations
+	expectedCopies expectations
+
+	logs  *buildLogs
+	stats *buildStats
 
 	lastMsg string
 
@@ -73,6 +106,11 @@ func initBuildModel(verbose bool) buildModel {
 		downloads:      map[int64]*copy{},
 		builds:         map[int64]*build{},
 		transfers:      map[int64]int64{},
+		hooks:          map[int64]string{},
+		expectedBuilds: expectations{},
+		expectedCopies: expectations{},
+		logs:           newBuildLogs(),
+		stats:          newBuildStats(time.Now),
 		lastMsg:        "Initializing build...",
 	}
 }
@@ -81,6 +119,22 @@ func (m buildModel) error() error {
 	return m.err
 }
 
+// Attaches the captured log of a failed derivation to the error.
+func (m buildModel) buildError(err error) error {
+	berr, ok := err.(*nix.BuildError)
+	if !ok {
+		return err
+	}
+
+	tail, path := m.logs.tail(berr.Drv)
+	if len(tail) > len(berr.Log) {
+		berr.Log = tail
+	}
+	berr.LogFile = path
+
+	return berr
+}
+
 func (m buildModel) Init() tea.Cmd {
 	return m.spinner.Tick
 }
@@ -118,7 +172,11 @@ func (m buildModel) handleEvent(ev nix.Event) (tea.Model, tea.Cmd) {
 			// Lix does not have builtins.warn, this means warnings are logged at log level error
 			traceWarning := strings.HasPrefix(event.Text, "trace: ") && strings.Contains(event.Text, "warning:")
 			if !traceWarning {
-				m.err = errors.New(event.Text)
+				err := nix.ParseError(event.Text)
+				if berr, ok := err.(*nix.BuildError); ok {
+					m.stats.fail(berr.Drv)
+				}
+				m.err = m.buildError(err)
 				return m, nil
 			}
 		}
@@ -151,6 +209,7 @@ func (m buildModel) handleStartEvent(ev nix.Event) (tea.Model, tea.Cmd) {
 
 	case nix.StartCopyPathEvent:
 		m.downloads[ev.ID] = &copy{name: extractName(ev.Path)}
+		m.stats.startCopy(ev.ID)
 
 		if m.verbose {
 			return m, tea.Println(ev.Text)
@@ -167,19 +226,68 @@ func (m buildModel) handleStartEvent(ev nix.Event) (tea.Model, tea.Cmd) {
 
 	case nix.StartBuildEvent:
 		m.builds[ev.ID] = &build{name: strings.TrimSuffix(extractName(ev.Path), ".drv")}
+		m.logs.start(ev.ID, ev.Path)
+		m.stats.startBuild(ev.ID, ev.Path)
+		return m, nil
+
+	case nix.StartSubstituteEvent:
+		if !m.verbose && ev.Text != "" {
+			m.lastMsg = ev.Text
+		}
+		return m, nil
+
+	case nix.StartQueryPathInfoEvent:
+		if ev.Text != "" {
+			m.lastMsg = ev.Text
+		}
+		return m, nil
+
+	case nix.StartPostBuildHookEvent:
+		name := strings.TrimSuffix(extractName(ev.Path), ".drv")
+		m.hooks[ev.ID] = name
+		m.lastMsg = fmt.Sprintf("running post-build hook for %s", name)
+
+		if m.verbose {
+			return m, tea.Println(m.lastMsg)
+		}
+		return m, nil
+
+	case nix.StartBuildWaitingEvent:
+		return m.showStatus(ev.Text)
+
+	case nix.StartFetchTreeEvent:
+		return m.showStatus(ev.Text)
+	}
+
+	return m, nil
+}
+
+// Shows a status message, which is also printed in verbose mode.
+func (m buildModel) showStatus(text string) (tea.Model, tea.Cmd) {
+	if text == "" {
 		return m, nil
 	}
 
+	m.lastMsg = text
+	if m.verbose {
+		return m, tea.Println(text)
+	}
 	return m, nil
 }
 
 func (m buildModel) handleStopEvent(ev nix.StopEvent) (tea.Model, tea.Cmd) {
+	m.stats.stop(ev.ID)
+
 	// First check if ID is build
 	if _, ok := m.builds[ev.ID]; ok {
 		// Remove from builds map
 		delete(m.builds, ev.ID)
+		m.logs.stop(ev.ID)
 	}
 
+	// Post-build hooks are tracked separately
+	delete(m.hooks, ev.ID)
+
 	// Then check if it's a download
 	if _, ok := m.downloads[ev.ID]; ok {
 		// Remove from downloads map
@@ -212,6 +320,7 @@ func (m buildModel) handleResultEvent(ev nix.Event) (tea.Model, tea.Cmd) {
 
 		b.phase = ev.Phase
 		m.lastMsg = b.String()
+		m.stats.setPhase(ev.ID, ev.Phase)
 		return m, nil
 
 	case nix.ResultProgressEvent:
@@ -243,11 +352,52 @@ func (m buildModel) handleResultEvent(ev nix.Event) (tea.Model, tea.Cmd) {
 
 		d.done = ev.Done
 		d.total = ev.Expected
+		m.stats.transferProgress(ev.ID, ev.Done)
 
 		m.lastMsg = d.String()
 		return m, nil
 
+	case nix.ResultUntrustedPathEvent:
+		return m, printWarning("path '%s' is untrusted", ev.Path)
+
+	case nix.ResultCorruptedPathEvent:
+		return m, printWarning("path '%s' is corrupted or missing", ev.Path)
+
+	case nix.ResultSetExpectedEvent:
+		switch ev.ActivityType {
+		case nix.ActivityTypeBuild:
+			m.expectedBuilds[ev.ID] = ev.Expected
+		case nix.ActivityTypeCopyPath:
+			m.expectedCopies[ev.ID] = ev.Expected
+		}
+		return m, nil
+
+	case nix.ResultFetchStatusEvent:
+		m.lastMsg = ev.Text
+		return m, nil
+
+	case nix.ResultPostBuildLogLineEvent:
+		name, ok := m.hooks[ev.ID]
+		if !ok {
+			return m, nil
+		}
+
+		if m.verbose {
+			return m, tea.Printf(
+				"%s %s",
+				lipgloss.NewStyle().
+					Foreground(lipgloss.Color("13")).
+					SetString(fmt.Sprintf("%s (post-build)>", name)).
+					String(),
+				ev.Text,
+			)
+		}
+		m.lastMsg = fmt.Sprintf("%s (post-build): %s", name, ev.Text)
+		return m, nil
+
 	case nix.ResultBuildLogLineEvent:
+		m.logs.add(ev.ID, ev.Text)
+
 		if m.verbose {
 			// Try to find build
 			if b, ok := m.builds[ev.ID]; ok {
@@ -363,7 +513,7 @@ func fmtBuilds(m buildModel) string {
 
 	remaining := lipgloss.NewStyle().
 		Foreground(lipgloss.Color("12")).
-		SetString(fmt.Sprintf("⧗ %d", m.buildsProgs.totalExpected()-m.buildsProgs.totalDone())).
+		SetString(fmt.Sprintf("⧗ %d", max(m.buildsProgs.totalExpected(), m.expectedBuilds.total())-m.buildsProgs.totalDone())).
 		String()
 
 	return fmt.Sprintf("%s | %s | %s", running, done, remaining)
@@ -384,7 +534,7 @@ func fmtDownloads(m buildModel) string {
 		Foreground(lipgloss.Color("12")).
 		SetString(
 			fmt.Sprintf(
-				"⧗ %d", m.copyPathsProgs.totalExpected()-m.copyPathsProgs.totalDone(),
+				"⧗ %d", max(m.copyPathsProgs.totalExpected(), m.expectedCopies.total())-m.copyPathsProgs.totalDone(),
 			),
 		).
 		String()
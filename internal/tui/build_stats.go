@@ -0,0 +1,225 @@
+package tui
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/arnarg/nilla-utils/internal/util"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// StatsOptions controls the statistics reported after a build.
+type StatsOptions struct {
+	// Print a summary to stderr after the build.
+	Print bool
+	// Number of slowest builds to include in the summary.
+	Top int
+	// Write the statistics as JSON to this file, if set.
+	JSONPath string
+}
+
+func (o StatsOptions) enabled() bool {
+	return o.Print || o.JSONPath != ""
+}
+
+type phaseTiming struct {
+	Name    string        `json:"name"`
+	Elapsed time.Duration `json:"-"`
+	Seconds float64       `json:"seconds"`
+}
+
+type buildTiming struct {
+	Name    string         `json:"name"`
+	Drv     string         `json:"drv"`
+	Elapsed time.Duration  `json:"-"`
+	Seconds float64        `json:"seconds"`
+	Phases  []*phaseTiming `json:"phases"`
+
+	start      time.Time
+	phaseStart time.Time
+}
+
+// buildStats tracks timing of builds and substitutions during a run.
+type buildStats struct {
+	WallSeconds float64        `json:"wallSeconds"`
+	Built       int            `json:"built"`
+	Failed      int            `json:"failed"`
+	Substituted int            `json:"substituted"`
+	Downloaded  int64          `json:"downloadedBytes"`
+	Builds      []*buildTiming `json:"builds"`
+	Failures    []*buildTiming `json:"failures"`
+
+	now       func() time.Time
+	start     time.Time
+	wall      time.Duration
+	running   map[int64]*buildTiming
+	failed    map[string]bool
+	copies    map[int64]bool
+	transfers map[int64]int64
+}
+
+func newBuildStats(now func() time.Time) *buildStats {
+	return &buildStats{
+		Builds:    []*buildTiming{},
+		Failures:  []*buildTiming{},
+		now:       now,
+		start:     now(),
+		running:   map[int64]*buildTiming{},
+		failed:    map[string]bool{},
+		copies:    map[int64]bool{},
+		transfers: map[int64]int64{},
+	}
+}
+
+func (s *buildStats) startBuild(id int64, drv string) {
+	now := s.now()
+	s.running[id] = &buildTiming{
+		Name:   strings.TrimSuffix(extractName(drv), ".drv"),
+		Drv:    drv,
+		Phases: []*phaseTiming{},
+		start:  now,
+	}
+}
+
+func (s *buildStats) setPhase(id int64, phase string) {
+	b, ok := s.running[id]
+	if !ok {
+		return
+	}
+
+	now := s.now()
+	b.endPhase(now)
+	b.Phases = append(b.Phases, &phaseTiming{Name: phase})
+	b.phaseStart = now
+}
+
+func (b *buildTiming) endPhase(now time.Time) {
+	if len(b.Phases) < 1 {
+		return
+	}
+
+	p := b.Phases[len(b.Phases)-1]
+	p.Elapsed = now.Sub(b.phaseStart)
+	p.Seconds = p.Elapsed.Seconds()
+}
+
+func (s *buildStats) startCopy(id int64) {
+	s.copies[id] = true
+}
+
+func (s *buildStats) transferProgress(id, done int64) {
+	s.transfers[id] = done
+}
+
+func (s *buildStats) stop(id int64) {
+	if b, ok := s.running[id]; ok {
+		now := s.now()
+		b.endPhase(now)
+		b.Elapsed = now.Sub(b.start)
+		b.Seconds = b.Elapsed.Seconds()
+
+		if s.failed[b.Drv] {
+			s.Failures = append(s.Failures, b)
+			s.Failed++
+		} else {
+			s.Builds = append(s.Builds, b)
+			s.Built++
+		}
+		delete(s.running, id)
+	}
+
+	if _, ok := s.copies[id]; ok {
+		s.Substituted++
+		delete(s.copies, id)
+	}
+
+	// Transfers keep reporting progress until they stop
+	if done, ok := s.transfers[id]; ok {
+		s.Downloaded += done
+		delete(s.transfers, id)
+	}
+}
+
+// fail marks the build of a derivation as failed. Nix reports the failure
+// after the build has stopped, so a stopped build is moved to the failures.
+func (s *buildStats) fail(drv string) {
+	s.failed[drv] = true
+
+	i := slices.IndexFunc(s.Builds, func(b *buildTiming) bool {
+		return b.Drv == drv
+	})
+	if i < 0 {
+		return
+	}
+
+	s.Failures = append(s.Failures, s.Builds[i])
+	s.Failed++
+	s.Builds = slices.Delete(s.Builds, i, i+1)
+	s.Built--
+}
+
+// finish stops the wall clock and sorts builds from slowest to fastest.
+func (s *buildStats) finish() {
+	s.wall = s.now().Sub(s.start)
+	s.WallSeconds = s.wall.Seconds()
+
+	slices.SortStableFunc(s.Builds, func(a, b *buildTiming) int {
+		return cmp.Compare(b.Elapsed, a.Elapsed)
+	})
+}
+
+func (s *buildStats) writeJSON(path string) error {
+	buf, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, buf, 0o644)
+}
+
+func (s *buildStats) summary(top int) string {
+	strb := &strings.Builder{}
+
+	label := lipgloss.NewStyle().Bold(true).Width(14)
+
+	size, unit := util.ConvertBytes(s.Downloaded)
+
+	fmt.Fprintf(strb, "%s%s\n", label.Render("Wall time:"), s.wall.Round(time.Millisecond))
+	fmt.Fprintf(strb, "%s%d derivations\n", label.Render("Built:"), s.Built)
+	if s.Failed > 0 {
+		fmt.Fprintf(strb, "%s%d derivations\n", label.Render("Failed:"), s.Failed)
+	}
+	fmt.Fprintf(
+		strb,
+		"%s%d paths (%.2f %s downloaded)\n",
+		label.Render("Substituted:"), s.Substituted, size, unit,
+	)
+
+	if len(s.Builds) < 1 || top < 1 {
+		return strb.String()
+	}
+
+	rows := [][]string{}
+	for _, b := range s.Builds[:min(top, len(s.Builds))] {
+		phases := []string{}
+		for _, p := range b.Phases {
+			phases = append(phases, fmt.Sprintf("%s %s", p.Name, p.Elapsed.Round(time.Millisecond)))
+		}
+
+		rows = append(rows, []string{
+			b.Name,
+			b.Elapsed.Round(time.Millisecond).String(),
+			strings.Join(phases, ", "),
+		})
+	}
+
+	fmt.Fprintf(strb, "\n%s\n", lipgloss.NewStyle().Bold(true).Render("Slowest builds:"))
+	strb.WriteString(util.RenderTable([]string{"Derivation", "Time", "Phases"}, rows...))
+	strb.WriteString("\n")
+
+	return strb.String()
+}
@@ -0,0 +1,106 @@
+package tui
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/arnarg/nilla-utils/internal/nix"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/go-test/deep"
+)
+
+func TestBuildStats(t *testing.T) {
+	clock := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	now := func() time.Time { return clock }
+	tick := func(d time.Duration) { clock = clock.Add(d) }
+
+	s := newBuildStats(now)
+
+	// Substituted path
+	s.startCopy(1)
+	s.transferProgress(2, 1024)
+	s.transferProgress(2, 4096)
+	tick(time.Second)
+	s.stop(2)
+	s.stop(1)
+
+	// Fast build
+	s.startBuild(3, "/nix/store/2467crcbg119q5jb5nwqxm0c87ls3wnv-fast-1.0.drv")
+	tick(2 * time.Second)
+	s.stop(3)
+
+	// Slow build with phases
+	s.startBuild(4, "/nix/store/2467crcbg119q5jb5nwqxm0c87ls3wnv-slow-1.0.drv")
+	tick(time.Second)
+	s.setPhase(4, "unpackPhase")
+	tick(time.Second)
+	s.setPhase(4, "buildPhase")
+	tick(10 * time.Second)
+	s.stop(4)
+
+	s.finish()
+
+	if s.Built != 2 {
+		t.Errorf("unexpected number of builds: %d", s.Built)
+	}
+	if s.Substituted != 1 {
+		t.Errorf("unexpected number of substitutions: %d", s.Substituted)
+	}
+	if s.Downloaded != 4096 {
+		t.Errorf("unexpected downloaded bytes: %d", s.Downloaded)
+	}
+	if s.wall != 15*time.Second {
+		t.Errorf("unexpected wall time: %s", s.wall)
+	}
+
+	// Slowest build first
+	if diff := deep.Equal(s.Builds[0], &buildTiming{
+		Name:    "slow-1.0",
+		Drv:     "/nix/store/2467crcbg119q5jb5nwqxm0c87ls3wnv-slow-1.0.drv",
+		Elapsed: 12 * time.Second,
+		Seconds: 12,
+		Phases: []*phaseTiming{
+			{Name: "unpackPhase", Elapsed: time.Second, Seconds: 1},
+			{Name: "buildPhase", Elapsed: 10 * time.Second, Seconds: 10},
+		},
+	}); diff != nil {
+		t.Error(diff)
+	}
+	if s.Builds[1].Name != "fast-1.0" {
+		t.Errorf("unexpected second slowest build: %s", s.Builds[1].Name)
+	}
+}
+
+func TestBuildStatsFailure(t *testing.T) {
+	f, err := os.Open("../nix/testdata/build-failure.progress")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+
+	decoder := nix.NewProgressDecoder(nix.ReplayProgress(context.Background(), f, nil, 0))
+
+	var m tea.Model = initBuildModel(false)
+	for ev := range decoder.Events {
+		m, _ = m.Update(ev)
+	}
+	m.(buildModel).logs.close()
+
+	s := m.(buildModel).stats
+	if s.Built != 0 || len(s.Builds) != 0 {
+		t.Errorf("expected no successful builds, got %d", s.Built)
+	}
+	if s.Failed != 1 || len(s.Failures) != 1 {
+		t.Fatalf("expected one failed build, got %d", s.Failed)
+	}
+	if s.Failures[0].Name != "hello-2.12" {
+		t.Errorf("unexpected failed build: %s", s.Failures[0].Name)
+	}
+	if s.Substituted != 1 {
+		t.Errorf("unexpected number of substitutions: %d", s.Substituted)
+	}
+}
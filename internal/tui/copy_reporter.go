@@ -2,7 +2,6 @@ package tui
 
 import (
 	"context"
-	"errors"
 	"fmt"
 	"strings"
 
@@ -98,7 +97,7 @@ func (m copyModel) handleEvent(ev nix.Event) (tea.Model, tea.Cmd) {
 
 		// error
 		if event.Level == 0 {
-			m.err = errors.New(event.Text)
+			m.err = nix.ParseError(event.Text)
 			return m, nil
 		}
 
@@ -137,6 +136,18 @@ func (m copyModel) handleStartEvent(ev nix.Event) (tea.Model, tea.Cmd) {
 
 		m.transfers[ev.ID] = ev.Parent
 		return m, nil
+
+	case nix.StartSubstituteEvent:
+		if !m.verbose && ev.Text != "" {
+			m.lastMsg = ev.Text
+		}
+		return m, nil
+
+	case nix.StartQueryPathInfoEvent:
+		if ev.Text != "" {
+			m.lastMsg = ev.Text
+		}
+		return m, nil
 	}
 
 	return m, nil
@@ -177,6 +188,12 @@ func (m copyModel) handleStopEvent(ev nix.StopEvent) (tea.Model, tea.Cmd) {
 
 func (m copyModel) handleResultEvent(ev nix.Event) (tea.Model, tea.Cmd) {
 	switch ev := ev.(type) {
+	case nix.ResultUntrustedPathEvent:
+		return m, printWarning("path '%s' is untrusted", ev.Path)
+
+	case nix.ResultCorruptedPathEvent:
+		return m, printWarning("path '%s' is corrupted or missing", ev.Path)
+
 	case nix.ResultProgressEvent:
 		// Check if the event ID is a CopyPaths event
 		if p, ok := m.copyPathsProgs[ev.ID]; ok {
@@ -0,0 +1,116 @@
+package tui
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/arnarg/nilla-utils/internal/nix"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/log"
+)
+
+var (
+	errorStyle    = lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("9"))
+	positionStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("12"))
+	traceStyle    = lipgloss.NewStyle().Foreground(lipgloss.Color("8"))
+	hintStyle     = lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("11"))
+	logStyle      = lipgloss.NewStyle().Foreground(lipgloss.Color("13"))
+)
+
+// PrintError prints an error to stderr. Nix errors are rendered with
+// all their details, other errors are logged.
+func PrintError(err error) {
+	if s := RenderError(err); s != "" {
+		fmt.Fprint(os.Stderr, s)
+		return
+	}
+	log.Error(err)
+}
+
+// RenderError renders a nix error. Returns an empty string if err
+// does not wrap a nix error.
+func RenderError(err error) string {
+	var (
+		merr *nix.MissingAttributeError
+		eerr *nix.EvalError
+		berr *nix.BuildError
+	)
+
+	strb := &strings.Builder{}
+
+	switch {
+	case errors.As(err, &merr):
+		renderContext(strb, err, merr)
+		renderEvalError(strb, merr.EvalError)
+		if len(merr.Suggestions) > 0 {
+			fmt.Fprintf(
+				strb,
+				"%s did you mean %s?\n",
+				hintStyle.Render("hint:"),
+				strings.Join(merr.Suggestions, ", "),
+			)
+		}
+
+	case errors.As(err, &eerr):
+		renderContext(strb, err, eerr)
+		renderEvalError(strb, eerr)
+
+	case errors.As(err, &berr):
+		renderContext(strb, err, berr)
+		renderBuildError(strb, berr)
+
+	default:
+		return ""
+	}
+
+	return strb.String()
+}
+
+// Errors wrapped with fmt.Errorf carry context in front of the nix error.
+func renderContext(strb *strings.Builder, err, inner error) {
+	if ctx := strings.TrimSuffix(err.Error(), inner.Error()); ctx != "" {
+		fmt.Fprintf(strb, "%s\n", strings.TrimSuffix(strings.TrimSpace(ctx), ":"))
+	}
+}
+
+func renderEvalError(strb *strings.Builder, err *nix.EvalError) {
+	for _, frame := range err.Trace {
+		fmt.Fprintf(strb, "%s\n", traceStyle.Render("… "+frame.Description))
+		if frame.Position != nil {
+			fmt.Fprintf(strb, "  %s\n", traceStyle.Render("at "+frame.Position.String()))
+		}
+	}
+
+	fmt.Fprintf(strb, "%s %s\n", errorStyle.Render("error:"), err.Message)
+	if err.Position != nil {
+		fmt.Fprintf(strb, "  %s\n", positionStyle.Render("at "+err.Position.String()))
+	}
+
+	if err.Hint != "" {
+		fmt.Fprintf(strb, "%s %s\n", hintStyle.Render("hint:"), err.Hint)
+	}
+}
+
+func renderBuildError(strb *strings.Builder, err *nix.BuildError) {
+	fmt.Fprintf(strb, "%s %s\n", errorStyle.Render("error:"), err.Message)
+
+	if len(err.Log) > 0 {
+		name := strings.TrimSuffix(extractName(err.Drv), ".drv")
+		for _, line := range err.Log {
+			fmt.Fprintf(strb, "%s %s\n", logStyle.Render(name+">"), line)
+		}
+	}
+
+	if err.LogFile != "" {
+		fmt.Fprintf(strb, "%s the full build log was saved to %s\n", hintStyle.Render("hint:"), err.LogFile)
+	} else {
+		fmt.Fprintf(
+			strb,
+			"%s run `nix log %s` to see the full build log\n",
+			hintStyle.Render("hint:"),
+			err.Drv,
+		)
+	}
+}
@@ -0,0 +1,476 @@
+package tui
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/arnarg/nilla-utils/internal/diff"
+	"github.com/arnarg/nilla-utils/internal/util"
+	"github.com/charmbracelet/bubbles/viewport"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// GenerationItem is a generation shown in the generation browser.
+type GenerationItem struct {
+	ID        int
+	BuildDate time.Time
+	// Details are extra columns shown for the generation, e.g. versions.
+	Details []string
+	Current bool
+	Pinned  bool
+	// Generation is used to calculate diffs and closure sizes.
+	Generation *diff.Generation
+}
+
+// GenerationBrowser configures the interactive generation browser.
+type GenerationBrowser struct {
+	Title string
+	// Headers of the generation details.
+	Headers []string
+	// Generations sorted from newest to oldest.
+	Generations []*GenerationItem
+	// SetPinned persists the pinning of a generation.
+	SetPinned func(item *GenerationItem, pinned bool) error
+}
+
+// BrowserResult holds the actions chosen in the generation browser.
+type BrowserResult struct {
+	Delete   []*GenerationItem
+	Activate *GenerationItem
+}
+
+var (
+	currentStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("13")).Bold(true)
+	cursorStyle  = lipgloss.NewStyle().Foreground(lipgloss.Color("205")).Bold(true)
+	pinnedStyle  = lipgloss.NewStyle().Foreground(lipgloss.Color("14"))
+	deleteStyle  = lipgloss.NewStyle().Foreground(lipgloss.Color("9"))
+	baseStyle    = lipgloss.NewStyle().Foreground(lipgloss.Color("11"))
+	helpStyle    = lipgloss.NewStyle().Foreground(lipgloss.Color("8"))
+)
+
+type sizeMsg struct {
+	index int
+	size  int64
+	err   error
+}
+
+type diffMsg struct {
+	to   *GenerationItem
+	text string
+	err  error
+}
+
+type whyMsg struct {
+	text string
+	err  error
+}
+
+type browserModel struct {
+	browser GenerationBrowser
+
+	w, h   int
+	cursor int
+	offset int
+	// Index of the generation to diff against, -1 for the current one.
+	base     int
+	deleting map[int]bool
+	sizes    map[int]string
+
+	viewing  bool
+	loading  bool
+	viewport viewport.Model
+	diffTo   *GenerationItem
+	diffText string
+
+	// Package name being entered to explain why it's in the closure
+	prompting bool
+	query     string
+
+	status string
+	result *BrowserResult
+}
+
+// RunGenerationBrowser opens an interactive browser for the generations.
+// Returns nil if the browser was cancelled.
+func RunGenerationBrowser(browser GenerationBrowser) (*BrowserResult, error) {
+	init := browserModel{
+		browser:  browser,
+		h:        24,
+		base:     -1,
+		deleting: map[int]bool{},
+		sizes:    map[int]string{},
+	}
+
+	p := tea.NewProgram(
+		init,
+		tea.WithOutput(os.Stderr),
+		tea.WithAltScreen(),
+	)
+
+	m, err := p.Run()
+	if err != nil {
+		return nil, err
+	}
+
+	return m.(browserModel).result, nil
+}
+
+func (m browserModel) Init() tea.Cmd {
+	if len(m.browser.Generations) < 1 {
+		return nil
+	}
+	return m.sizeCmd(0)
+}
+
+// Closure sizes are calculated one generation at a time.
+func (m browserModel) sizeCmd(index int) tea.Cmd {
+	gen := m.browser.Generations[index].Generation
+	return func() tea.Msg {
+		size, err := diff.ClosureSize(gen)
+		return sizeMsg{index, size, err}
+	}
+}
+
+func (m browserModel) diffCmd(from, to *GenerationItem) tea.Cmd {
+	return func() tea.Msg {
+		pkgDiff, closure, err := diff.Run(from.Generation, to.Generation)
+		if err != nil {
+			return diffMsg{err: err}
+		}
+
+		strb := &strings.Builder{}
+		fmt.Fprintf(strb, "Comparing changes (%d -> %d)\n\n", from.ID, to.ID)
+		if pkgs := diff.Render(pkgDiff); pkgs != "" {
+			strb.WriteString(pkgs)
+		} else {
+			strb.WriteString("No package changes\n")
+		}
+		strb.WriteString(diff.RenderClosure(closure))
+
+		return diffMsg{to: to, text: strb.String()}
+	}
+}
+
+func (m browserModel) whyCmd(gen *GenerationItem, pname string) tea.Cmd {
+	return func() tea.Msg {
+		chains, err := diff.Why(gen.Generation, pname)
+		if err != nil {
+			return whyMsg{err: err}
+		}
+
+		return whyMsg{
+			text: fmt.Sprintf("\nWhy %s is in %d:\n%s", pname, gen.ID, diff.RenderWhy(pname, chains)),
+		}
+	}
+}
+
+func (m browserModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.w = msg.Width
+		m.h = msg.Height
+		m.viewport.Width = msg.Width
+		m.viewport.Height = max(msg.Height-2, 1)
+		return m, nil
+
+	case sizeMsg:
+		if msg.err != nil {
+			m.sizes[msg.index] = "?"
+		} else {
+			size, unit := util.ConvertBytes(msg.size)
+			m.sizes[msg.index] = fmt.Sprintf("%.2f %s", size, unit)
+		}
+
+		if next := msg.index + 1; next < len(m.browser.Generations) {
+			return m, m.sizeCmd(next)
+		}
+		return m, nil
+
+	case diffMsg:
+		m.loading = false
+		if msg.err != nil {
+			m.status = fmt.Sprintf("Could not compare generations: %s", msg.err)
+			return m, nil
+		}
+
+		m.status = ""
+		m.viewing = true
+		m.diffTo = msg.to
+		m.diffText = msg.text
+		m.viewport = viewport.New(m.w, max(m.h-2, 1))
+		m.viewport.SetContent(msg.text)
+		return m, nil
+
+	case whyMsg:
+		m.loading = false
+		m.status = ""
+		if msg.err != nil {
+			m.status = fmt.Sprintf("Could not explain package: %s", msg.err)
+			return m, nil
+		}
+
+		// Expand the explanation below the diff
+		m.diffText += msg.text
+		m.viewport.SetContent(m.diffText)
+		m.viewport.GotoBottom()
+		return m, nil
+
+	case tea.KeyMsg:
+		if m.viewing {
+			return m.updateDiffView(msg)
+		}
+		return m.updateList(msg)
+	}
+
+	return m, nil
+}
+
+func (m browserModel) updateDiffView(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	if m.prompting {
+		return m.updatePrompt(msg)
+	}
+
+	switch msg.String() {
+	case "ctrl+c":
+		return m, tea.Quit
+	case "esc", "q", "backspace":
+		m.viewing = false
+		m.status = ""
+		return m, nil
+	case "w":
+		if !m.loading {
+			m.prompting = true
+			m.query = ""
+		}
+		return m, nil
+	}
+
+	var cmd tea.Cmd
+	m.viewport, cmd = m.viewport.Update(msg)
+	return m, cmd
+}
+
+func (m browserModel) updatePrompt(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.Type {
+	case tea.KeyCtrlC:
+		return m, tea.Quit
+	case tea.KeyEsc:
+		m.prompting = false
+	case tea.KeyBackspace:
+		if len(m.query) > 0 {
+			m.query = m.query[:len(m.query)-1]
+		}
+	case tea.KeyEnter:
+		m.prompting = false
+		if m.query == "" {
+			return m, nil
+		}
+
+		m.loading = true
+		m.status = fmt.Sprintf("Finding why %s is in the closure...", m.query)
+		return m, m.whyCmd(m.diffTo, m.query)
+	case tea.KeyRunes:
+		m.query += string(msg.Runes)
+	}
+
+	return m, nil
+}
+
+func (m browserModel) updateList(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	gens := m.browser.Generations
+	if len(gens) < 1 {
+		if msg.String() == "q" || msg.String() == "esc" || msg.String() == "ctrl+c" {
+			return m, tea.Quit
+		}
+		return m, nil
+	}
+
+	gen := gens[m.cursor]
+	m.status = ""
+
+	switch msg.String() {
+	case "ctrl+c", "esc":
+		m.result = nil
+		return m, tea.Quit
+
+	case "up", "k":
+		m.cursor = max(m.cursor-1, 0)
+
+	case "down", "j":
+		m.cursor = min(m.cursor+1, len(gens)-1)
+
+	case " ", "m":
+		if m.base == m.cursor {
+			m.base = -1
+		} else {
+			m.base = m.cursor
+		}
+
+	case "enter", "d":
+		if m.loading {
+			return m, nil
+		}
+
+		from := m.baseGeneration()
+		if from == nil || from == gen {
+			m.status = "Mark another generation with space to compare against"
+			return m, nil
+		}
+
+		m.loading = true
+		m.status = "Comparing generations..."
+		return m, m.diffCmd(from, gen)
+
+	case "p":
+		if m.browser.SetPinned != nil {
+			if err := m.browser.SetPinned(gen, !gen.Pinned); err != nil {
+				m.status = fmt.Sprintf("Could not pin generation: %s", err)
+				return m, nil
+			}
+		}
+		gen.Pinned = !gen.Pinned
+		if gen.Pinned {
+			delete(m.deleting, m.cursor)
+		}
+
+	case "x":
+		switch {
+		case gen.Current:
+			m.status = "The current generation can not be deleted"
+		case gen.Pinned:
+			m.status = "Pinned generations can not be deleted"
+		case m.deleting[m.cursor]:
+			delete(m.deleting, m.cursor)
+		default:
+			m.deleting[m.cursor] = true
+		}
+
+	case "r":
+		if gen.Current {
+			m.status = "This is already the current generation"
+			return m, nil
+		}
+
+		m.result = &BrowserResult{Activate: gen}
+		return m, tea.Quit
+
+	case "q":
+		m.result = &BrowserResult{}
+		for i, g := range gens {
+			if m.deleting[i] {
+				m.result.Delete = append(m.result.Delete, g)
+			}
+		}
+		return m, tea.Quit
+	}
+
+	// Keep the cursor visible
+	rows := m.visibleRows()
+	if m.cursor < m.offset {
+		m.offset = m.cursor
+	} else if m.cursor >= m.offset+rows {
+		m.offset = m.cursor - rows + 1
+	}
+
+	return m, nil
+}
+
+// Returns the generation to diff against, which is the marked
+// generation or the current generation.
+func (m browserModel) baseGeneration() *GenerationItem {
+	if m.base >= 0 {
+		return m.browser.Generations[m.base]
+	}
+	for _, gen := range m.browser.Generations {
+		if gen.Current {
+			return gen
+		}
+	}
+	return nil
+}
+
+func (m browserModel) visibleRows() int {
+	// Title, header, status and help take up the rest
+	return max(m.h-6, 3)
+}
+
+func (m browserModel) View() string {
+	if m.viewing {
+		footer := helpStyle.Render("↑/↓ scroll • w why is a package here • esc back")
+		switch {
+		case m.prompting:
+			footer = fmt.Sprintf("Why is this package here: %s%s", m.query, cursorStyle.Render("█"))
+		case m.status != "":
+			footer = m.status
+		}
+
+		return fmt.Sprintf("%s\n%s", m.viewport.View(), footer)
+	}
+
+	strb := &strings.Builder{}
+	fmt.Fprintf(strb, "%s\n", lipgloss.NewStyle().Bold(true).Render(m.browser.Title))
+
+	gens := m.browser.Generations
+	if len(gens) < 1 {
+		strb.WriteString("No generations found\n")
+		return strb.String()
+	}
+
+	headers := append(append([]string{"Generation", "Build date"}, m.browser.Headers...), "Closure size", "")
+	rows := [][]string{}
+	end := min(m.offset+m.visibleRows(), len(gens))
+	for i := m.offset; i < end; i++ {
+		gen := gens[i]
+
+		cursor := " "
+		if i == m.cursor {
+			cursor = cursorStyle.Render("›")
+		}
+		current := " "
+		if gen.Current {
+			current = currentStyle.Render("*")
+		}
+
+		flags := []string{}
+		if gen.Pinned {
+			flags = append(flags, pinnedStyle.Render("pinned"))
+		}
+		if m.deleting[i] {
+			flags = append(flags, deleteStyle.Render("delete"))
+		}
+		if m.base == i {
+			flags = append(flags, baseStyle.Render("compare"))
+		}
+
+		size, ok := m.sizes[i]
+		if !ok {
+			size = helpStyle.Render("…")
+		}
+
+		row := []string{
+			fmt.Sprintf("%s%s %s", cursor, current, strconv.Itoa(gen.ID)),
+			gen.BuildDate.Format(time.DateTime),
+		}
+		row = append(row, gen.Details...)
+		row = append(row, size, strings.Join(flags, " "))
+
+		rows = append(rows, row)
+	}
+	fmt.Fprintf(strb, "%s\n", util.RenderTable(headers, rows...))
+
+	if m.status != "" {
+		fmt.Fprintf(strb, "%s\n", m.status)
+	} else {
+		strb.WriteString("\n")
+	}
+
+	strb.WriteString(helpStyle.Render(
+		"↑/↓ move • space mark • enter diff • p pin • x delete • r activate • q apply & quit • esc cancel",
+	))
+
+	return strb.String()
+}
@@ -0,0 +1,45 @@
+package tui
+
+import (
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+func TestGenerationBrowserWhyPrompt(t *testing.T) {
+	to := &GenerationItem{ID: 42}
+	m := browserModel{
+		browser:  GenerationBrowser{Generations: []*GenerationItem{to}},
+		base:     -1,
+		viewing:  true,
+		diffTo:   to,
+		diffText: "Comparing changes (41 -> 42)\n",
+	}
+
+	keys := []tea.KeyMsg{
+		{Type: tea.KeyRunes, Runes: []rune("w")},
+		{Type: tea.KeyRunes, Runes: []rune("gzipp")},
+		{Type: tea.KeyBackspace},
+	}
+	for _, key := range keys {
+		model, _ := m.Update(key)
+		m = model.(browserModel)
+	}
+
+	if !m.prompting || m.query != "gzip" {
+		t.Fatalf("unexpected prompt state: %t \"%s\"", m.prompting, m.query)
+	}
+
+	model, cmd := m.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	m = model.(browserModel)
+	if m.prompting || !m.loading || cmd == nil {
+		t.Fatal("expected the package to be explained after enter")
+	}
+
+	// The explanation is expanded below the diff
+	model, _ = m.Update(whyMsg{text: "\nWhy gzip is in 42:\n"})
+	m = model.(browserModel)
+	if m.loading || m.diffText != "Comparing changes (41 -> 42)\n\nWhy gzip is in 42:\n" {
+		t.Errorf("unexpected diff text: \"%s\"", m.diffText)
+	}
+}
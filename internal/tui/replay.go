@@ -0,0 +1,30 @@
+package tui
+
+import (
+	"context"
+	"io"
+	"os"
+
+	"github.com/arnarg/nilla-utils/internal/nix"
+)
+
+// Replay feeds a progress stream recorded with nix.RecordProgress through
+// a reporter, sped up by a factor of speed (0 replays without delay).
+func Replay(ctx context.Context, path string, speed float64, reporter nix.ProgressReporter) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// Without timing the recording is replayed without delay
+	var timing io.Reader
+	if tf, err := os.Open(path + nix.TimingSuffix); err == nil {
+		defer tf.Close()
+		timing = tf
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	return reporter.Run(ctx, nix.NewProgressDecoder(nix.ReplayProgress(ctx, f, timing, speed)))
+}
@@ -0,0 +1,77 @@
+package tui
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/arnarg/nilla-utils/internal/nix"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+// Replays the recorded progress fixtures of the nix package through the build
+// reporter's model and compares its view after every event, and the final
+// error, to golden files.
+func TestBuildReporterGolden(t *testing.T) {
+	fixtures, err := filepath.Glob("../nix/testdata/*.progress")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, fixture := range fixtures {
+		t.Run(filepath.Base(fixture), func(t *testing.T) {
+			state := t.TempDir()
+			t.Setenv("XDG_STATE_HOME", state)
+
+			f, err := os.Open(fixture)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+
+			decoder := nix.NewProgressDecoder(nix.ReplayProgress(context.Background(), f, nil, 0))
+
+			var m tea.Model = initBuildModel(false)
+			out := &strings.Builder{}
+			for ev := range decoder.Events {
+				m, _ = m.Update(ev)
+				fmt.Fprintf(out, "--- %T\n%s", ev, m.View())
+			}
+
+			if err := m.(buildModel).error(); err != nil {
+				fmt.Fprintf(out, "--- error\n%s", RenderError(err))
+			}
+			m.(buildModel).logs.close()
+
+			// Build logs are written to a temporary directory
+			// with a name depending on the current time
+			result := strings.ReplaceAll(out.String(), state, "$XDG_STATE_HOME")
+			logs, _ := filepath.Glob(filepath.Join(logsDir(), "*"))
+			for _, dir := range logs {
+				result = strings.ReplaceAll(result, filepath.Base(dir), "RUN")
+			}
+
+			golden := filepath.Join("testdata", strings.TrimSuffix(filepath.Base(fixture), ".progress")+".golden")
+			if *update {
+				if err := os.WriteFile(golden, []byte(result), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			expected, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if result != string(expected) {
+				t.Errorf("reporter output does not match %s:\n%s", golden, result)
+			}
+		})
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"github.com/arnarg/nilla-utils/internal/nix"
 	"github.com/arnarg/nilla-utils/internal/util"
 	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
 )
 
 type tuiModel interface {
@@ -66,6 +67,31 @@ func runTUIModel(ctx context.Context, init tuiModel, decoder *nix.ProgressDecode
 	return m.(tuiModel).error()
 }
 
+// Prints a warning above the progress view.
+func printWarning(format string, args ...any) tea.Cmd {
+	return tea.Printf(
+		"%s %s",
+		lipgloss.NewStyle().
+			Bold(true).
+			Foreground(lipgloss.Color("11")).
+			SetString("warning:").
+			String(),
+		fmt.Sprintf(format, args...),
+	)
+}
+
+// Activities report their expected number of child activities
+// with ResultSetExpectedEvent, keyed by activity ID.
+type expectations map[int64]int64
+
+func (e expectations) total() int {
+	total := 0
+	for _, exp := range e {
+		total += int(exp)
+	}
+	return total
+}
+
 type progress struct {
 	done     int
 	expected int
@@ -1,6 +1,7 @@
 package util
 
 import (
+	"fmt"
 	"os"
 	"os/exec"
 	"os/user"
@@ -134,3 +135,15 @@ func levelStyle(level log.Level, color lipgloss.TerminalColor) lipgloss.Style {
 		MaxWidth(4).
 		Foreground(color)
 }
+
+// PrintSection prints a section header to stderr.
+func PrintSection(text string) {
+	fmt.Fprintf(os.Stderr, "\033[32m>\033[0m %s\n", text)
+}
+
+// ErrorLine returns the first line of an error, for errors
+// shown where there's no room for the full message.
+func ErrorLine(err error) string {
+	line, _, _ := strings.Cut(err.Error(), "\n")
+	return line
+}
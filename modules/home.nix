@@ -55,6 +55,38 @@ in {
             default.value = [];
           };
 
+          deployment = {
+            target = lib.options.create {
+              description = "The user and host to deploy the configuration to, for example `alice@10.0.0.5`. Deploys locally when null.";
+              type = lib.types.nullish lib.types.string;
+              default.value = null;
+            };
+
+            buildHost = lib.options.create {
+              description = "The host to build the configuration on. Builds locally when null.";
+              type = lib.types.nullish lib.types.string;
+              default.value = null;
+            };
+
+            tags = lib.options.create {
+              description = "Tags to select the configuration by.";
+              type = lib.types.list.of lib.types.string;
+              default.value = [];
+            };
+
+            confirm = lib.options.create {
+              description = "Whether to skip the confirmation prompt when deploying.";
+              type = lib.types.bool;
+              default.value = false;
+            };
+
+            flags = lib.options.create {
+              description = "Defaults of command line flags when deploying the system, by flag name. Supported flags are `no-link`, `out-link`, `verbose` and `specialisation`. Flags passed on the command line take precedence.";
+              type = lib.types.attrs.any;
+              default.value = {};
+            };
+          };
+
           result = lib.options.create {
             description = "The created home-manager system.";
             type = lib.types.raw;
@@ -93,6 +93,44 @@ in {
             default.value = [];
           };
 
+          deployment = {
+            target = lib.options.create {
+              description = "The host to deploy the system to, for example `root@10.0.0.5`. Deploys locally when null.";
+              type = lib.types.nullish lib.types.string;
+              default.value = null;
+            };
+
+            buildHost = lib.options.create {
+              description = "The host to build the system on. Builds locally when null.";
+              type = lib.types.nullish lib.types.string;
+              default.value = null;
+            };
+
+            elevation = lib.options.create {
+              description = "How to run privileged commands on the target, one of `sudo`, `doas` or `none`.";
+              type = lib.types.enum ["sudo" "doas" "none"];
+              default.value = "sudo";
+            };
+
+            tags = lib.options.create {
+              description = "Tags to select the system by.";
+              type = lib.types.list.of lib.types.string;
+              default.value = [];
+            };
+
+            confirm = lib.options.create {
+              description = "Whether to skip the confirmation prompt when deploying.";
+              type = lib.types.bool;
+              default.value = false;
+            };
+
+            flags = lib.options.create {
+              description = "Defaults of command line flags when deploying the system, by flag name. Supported flags are `no-link`, `out-link` and `verbose`. Flags passed on the command line take precedence.";
+              type = lib.types.attrs.any;
+              default.value = {};
+            };
+          };
+
           result = lib.options.create {
             description = "The created NixOS system.";
             type = lib.types.raw;
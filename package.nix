@@ -1,8 +1,6 @@
 {
   lib,
   buildGoApplication,
-  nvd,
-  makeWrapper,
 }: let
   version = "0.0.0-alpha.9";
 in
@@ -25,11 +23,4 @@ in
 
     subPackages = ["cmd/nilla-os" "cmd/nilla-home"];
     ldflags = ["-X main.version=${version}"];
-
-    nativeBuildInputs = [makeWrapper];
-
-    postInstall = ''
-      wrapProgram $out/bin/nilla-os --prefix PATH : ${lib.makeBinPath [nvd]}
-      wrapProgram $out/bin/nilla-home --prefix PATH : ${lib.makeBinPath [nvd]}
-    '';
   }